@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// What happens to a user's chirps when their scheduled deletion comes due (ACCOUNT_DELETION_CHIRPS):
+const (
+	// the account row is scrubbed of personal data and the chirps stay up as "Deleted user":
+	chirpPolicyAnonymize = "anonymize"
+	// the account is tombstoned, which hides its chirps, and the purge job later removes it all:
+	chirpPolicyDelete = "delete"
+)
+
+// processAccountDeletions carries out every account deletion whose grace period has run out. Each
+// user is handled in its own transaction so one failure doesn't block the rest:
+func (cfg *apiConfig) processAccountDeletions(ctx context.Context) error {
+	users, err := cfg.db.GetUsersDueForDeletion(ctx)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		// any data exports they made are copies of exactly what's being deleted:
+		exports, err := cfg.db.GetStoredDataExportsForUser(ctx, user.ID)
+		if err == nil {
+			err = cfg.deleteDataExportArchives(ctx, exports)
+		}
+		if err != nil {
+			log.Printf("Error deleting data exports of account %s: %s", user.ID, err)
+			continue
+		}
+
+		tx, err := cfg.dbConn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		qtx := cfg.db.WithTx(tx)
+
+		if cfg.accountDeletionChirps == chirpPolicyDelete {
+			_, err = qtx.SoftDeleteUser(ctx, user.ID)
+		} else {
+			err = qtx.AnonymizeUser(ctx, user.ID)
+			// signing in with their provider, or a recovery code, mustn't lead back to the
+			// anonymized account:
+			if err == nil {
+				err = qtx.DeleteUserIdentities(ctx, user.ID)
+			}
+			if err == nil {
+				err = qtx.DeleteRecoveryCodes(ctx, user.ID)
+			}
+		}
+		if err == nil {
+			// sessions were revoked when the deletion was scheduled, but logging in again would have
+			// cancelled it, so anything left must go now:
+			err = qtx.RevokeAllRefreshTokensForUser(ctx, user.ID)
+		}
+		if err == nil {
+			err = qtx.RevokeAllSessionsForUser(ctx, user.ID)
+		}
+		if err == nil {
+			err = qtx.RevokeAllAPIKeysForUser(ctx, user.ID)
+		}
+		if err == nil {
+			err = qtx.RevokeAllOAuthTokensForUser(ctx, user.ID)
+		}
+		// no actor and no request: Chirpy did this itself, when the grace period ran out:
+		if err == nil {
+			err = cfg.writeAuditEvent(ctx, qtx, nil, auditEvent{
+				Action:  auditUserDeleted,
+				Target:  auditTarget("user", user.ID),
+				Details: map[string]any{"chirps": cfg.accountDeletionChirps},
+			})
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Printf("Error deleting account %s: %s", user.ID, err)
+			continue
+		}
+		log.Printf("Deleted account %s (chirps: %s)", user.ID, cfg.accountDeletionChirps)
+	}
+	return nil
+}
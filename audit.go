@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/audit"
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/google/uuid"
+)
+
+// the actions recorded in the audit log:
+const (
+	auditImpersonationStart   = "impersonation.start"
+	auditImpersonationStop    = "impersonation.stop"
+	auditImpersonationRequest = "impersonation.request"
+	auditLogin                = "user.login"
+	auditLoginFailed          = "user.login_failed"
+	auditPasswordReset        = "user.password_reset"
+	auditProfileUpdate        = "user.profile_update"
+	auditDeletionScheduled    = "user.deletion_scheduled"
+	auditUserDeleted          = "user.deleted"
+	auditUserRestored         = "user.restored"
+	auditChirpDeleted         = "chirp.deleted"
+	auditChirpRestored        = "chirp.restored"
+	auditRoleGranted          = "role.granted"
+	auditRoleRevoked          = "role.revoked"
+	auditReset                = "admin.reset"
+)
+
+// the header a request ID arrives in (from a load balancer, say) and is echoed back in:
+const requestIDHeader = "X-Request-ID"
+
+// request IDs we're handed are only kept if they look like IDs, so nobody can stuff the log with
+// arbitrary text:
+var requestIDRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// how many events queueAudit holds before it makes callers write their own:
+const auditQueueSize = 1024
+
+// An auditEvent is one entry in the audit log. Actor and OnBehalfOf are uuid.Nil when they don't
+// apply (an event with no actor was done by Chirpy itself). Diff is what the action changed, made
+// with audit.Diff:
+type auditEvent struct {
+	Actor      uuid.UUID
+	OnBehalfOf uuid.UUID
+	Action     string
+	Target     string
+	Details    map[string]any
+	Diff       map[string]audit.Change
+	// where the request came from. writeAuditEvent fills these in from the request it's given;
+	// queueAudit sets them, since the request is over by the time the event is written:
+	IP        string
+	RequestID string
+}
+
+// auditTarget names the thing an event was about, e.g. "user:<id>":
+func auditTarget(kind string, id uuid.UUID) string {
+	return kind + ":" + id.String()
+}
+
+// auditActor is who to record as doing something with claims: the user, or, while impersonating,
+// the admin acting on their behalf:
+func auditActor(claims auth.Claims) (actor, onBehalfOf uuid.UUID) {
+	if claims.Actor != uuid.Nil {
+		return claims.Actor, claims.UserID
+	}
+	return claims.UserID, uuid.Nil
+}
+
+// writeAuditEvent adds e to the audit log using q, so it can be part of a transaction: an action
+// that has to be on record then only happens if the record is written. q must be in a transaction,
+// since the lock that keeps the hash chain in order lasts until it ends. r is nil for events from
+// background jobs:
+func (cfg *apiConfig) writeAuditEvent(ctx context.Context, q *database.Queries, r *http.Request, e auditEvent) error {
+	details := e.Details
+	if details == nil {
+		details = map[string]any{}
+	}
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	diff := e.Diff
+	if diff == nil {
+		diff = map[string]audit.Change{}
+	}
+	diffJSON, err := json.Marshal(diff)
+	if err != nil {
+		return err
+	}
+
+	entry := audit.Entry{
+		// Postgres keeps microseconds, so the hash has to be of the time as it'll be read back:
+		CreatedAt:  time.Now().UTC().Truncate(time.Microsecond),
+		Actor:      e.Actor,
+		OnBehalfOf: e.OnBehalfOf,
+		Action:     e.Action,
+		Target:     e.Target,
+		IP:         e.IP,
+		RequestID:  e.RequestID,
+		Details:    detailsJSON,
+		Diff:       diffJSON,
+	}
+	if r != nil {
+		entry.IP = clientIP(r)
+		entry.RequestID = requestIDFromContext(r.Context())
+	}
+
+	if err := q.LockAuditLog(ctx); err != nil {
+		return err
+	}
+	prev, err := q.GetLastAuditHash(ctx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	hash, err := audit.Hash(cfg.auditKey, prev, entry)
+	if err != nil {
+		return err
+	}
+	return q.CreateAuditEvent(ctx, database.CreateAuditEventParams{
+		CreatedAt:  entry.CreatedAt,
+		ActorID:    uuid.NullUUID{UUID: e.Actor, Valid: e.Actor != uuid.Nil},
+		OnBehalfOf: uuid.NullUUID{UUID: e.OnBehalfOf, Valid: e.OnBehalfOf != uuid.Nil},
+		Action:     e.Action,
+		Target:     e.Target,
+		Ip:         entry.IP,
+		Details:    detailsJSON,
+		RequestID:  entry.RequestID,
+		Diff:       diffJSON,
+		PrevHash:   prev,
+		Hash:       hash,
+	})
+}
+
+// recordAudit is writeAuditEvent for things that have already happened, where all we can do about a
+// failure is log it:
+func (cfg *apiConfig) recordAudit(r *http.Request, e auditEvent) {
+	if err := cfg.writeAuditEventTx(r.Context(), r, e); err != nil {
+		log.Printf("Error writing audit event %s: %s", e.Action, err)
+	}
+}
+
+// writeAuditEventTx is writeAuditEvent in a transaction of its own:
+func (cfg *apiConfig) writeAuditEventTx(ctx context.Context, r *http.Request, e auditEvent) error {
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := cfg.writeAuditEvent(ctx, cfg.db.WithTx(tx), r, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// auditEmailHash is what the audit log keeps instead of an email address. Entries can't be
+// changed or erased once written, so an address in one would outlive the account it belonged to;
+// the hash still shows which events involved the same address:
+func auditEmailHash(email string) string {
+	return auth.HashToken(strings.ToLower(strings.TrimSpace(email)))
+}
+
+// queueAudit is recordAudit for the login endpoints, which mustn't all wait their turn for the
+// audit log's lock: e is handed to runAuditQueue to write in the background. If the queue is full
+// the caller writes it after all, so a flood of logins slows down rather than losing events:
+func (cfg *apiConfig) queueAudit(r *http.Request, e auditEvent) {
+	e.IP = clientIP(r)
+	e.RequestID = requestIDFromContext(r.Context())
+	select {
+	case cfg.auditQueue <- e:
+	default:
+		cfg.recordAudit(r, e)
+	}
+}
+
+// runAuditQueue writes the events from queueAudit, as many as are waiting in one transaction, so
+// the lock is taken once per batch rather than once per login. If the batch can't be written, its
+// events are retried one at a time so one bad event doesn't take the rest with it. Events still
+// queued when the server stops are lost:
+func (cfg *apiConfig) runAuditQueue(ctx context.Context) {
+	for {
+		var batch []auditEvent
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-cfg.auditQueue:
+			batch = append(batch, e)
+		}
+	drain:
+		for len(batch) < auditQueueSize {
+			select {
+			case e := <-cfg.auditQueue:
+				batch = append(batch, e)
+			default:
+				break drain
+			}
+		}
+		if err := cfg.writeAuditBatch(ctx, batch); err != nil && len(batch) > 1 {
+			log.Printf("Error writing %d queued audit events, retrying one at a time: %s", len(batch), err)
+			for _, e := range batch {
+				if err := cfg.writeAuditBatch(ctx, []auditEvent{e}); err != nil {
+					log.Printf("Error writing queued audit event %s: %s", e.Action, err)
+				}
+			}
+		} else if err != nil {
+			log.Printf("Error writing queued audit event %s: %s", batch[0].Action, err)
+		}
+	}
+}
+
+// writeAuditBatch writes events in a transaction of their own:
+func (cfg *apiConfig) writeAuditBatch(ctx context.Context, events []auditEvent) error {
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+	for _, e := range events {
+		if err := cfg.writeAuditEvent(ctx, qtx, nil, e); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// auditEntryFromDB is the part of a stored event its hash covers:
+func auditEntryFromDB(e database.AuditEvent) audit.Entry {
+	return audit.Entry{
+		CreatedAt:  e.CreatedAt,
+		Actor:      e.ActorID.UUID,
+		OnBehalfOf: e.OnBehalfOf.UUID,
+		Action:     e.Action,
+		Target:     e.Target,
+		IP:         e.Ip,
+		RequestID:  e.RequestID,
+		Details:    e.Details,
+		Diff:       e.Diff,
+	}
+}
+
+type requestIDContextKey struct{}
+
+// middlewareRequestID gives every request an ID, so an audit entry can be matched up with the
+// logs of the request that made it. One passed in by a proxy in front of us is kept:
+func middlewareRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDRegexp.MatchString(id) {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't generate request ID", err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id)))
+	})
+}
+
+// requestIDFromContext is the ID middlewareRequestID gave the request, or "" outside a request:
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/oauth"
+	"github.com/craigbucher/learn-http-servers/internal/rbac"
+	"github.com/craigbucher/learn-http-servers/internal/scope"
+	"github.com/google/uuid"
+)
+
+// errInsufficientScope means the credential was valid but wasn't granted the scope the handler
+// needs:
+var errInsufficientScope = errors.New("credential lacks the required scope")
+
+// authenticate works out which user is making the request, from the access token in an
+// "Authorization: Bearer <token>" header, a personal API key in "Authorization: ApiKey <key>", or
+// (with no Authorization header) the web app's session cookie. Access tokens and sessions from
+// logging in can do anything; API keys and tokens from the OAuth server only work if they were
+// granted scope s. Handlers that act on behalf of a user call this first and pass any error to
+// respondWithAuthError:
+func (cfg *apiConfig) authenticate(r *http.Request, s scope.Scope) (uuid.UUID, error) {
+	claims, err := cfg.authenticateClaims(r, s)
+	return claims.UserID, err
+}
+
+// authenticateClaims is authenticate for handlers that also need the caller's roles. Roles only
+// come with access tokens and sessions from logging in; API keys and OAuth tokens act as a user
+// without any:
+func (cfg *apiConfig) authenticateClaims(r *http.Request, s scope.Scope) (auth.Claims, error) {
+	// middlewareCSRF has already checked the CSRF token of anything but a safe request:
+	if token, ok := sessionToken(r); ok {
+		return cfg.authenticateSession(r, token)
+	}
+	if key, err := auth.GetAPIKey(r.Header); err == nil {
+		userID, err := cfg.authenticateAPIKey(r, key, s)
+		return auth.Claims{UserID: userID}, err
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+	// third-party apps send the opaque tokens from our OAuth server, which are scoped like API keys:
+	if oauth.IsAccessToken(token) {
+		userID, err := cfg.authenticateOAuthToken(r, token, s)
+		return auth.Claims{UserID: userID}, err
+	}
+	claims, err := cfg.signingKeys.ParseJWT(token)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+	// an admin acting as the user (see handler_admin_impersonate.go):
+	if claims.Actor != uuid.Nil {
+		if err := cfg.checkImpersonation(r, claims, s == scope.Account); err != nil {
+			return auth.Claims{}, err
+		}
+	}
+	// a valid signature isn't enough on its own: the account may have been deleted, or the login
+	// ended, since the token was issued:
+	valid, err := cfg.db.AccessTokenStillValid(r.Context(), database.AccessTokenStillValidParams{
+		UserID:    claims.UserID,
+		SessionID: uuid.NullUUID{UUID: claims.SessionID, Valid: claims.SessionID != uuid.Nil},
+	})
+	if err != nil {
+		return auth.Claims{}, err
+	}
+	if !valid {
+		return auth.Claims{}, errors.New("access token has been revoked")
+	}
+	return claims, nil
+}
+
+// authenticateOAuthToken checks an access token issued to a third-party app:
+func (cfg *apiConfig) authenticateOAuthToken(r *http.Request, token string, s scope.Scope) (uuid.UUID, error) {
+	t, err := cfg.db.GetActiveOAuthToken(r.Context(), auth.HashToken(token))
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if t.Kind != oauthKindAccess {
+		return uuid.Nil, errors.New("not an access token")
+	}
+	if !scope.Contains(t.Scopes, s) {
+		return uuid.Nil, fmt.Errorf("%w %q", errInsufficientScope, s)
+	}
+	return t.UserID, nil
+}
+
+// authenticateAPIKey looks the key up by its hash and notes that it's been used:
+func (cfg *apiConfig) authenticateAPIKey(r *http.Request, key string, s scope.Scope) (uuid.UUID, error) {
+	apiKey, err := cfg.db.GetAPIKeyByHash(r.Context(), auth.HashToken(key))
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !scope.Contains(apiKey.Scopes, s) {
+		return uuid.Nil, fmt.Errorf("%w %q", errInsufficientScope, s)
+	}
+	// last-used tracking is only informational, so a failure here doesn't fail the request:
+	if err := cfg.db.TouchAPIKey(r.Context(), apiKey.ID); err != nil {
+		log.Printf("Couldn't update last use of API key %s: %s", apiKey.ID, err)
+	}
+	return apiKey.UserID, nil
+}
+
+// respondWithAuthError turns an error from authenticate into a response: 403 if the API key or
+// OAuth token just lacks the scope (or an admin tried something sensitive while impersonating), 401
+// for anything else:
+func respondWithAuthError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInsufficientScope) {
+		respondWithError(w, http.StatusForbidden, "Credential doesn't have the scope for this", err)
+		return
+	}
+	if errors.Is(err, errImpersonationForbidden) {
+		respondWithError(w, http.StatusForbidden, "That isn't allowed while impersonating a user", err)
+		return
+	}
+	respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+}
+
+// makeAccessToken issues an access token for userID with their current roles baked in. sessionID
+// is the login session it belongs to (uuid.Nil for refresh tokens from before sessions existed):
+func (cfg *apiConfig) makeAccessToken(ctx context.Context, userID, sessionID uuid.UUID) (string, error) {
+	roles, err := cfg.db.GetUserRoles(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	return cfg.signingKeys.MakeJWT(auth.Claims{
+		UserID:    userID,
+		Roles:     roles,
+		SessionID: sessionID,
+	}, accessTokenExpiry)
+}
+
+// claimsContextKey is where middlewareRequirePermission leaves the caller's claims for the handler:
+type claimsContextKey struct{}
+
+// middlewareRequirePermission only lets requests through if their access token carries a role that
+// grants perm: no token is a 401, a token without the permission a 403. The wrapped handler can get
+// the caller's claims with claimsFromContext:
+func (cfg *apiConfig) middlewareRequirePermission(perm rbac.Permission, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// admin work needs a real login; API keys and OAuth tokens can't be granted scope.Account:
+		claims, err := cfg.authenticateClaims(r, scope.Account)
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+		if !rbac.Has(claims.Roles, perm) {
+			respondWithError(w, http.StatusForbidden, "You don't have permission to do that", nil)
+			return
+		}
+		ctx := context.WithValue(r.Context(), claimsContextKey{}, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// claimsFromContext returns the claims middlewareRequirePermission checked, or zero claims if the
+// request didn't go through it:
+func claimsFromContext(ctx context.Context) auth.Claims {
+	claims, _ := ctx.Value(claimsContextKey{}).(auth.Claims)
+	return claims
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/craigbucher/learn-http-servers/internal/blobstore"
+)
+
+// newBlobStoreFromEnv picks where uploads are kept based on BLOB_STORE:
+//   - "local" (the default): files under MEDIA_DIR on this server's disk
+//   - "s3": a bucket on AWS S3 or any S3-compatible server, configured with the S3_* variables
+func newBlobStoreFromEnv() (blobstore.BlobStore, error) {
+	switch driver := os.Getenv("BLOB_STORE"); driver {
+	case "", "local":
+		mediaDir := os.Getenv("MEDIA_DIR")
+		if mediaDir == "" {
+			mediaDir = "uploads"
+		}
+		return blobstore.NewLocal(mediaDir)
+	case "s3":
+		region := os.Getenv("S3_REGION")
+		// S3_ENDPOINT only needs setting for non-AWS servers (e.g. http://localhost:9000 for MinIO):
+		endpoint := os.Getenv("S3_ENDPOINT")
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+		}
+		return blobstore.NewS3(blobstore.S3Config{
+			Endpoint:        endpoint,
+			Region:          region,
+			Bucket:          os.Getenv("S3_BUCKET"),
+			Prefix:          os.Getenv("S3_PREFIX"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle:       os.Getenv("S3_PATH_STYLE") == "true",
+		})
+	default:
+		return nil, fmt.Errorf("unknown BLOB_STORE %q (want \"local\" or \"s3\")", driver)
+	}
+}
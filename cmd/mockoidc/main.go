@@ -0,0 +1,45 @@
+// Command mockoidc runs a stand-in OpenID Connect provider for trying out "sign in with ..." locally.
+// It approves every login straight away as the user described by its flags:
+//
+//	go run ./cmd/mockoidc -email walt@example.com
+//
+// then start Chirpy with the OIDC_* variables it prints and visit /api/login/oidc.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/craigbucher/learn-http-servers/internal/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9090", "address to listen on")
+	clientID := flag.String("client-id", "chirpy", "client ID Chirpy must use")
+	clientSecret := flag.String("client-secret", "chirpy-secret", "client secret Chirpy must use")
+	subject := flag.String("subject", "mock-user-1", "subject (user ID at the provider) to log in as")
+	email := flag.String("email", "user@example.com", "email address to report")
+	emailVerified := flag.Bool("email-verified", true, "whether to report the email address as verified")
+	name := flag.String("name", "Mock User", "full name to report")
+	username := flag.String("username", "", "preferred_username to report")
+	flag.Parse()
+
+	issuer := "http://" + *addr
+	provider, err := oidctest.New(issuer, *clientID, *clientSecret)
+	if err != nil {
+		log.Fatalf("Error creating provider: %s", err)
+	}
+	provider.SetUser(oidctest.User{
+		Subject:           *subject,
+		Email:             *email,
+		EmailVerified:     *emailVerified,
+		Name:              *name,
+		PreferredUsername: *username,
+	})
+
+	fmt.Printf("OIDC_ISSUER=%s\nOIDC_CLIENT_ID=%s\nOIDC_CLIENT_SECRET=%s\n", issuer, *clientID, *clientSecret)
+	log.Printf("Mock OpenID Connect provider listening on %s", issuer)
+	log.Fatal(http.ListenAndServe(*addr, provider))
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/export"
+	"github.com/google/uuid"
+)
+
+// processDataExports builds every queued export, one at a time, then deletes archives whose
+// download window has closed:
+func (cfg *apiConfig) processDataExports(ctx context.Context) error {
+	for {
+		dbExport, err := cfg.db.ClaimDataExport(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := cfg.buildDataExport(ctx, dbExport); err != nil {
+			log.Printf("Error building data export %s: %s", dbExport.ID, err)
+			if err := cfg.db.FailDataExport(ctx, dbExport.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	expired, err := cfg.db.GetExpiredDataExports(ctx)
+	if err != nil {
+		return err
+	}
+	return cfg.deleteDataExportArchives(ctx, expired)
+}
+
+// deleteDataExportArchives removes ready exports' archives from the blob store and marks them
+// expired, so their download links stop working:
+func (cfg *apiConfig) deleteDataExportArchives(ctx context.Context, exports []database.DataExport) error {
+	for _, e := range exports {
+		if err := cfg.blobs.Delete(ctx, e.BlobKey.String); err != nil {
+			return err
+		}
+		if err := cfg.db.ExpireDataExport(ctx, e.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildDataExport writes the user's archive to a temporary file (so we know its size and never
+// hold it all in memory), then uploads it to the blob store:
+func (cfg *apiConfig) buildDataExport(ctx context.Context, dbExport database.DataExport) error {
+	archive, blobKeys, err := cfg.collectExportData(ctx, dbExport.UserID)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "chirpy-export-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	err = export.Write(tmp, archive, func(m export.Media) (io.ReadCloser, error) {
+		return cfg.blobs.Get(ctx, blobKeys[m.ID])
+	})
+	if err != nil {
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	blobKey := "exports/" + dbExport.ID.String() + ".zip"
+	if err := cfg.blobs.Put(ctx, blobKey, tmp, "application/zip"); err != nil {
+		return err
+	}
+	err = cfg.db.CompleteDataExport(ctx, database.CompleteDataExportParams{
+		ID:               dbExport.ID,
+		BlobKey:          sql.NullString{String: blobKey, Valid: true},
+		SizeBytes:        sql.NullInt64{Int64: size, Valid: true},
+		ExpiresInSeconds: cfg.dataExportRetention.Seconds(),
+	})
+	if err != nil {
+		cfg.blobs.Delete(ctx, blobKey)
+		return err
+	}
+	log.Printf("Built data export %s (%d bytes)", dbExport.ID, size)
+	return nil
+}
+
+// collectExportData maps the user's rows to the export package's types. It also returns each
+// media item's blob key, which the archive doesn't need to know about:
+func (cfg *apiConfig) collectExportData(ctx context.Context, userID uuid.UUID) (export.Archive, map[uuid.UUID]string, error) {
+	user, err := cfg.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return export.Archive{}, nil, err
+	}
+	dbChirps, err := cfg.db.GetChirpsForExport(ctx, userID)
+	if err != nil {
+		return export.Archive{}, nil, err
+	}
+	dbRevisions, err := cfg.db.GetChirpRevisionsForExport(ctx, userID)
+	if err != nil {
+		return export.Archive{}, nil, err
+	}
+	dbMedia, err := cfg.db.GetMediaForExport(ctx, userID)
+	if err != nil {
+		return export.Archive{}, nil, err
+	}
+
+	revisions := map[uuid.UUID][]export.Revision{}
+	for _, rev := range dbRevisions {
+		revisions[rev.ChirpID] = append(revisions[rev.ChirpID], export.Revision{
+			CreatedAt: rev.CreatedAt,
+			Body:      rev.Body,
+		})
+	}
+	media := make([]export.Media, 0, len(dbMedia))
+	mediaIDs := map[uuid.UUID][]uuid.UUID{}
+	blobKeys := map[uuid.UUID]string{}
+	for _, m := range dbMedia {
+		blobKeys[m.ID] = m.BlobKey
+		item := export.Media{
+			ID:          m.ID,
+			CreatedAt:   m.CreatedAt,
+			ContentType: m.ContentType,
+			Size:        m.SizeBytes,
+			Width:       m.Width,
+			Height:      m.Height,
+		}
+		if m.ChirpID.Valid {
+			item.ChirpID = &m.ChirpID.UUID
+			mediaIDs[m.ChirpID.UUID] = append(mediaIDs[m.ChirpID.UUID], m.ID)
+		}
+		media = append(media, item)
+	}
+	chirps := make([]export.Chirp, 0, len(dbChirps))
+	for _, c := range dbChirps {
+		chirp := export.Chirp{
+			ID:        c.ID,
+			CreatedAt: c.CreatedAt,
+			UpdatedAt: c.UpdatedAt,
+			Body:      c.Body,
+			Revisions: revisions[c.ID],
+			MediaIDs:  mediaIDs[c.ID],
+		}
+		if c.DeletedAt.Valid {
+			chirp.DeletedAt = &c.DeletedAt.Time
+		}
+		chirps = append(chirps, chirp)
+	}
+
+	return export.Archive{
+		GeneratedAt: time.Now(),
+		Profile: export.Profile{
+			ID:          user.ID,
+			CreatedAt:   user.CreatedAt,
+			UpdatedAt:   user.UpdatedAt,
+			Email:       user.Email,
+			Handle:      user.Handle,
+			DisplayName: user.DisplayName,
+			Bio:         user.Bio,
+			AvatarURL:   user.AvatarUrl,
+		},
+		Chirps: chirps,
+		Media:  media,
+	}, blobKeys, nil
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// isUniqueViolation reports whether err is Postgres rejecting a duplicate value for the named
+// unique constraint or index (error code 23505), so handlers can answer 409 instead of 500:
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
@@ -3,12 +3,17 @@ module github.com/craigbucher/learn-http-servers
 go 1.24.4
 
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/lib/pq v1.10.9 // indirect
-	golang.org/x/crypto v0.41.0 // indirect
+	github.com/golang-jwt/jwt/v5 v5.3.0
+	github.com/google/uuid v1.6.0
+	github.com/joho/godotenv v1.5.1
+	github.com/lib/pq v1.10.9
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/crypto v0.41.0
+	golang.org/x/image v0.25.0
 )
 
+require golang.org/x/sys v0.35.0 // indirect
+
 // The 'go.sum' file contains cryptographic checksums (hashes) for each version of each dependency your
 // project uses.
 
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/audit"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/google/uuid"
+)
+
+// how many entries the chain check reads from the database at a time:
+const auditVerifyBatchSize = 500
+
+// An AuditEvent is an entry in the audit log as admins see it:
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ActorID    *uuid.UUID      `json:"actor_id"`
+	OnBehalfOf *uuid.UUID      `json:"on_behalf_of"`
+	Action     string          `json:"action"`
+	Target     string          `json:"target"`
+	IP         string          `json:"ip"`
+	RequestID  string          `json:"request_id"`
+	Details    json.RawMessage `json:"details"`
+	Diff       json.RawMessage `json:"diff"`
+	Hash       string          `json:"hash"`
+}
+
+func auditEventFromDB(e database.AuditEvent) AuditEvent {
+	event := AuditEvent{
+		ID:        e.ID,
+		CreatedAt: e.CreatedAt,
+		Action:    e.Action,
+		Target:    e.Target,
+		IP:        e.Ip,
+		RequestID: e.RequestID,
+		Details:   e.Details,
+		Diff:      e.Diff,
+		Hash:      e.Hash.String,
+	}
+	// null rather than the zero UUID when nobody (or nobody else) was involved:
+	if e.ActorID.Valid {
+		event.ActorID = &e.ActorID.UUID
+	}
+	if e.OnBehalfOf.Valid {
+		event.OnBehalfOf = &e.OnBehalfOf.UUID
+	}
+	return event
+}
+
+// handles GET /admin/audit-events, newest first. Every filter is optional:
+//
+//	?actor_id=<uuid>  done by (or on behalf of) this user
+//	?action=user.login
+//	?target=user:<uuid>
+//	?since=<RFC 3339 time>&until=<RFC 3339 time>
+//
+// plus the usual ?limit= and ?offset=:
+func (cfg *apiConfig) handlerAdminAuditEventsList(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Events []AuditEvent `json:"events"`
+		Total  int64        `json:"total"`
+		Limit  int32        `json:"limit"`
+		Offset int32        `json:"offset"`
+	}
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	query := r.URL.Query()
+	filter := database.CountAuditEventsParams{}
+	if s := query.Get("actor_id"); s != "" {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid actor_id", err)
+			return
+		}
+		filter.ActorID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+	if s := query.Get("action"); s != "" {
+		filter.Action = sql.NullString{String: s, Valid: true}
+	}
+	if s := query.Get("target"); s != "" {
+		filter.Target = sql.NullString{String: s, Valid: true}
+	}
+	for _, t := range []struct {
+		param string
+		dest  *sql.NullTime
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	} {
+		s := query.Get(t.param)
+		if s == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, t.param+" must be an RFC 3339 time", err)
+			return
+		}
+		// created_at is stored in UTC without a time zone:
+		*t.dest = sql.NullTime{Time: parsed.UTC(), Valid: true}
+	}
+
+	dbEvents, err := cfg.db.ListAuditEvents(r.Context(), database.ListAuditEventsParams{
+		ActorID:    filter.ActorID,
+		Action:     filter.Action,
+		Target:     filter.Target,
+		Since:      filter.Since,
+		Until:      filter.Until,
+		PageLimit:  limit,
+		PageOffset: offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list audit events", err)
+		return
+	}
+	total, err := cfg.db.CountAuditEvents(r.Context(), filter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list audit events", err)
+		return
+	}
+
+	events := []AuditEvent{}
+	for _, e := range dbEvents {
+		events = append(events, auditEventFromDB(e))
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Events: events,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// handles GET /admin/audit-events/verify, walking the whole hash chain. OK is false if any entry
+// has been changed, removed or slipped in since it was written; FirstBadID is where the chain
+// breaks, and everything before it is known to be intact. LastID and LastHash are the last intact
+// entry. Entries from before the chain existed aren't checked:
+func (cfg *apiConfig) handlerAdminAuditEventsVerify(w http.ResponseWriter, r *http.Request) {
+	// the last good hash is worth writing down somewhere outside the database: a later check whose
+	// chain doesn't pass through it means entries have been cut off the end:
+	type response struct {
+		OK         bool   `json:"ok"`
+		Checked    int    `json:"checked"`
+		FirstBadID int64  `json:"first_bad_id,omitempty"`
+		LastID     int64  `json:"last_id,omitempty"`
+		LastHash   string `json:"last_hash,omitempty"`
+	}
+
+	prev := ""
+	afterID := int64(0)
+	lastID := int64(0)
+	checked := 0
+	for {
+		dbEvents, err := cfg.db.ListAuditChain(r.Context(), database.ListAuditChainParams{
+			AfterID:   afterID,
+			PageLimit: auditVerifyBatchSize,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't verify audit log", err)
+			return
+		}
+		if len(dbEvents) == 0 {
+			break
+		}
+
+		links := make([]audit.Link, 0, len(dbEvents))
+		for _, e := range dbEvents {
+			links = append(links, audit.Link{
+				ID:       e.ID,
+				PrevHash: e.PrevHash.String,
+				Hash:     e.Hash.String,
+				Entry:    auditEntryFromDB(e),
+			})
+		}
+		badID, last, err := audit.Verify(cfg.auditKey, prev, links)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't verify audit log", err)
+			return
+		}
+		if badID != 0 {
+			for _, l := range links {
+				if l.ID == badID {
+					break
+				}
+				checked++
+				lastID = l.ID
+			}
+			respondWithJSON(w, http.StatusOK, response{OK: false, Checked: checked, FirstBadID: badID, LastID: lastID, LastHash: last})
+			return
+		}
+		checked += len(links)
+		prev = last
+		afterID = links[len(links)-1].ID
+		lastID = afterID
+	}
+
+	respondWithJSON(w, http.StatusOK, response{OK: true, Checked: checked, LastID: lastID, LastHash: prev})
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/oauth"
+	"github.com/google/uuid"
+)
+
+const (
+	// impersonation is for reproducing a problem, not for living in someone else's account:
+	defaultImpersonationMinutes = 15
+	maxImpersonationMinutes     = 60
+	maxImpersonationReason      = 500
+)
+
+// errImpersonationForbidden means an impersonation token was used for something only the user
+// themselves may do, like changing their email or password, or managing their sessions and keys:
+var errImpersonationForbidden = errors.New("not allowed while impersonating")
+
+// handles POST /admin/users/{userID}/impersonate with the reason (which is recorded) and,
+// optionally, how many minutes the token should last. The token acts as the user, carrying the
+// admin's ID in its "act" claim, and can't do anything that needs scope.Account:
+func (cfg *apiConfig) handlerAdminImpersonationStart(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason  string `json:"reason"`
+		Minutes int    `json:"minutes"`
+	}
+	type response struct {
+		ImpersonationID uuid.UUID `json:"impersonation_id"`
+		Token           string    `json:"token"`
+		ExpiresAt       time.Time `json:"expires_at"`
+	}
+
+	admin := claimsFromContext(r.Context())
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	reason := strings.TrimSpace(params.Reason)
+	if reason == "" || utf8.RuneCountInString(reason) > maxImpersonationReason {
+		respondWithError(w, http.StatusBadRequest, "A reason of up to 500 characters is required", nil)
+		return
+	}
+	minutes := params.Minutes
+	if minutes == 0 {
+		minutes = defaultImpersonationMinutes
+	}
+	if minutes < 1 || minutes > maxImpersonationMinutes {
+		respondWithError(w, http.StatusBadRequest, "Minutes must be between 1 and 60", nil)
+		return
+	}
+	duration := time.Duration(minutes) * time.Minute
+
+	if userID == admin.UserID {
+		respondWithError(w, http.StatusBadRequest, "You can't impersonate yourself", nil)
+		return
+	}
+	if _, err := cfg.db.GetUserByID(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	// staff accounts are off limits, so impersonation can't be used to borrow someone's role or
+	// to act under another admin's name:
+	roles, err := cfg.db.GetUserRoles(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start impersonation", err)
+		return
+	}
+	if len(roles) > 0 {
+		respondWithError(w, http.StatusForbidden, "Users with roles can't be impersonated", nil)
+		return
+	}
+
+	// the impersonation only starts if it's on record:
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start impersonation", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	impersonation, err := qtx.CreateImpersonation(r.Context(), database.CreateImpersonationParams{
+		AdminID:          admin.UserID,
+		UserID:           userID,
+		Reason:           reason,
+		ExpiresInSeconds: duration.Seconds(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start impersonation", err)
+		return
+	}
+	err = cfg.writeAuditEvent(r.Context(), qtx, r, auditEvent{
+		Actor:      admin.UserID,
+		OnBehalfOf: userID,
+		Action:     auditImpersonationStart,
+		Target:     auditTarget("user", userID),
+		Details: map[string]any{
+			"impersonation_id": impersonation.ID,
+			"reason":           reason,
+			"expires_at":       impersonation.ExpiresAt,
+		},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start impersonation", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start impersonation", err)
+		return
+	}
+
+	// no roles: whatever the admin can do, the token can only do what the user could:
+	token, err := cfg.signingKeys.MakeJWT(auth.Claims{
+		UserID:  userID,
+		Actor:   admin.UserID,
+		TokenID: impersonation.ID,
+	}, duration)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create impersonation token", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, response{
+		ImpersonationID: impersonation.ID,
+		Token:           token,
+		ExpiresAt:       impersonation.ExpiresAt,
+	})
+}
+
+// handles DELETE /admin/impersonations/{impersonationID}, ending an impersonation before its token
+// expires. The token stops working straight away:
+func (cfg *apiConfig) handlerAdminImpersonationStop(w http.ResponseWriter, r *http.Request) {
+	admin := claimsFromContext(r.Context())
+	impersonationID, err := uuid.Parse(r.PathValue("impersonationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid impersonation ID", err)
+		return
+	}
+
+	impersonation, err := cfg.db.EndImpersonation(r.Context(), impersonationID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find an active impersonation", err)
+		return
+	}
+	cfg.recordAudit(r, auditEvent{
+		Actor:      admin.UserID,
+		OnBehalfOf: impersonation.UserID,
+		Action:     auditImpersonationStop,
+		Target:     auditTarget("user", impersonation.UserID),
+		Details: map[string]any{
+			"impersonation_id": impersonation.ID,
+			"started_by":       impersonation.AdminID,
+		},
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkImpersonation is the extra check for a token with an "act" claim: the impersonation it
+// belongs to must still be going, and it can't be used for anything that needs scope.Account:
+func (cfg *apiConfig) checkImpersonation(r *http.Request, claims auth.Claims, needsAccount bool) error {
+	impersonation, err := cfg.db.GetActiveImpersonation(r.Context(), claims.TokenID)
+	if err != nil {
+		return err
+	}
+	if impersonation.UserID != claims.UserID || impersonation.AdminID != claims.Actor {
+		return errors.New("token doesn't match its impersonation")
+	}
+	if needsAccount {
+		return errImpersonationForbidden
+	}
+	return nil
+}
+
+// statusRecorder remembers the status code a handler responded with:
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the real ResponseWriter:
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// middlewareAuditImpersonation records every request made with an impersonation token that could
+// change something, along with how it turned out:
+func (cfg *apiConfig) middlewareAuditImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil || oauth.IsAccessToken(token) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		claims, err := cfg.signingKeys.ParseJWT(token)
+		if err != nil || claims.Actor == uuid.Nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		cfg.recordAudit(r, auditEvent{
+			Actor:      claims.Actor,
+			OnBehalfOf: claims.UserID,
+			Action:     auditImpersonationRequest,
+			Target:     auditTarget("user", claims.UserID),
+			Details: map[string]any{
+				"impersonation_id": claims.TokenID,
+				"method":           r.Method,
+				"path":             r.URL.Path,
+				"status":           rec.status,
+			},
+		})
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// The handlers in this file sit behind middlewareRequirePermission in main.go, so by the time they
+// run the caller is known to be allowed to use them.
+
+// handles DELETE /admin/users/{userID}, tombstoning the account (and, with it, hiding its chirps):
+func (cfg *apiConfig) handlerAdminUsersDelete(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	rows, err := cfg.db.SoftDeleteUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete user", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", nil)
+		return
+	}
+	actor, onBehalfOf := auditActor(claimsFromContext(r.Context()))
+	cfg.recordAudit(r, auditEvent{
+		Actor:      actor,
+		OnBehalfOf: onBehalfOf,
+		Action:     auditUserDeleted,
+		Target:     auditTarget("user", userID),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handles POST /admin/users/{userID}/restore
+func (cfg *apiConfig) handlerAdminUsersRestore(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	// RestoreUser only matches tombstoned rows, so "no rows" means there's nothing to restore
+	// (never existed, not deleted, or already purged):
+	user, err := cfg.db.RestoreUser(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "No deleted user with that ID", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore user", err)
+		return
+	}
+	actor, onBehalfOf := auditActor(claimsFromContext(r.Context()))
+	cfg.recordAudit(r, auditEvent{
+		Actor:      actor,
+		OnBehalfOf: onBehalfOf,
+		Action:     auditUserRestored,
+		Target:     auditTarget("user", userID),
+	})
+	respondWithJSON(w, http.StatusOK, userFromDB(user))
+}
+
+// handles POST /admin/chirps/{chirpID}/restore
+func (cfg *apiConfig) handlerAdminChirpsRestore(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	dbChirp, err := cfg.db.RestoreChirp(r.Context(), chirpID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "No deleted chirp with that ID", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore chirp", err)
+		return
+	}
+	actor, onBehalfOf := auditActor(claimsFromContext(r.Context()))
+	cfg.recordAudit(r, auditEvent{
+		Actor:      actor,
+		OnBehalfOf: onBehalfOf,
+		Action:     auditChirpRestored,
+		Target:     auditTarget("chirp", chirpID),
+		Details:    map[string]any{"author_id": dbChirp.UserID},
+	})
+	respondWithJSON(w, http.StatusOK, Chirp{
+		ID:        dbChirp.ID,
+		CreatedAt: dbChirp.CreatedAt,
+		UpdatedAt: dbChirp.UpdatedAt,
+		UserID:    dbChirp.UserID,
+		Body:      dbChirp.Body,
+		Edited:    dbChirp.Edited,
+	})
+}
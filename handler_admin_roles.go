@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/craigbucher/learn-http-servers/internal/audit"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/rbac"
+	"github.com/google/uuid"
+)
+
+// handles GET /admin/users/{userID}/roles, showing a user's roles and what they add up to:
+func (cfg *apiConfig) handlerAdminRolesGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Roles       []string          `json:"roles"`
+		Permissions []rbac.Permission `json:"permissions"`
+	}
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	if _, err := cfg.db.GetUserByID(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+
+	roles, err := cfg.db.GetUserRoles(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get roles", err)
+		return
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Roles:       roles,
+		Permissions: rbac.Permissions(roles),
+	})
+}
+
+// handles PUT /admin/users/{userID}/roles/{role}. The user's tokens pick the new role up the next
+// time they log in or refresh:
+func (cfg *apiConfig) handlerAdminRolesGrant(w http.ResponseWriter, r *http.Request) {
+	userID, role, ok := parseRolePath(w, r)
+	if !ok {
+		return
+	}
+	if _, err := cfg.db.GetUserByID(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	before, err := cfg.db.GetUserRoles(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't grant role", err)
+		return
+	}
+
+	err = cfg.db.GrantRole(r.Context(), database.GrantRoleParams{
+		UserID:    userID,
+		Role:      string(role),
+		GrantedBy: uuid.NullUUID{UUID: claimsFromContext(r.Context()).UserID, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't grant role", err)
+		return
+	}
+	cfg.recordAudit(r, roleChangeEvent(r, auditRoleGranted, userID, role, before))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handles DELETE /admin/users/{userID}/roles/{role}. Access tokens already issued keep the role
+// until they expire (at most accessTokenExpiry):
+func (cfg *apiConfig) handlerAdminRolesRevoke(w http.ResponseWriter, r *http.Request) {
+	userID, role, ok := parseRolePath(w, r)
+	if !ok {
+		return
+	}
+
+	// do the check and the delete in one transaction, so two admins demoting each other at the same
+	// time can't leave nobody in charge:
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke role", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+	if role == rbac.Admin {
+		// this lock conflicts with itself, so concurrent admin revocations wait their turn:
+		if _, err := tx.ExecContext(r.Context(), "LOCK TABLE user_roles IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke role", err)
+			return
+		}
+	}
+
+	before, err := qtx.GetUserRoles(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke role", err)
+		return
+	}
+	rows, err := qtx.RevokeRole(r.Context(), database.RevokeRoleParams{
+		UserID: userID,
+		Role:   string(role),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke role", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "User doesn't have that role", nil)
+		return
+	}
+	if role == rbac.Admin {
+		admins, err := qtx.CountUsersWithRole(r.Context(), string(rbac.Admin))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke role", err)
+			return
+		}
+		if admins == 0 {
+			respondWithError(w, http.StatusConflict, "Can't remove the last admin", nil)
+			return
+		}
+	}
+	err = cfg.writeAuditEvent(r.Context(), qtx, r, roleChangeEvent(r, auditRoleRevoked, userID, role, before))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke role", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke role", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// roleChangeEvent is the audit event for granting or revoking role, given the user's roles before:
+func roleChangeEvent(r *http.Request, action string, userID uuid.UUID, role rbac.Role, before []string) auditEvent {
+	after := slices.DeleteFunc(slices.Clone(before), func(s string) bool { return s == string(role) })
+	if action == auditRoleGranted {
+		after = append(after, string(role))
+		slices.Sort(after)
+	}
+	actor, onBehalfOf := auditActor(claimsFromContext(r.Context()))
+	return auditEvent{
+		Actor:      actor,
+		OnBehalfOf: onBehalfOf,
+		Action:     action,
+		Target:     auditTarget("user", userID),
+		Details:    map[string]any{"role": role},
+		Diff: audit.Diff(
+			map[string]any{"roles": append([]string{}, before...)},
+			map[string]any{"roles": after},
+		),
+	}
+}
+
+// parseRolePath reads {userID} and {role} from the URL, responding 400 if either is invalid:
+func parseRolePath(w http.ResponseWriter, r *http.Request) (uuid.UUID, rbac.Role, bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return uuid.Nil, "", false
+	}
+	role, err := rbac.ParseRole(r.PathValue("role"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unknown role", err)
+		return uuid.Nil, "", false
+	}
+	return userID, role, true
+}
+
+// grantStartupAdmins makes every account in ADMIN_EMAILS (comma-separated) an admin, which is how
+// the first admin gets their role. It only does anything while there are no admins at all, so an
+// admin whose role was revoked doesn't get it back on the next restart. Accounts that don't exist
+// yet, or haven't verified their email (anyone could have signed up with it), are skipped with a
+// warning; they're picked up on a later restart:
+func (cfg *apiConfig) grantStartupAdmins(ctx context.Context, emails string) {
+	admins, err := cfg.db.CountUsersWithRole(ctx, string(rbac.Admin))
+	if err != nil {
+		log.Printf("ADMIN_EMAILS: couldn't count admins: %s", err)
+		return
+	}
+	if admins > 0 {
+		return
+	}
+
+	for _, email := range strings.Split(emails, ",") {
+		email = strings.TrimSpace(email)
+		if email == "" {
+			continue
+		}
+		user, err := cfg.db.GetUserByEmail(ctx, email)
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("ADMIN_EMAILS: no account for %s yet", email)
+			continue
+		}
+		if err != nil {
+			log.Printf("Couldn't make %s an admin: %s", email, err)
+			continue
+		}
+		if !user.EmailVerifiedAt.Valid {
+			log.Printf("ADMIN_EMAILS: %s hasn't verified their email yet", email)
+			continue
+		}
+		err = cfg.db.GrantRole(ctx, database.GrantRoleParams{
+			UserID: user.ID,
+			Role:   string(rbac.Admin),
+		})
+		if err != nil {
+			log.Printf("Couldn't make %s an admin: %s", email, err)
+		}
+	}
+}
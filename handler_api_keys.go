@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/scope"
+	"github.com/google/uuid"
+)
+
+// how long a key's name can be:
+const maxAPIKeyNameLength = 100
+
+// the longest expiry a key can be given, ten years (it can also have none at all):
+const maxAPIKeyExpiryDays = 3650
+
+// API shape of a personal API key. The key itself is only ever shown once, when it's created:
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func apiKeyFromDB(k database.ApiKey) APIKey {
+	var expiresAt, lastUsedAt *time.Time
+	if k.ExpiresAt.Valid {
+		expiresAt = &k.ExpiresAt.Time
+	}
+	if k.LastUsedAt.Valid {
+		lastUsedAt = &k.LastUsedAt.Time
+	}
+	return APIKey{
+		ID:         k.ID,
+		CreatedAt:  k.CreatedAt,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.Scopes,
+		ExpiresAt:  expiresAt,
+		LastUsedAt: lastUsedAt,
+	}
+}
+
+// handles GET /api/users/me/api-keys. Managing keys needs a real login, so one leaked key can't be
+// used to mint more:
+func (cfg *apiConfig) handlerAPIKeysList(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scope.Account)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	dbKeys, err := cfg.db.ListAPIKeys(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API keys", err)
+		return
+	}
+	keys := make([]APIKey, 0, len(dbKeys))
+	for _, k := range dbKeys {
+		keys = append(keys, apiKeyFromDB(k))
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+// handles POST /api/users/me/api-keys with a name, the scopes the key may use and, optionally, how
+// many days it should last. The response is the only time the key is ever shown:
+func (cfg *apiConfig) handlerAPIKeysCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays *int     `json:"expires_in_days"`
+	}
+	type response struct {
+		APIKey
+		Key string `json:"key"`
+	}
+
+	userID, err := cfg.authenticate(r, scope.Account)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	name := strings.TrimSpace(params.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxAPIKeyNameLength {
+		respondWithError(w, http.StatusBadRequest, "Name must be 1-100 characters", nil)
+		return
+	}
+	scopes, err := scope.Parse(params.Scopes)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if len(scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one scope is required", nil)
+		return
+	}
+	// no expiry means the key lasts until it's revoked:
+	expiresIn := sql.NullFloat64{}
+	if params.ExpiresInDays != nil {
+		if *params.ExpiresInDays < 1 || *params.ExpiresInDays > maxAPIKeyExpiryDays {
+			respondWithError(w, http.StatusBadRequest, "expires_in_days must be between 1 and 3650", nil)
+			return
+		}
+		expiresIn = sql.NullFloat64{
+			Float64: (time.Duration(*params.ExpiresInDays) * 24 * time.Hour).Seconds(),
+			Valid:   true,
+		}
+	}
+
+	key, prefix, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+	dbKey, err := cfg.db.CreateAPIKey(r.Context(), database.CreateAPIKeyParams{
+		UserID:           userID,
+		Name:             name,
+		Prefix:           prefix,
+		KeyHash:          auth.HashToken(key),
+		Scopes:           scope.Strings(scopes),
+		ExpiresInSeconds: expiresIn,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		APIKey: apiKeyFromDB(dbKey),
+		Key:    key,
+	})
+}
+
+// handles DELETE /api/users/me/api-keys/{keyID}. Revoked keys stop working straight away:
+func (cfg *apiConfig) handlerAPIKeysRevoke(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scope.Account)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID", err)
+		return
+	}
+
+	// the user ID is part of the WHERE clause, so someone else's key looks the same as a missing one:
+	rows, err := cfg.db.RevokeAPIKey(r.Context(), database.RevokeAPIKeyParams{
+		ID:     keyID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't find API key", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
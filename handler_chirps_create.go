@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/scope"
 	"github.com/google/uuid"
 )
 
@@ -17,45 +18,125 @@ type Chirp struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	UserID    uuid.UUID `json:"user_id"`
 	Body      string    `json:"body"`
+	Edited    bool      `json:"edited"`
+	Media     []Media   `json:"media,omitempty"`
 }
 
 func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request) {
 	// define the shape of your incoming JSON; The json:"body" tag tells Go how to map the JSON field 
 	// to the struct field:
 	type parameters struct {
-		Body   string    `json:"body"`
+		Body     string      `json:"body"`
+		MediaIDs []uuid.UUID `json:"media_ids"`
+		// clients written before access tokens still send the author's ID. It's optional now, but
+		// if it's there it has to agree with the token:
 		UserID uuid.UUID `json:"user_id"`
 	}
 
+	// the author is whoever the access token belongs to:
+	userID, err := cfg.authenticate(r, scope.ChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	// with REQUIRE_VERIFIED_EMAIL on, only users who've confirmed their address can post:
+	if cfg.requireVerifiedEmail {
+		user, err := cfg.db.GetUserByID(r.Context(), userID)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find user", err)
+			return
+		}
+		if !user.EmailVerifiedAt.Valid {
+			respondWithError(w, http.StatusForbidden, "Verify your email address before chirping", nil)
+			return
+		}
+	}
+
 	// create a decoder that reads from the request body:
 	decoder := json.NewDecoder(r.Body)
 	// create an empty parameters struct:
 	params := parameters{}
 	// fill it with the JSON data; The &params passes a pointer so the decoder can modify the struct:
-	err := decoder.Decode(&params)
+	err = decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
+	if params.UserID != uuid.Nil && params.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "user_id doesn't match the access token", nil)
+		return
+	}
 	// Call the 'validateChirp' method on the parameter/chirp body:
 	cleaned, err := validateChirp(params.Body)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
+	// drop duplicate media IDs so they can't be miscounted below:
+	mediaIDs := []uuid.UUID{}
+	seenMedia := map[uuid.UUID]struct{}{}
+	for _, id := range params.MediaIDs {
+		if _, ok := seenMedia[id]; !ok {
+			seenMedia[id] = struct{}{}
+			mediaIDs = append(mediaIDs, id)
+		}
+	}
+	if len(mediaIDs) > maxMediaPerChirp {
+		respondWithError(w, http.StatusBadRequest, "Too many media attachments", nil)
+		return
+	}
+
+	// creating the chirp and attaching its media happen in one transaction, so a chirp is never 
+	// saved with only some of its images:
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp", err)
+		return
+	}
+	// Rollback is a no-op once Commit has succeeded:
+	defer tx.Rollback()
+	// WithTx returns a copy of our queries that runs inside the transaction:
+	qtx := cfg.db.WithTx(tx)
 
 	// Create a chirp in the database and handle any errors:
 	// cfg.db.CreateChirp = call the DB method 'CreateChirp' (from chirps.sql, created by sqlc) to insert a row:
 	// database.CreateChirpParams = parameters to insert into the database:
-	chirp, err := cfg.db.CreateChirp(r.Context(), database.CreateChirpParams{
+	chirp, err := qtx.CreateChirp(r.Context(), database.CreateChirpParams{
 		Body:   cleaned,		// validated/sanitized chirp body
-		UserID: params.UserID,	// the author’s UUID
+		UserID: userID,			// the author’s UUID
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp", err)
 		return
 	}
 
+	media := []Media{}
+	if len(mediaIDs) > 0 {
+		// only the author's own, still-unattached uploads are claimed:
+		dbMedia, err := qtx.AttachMediaToChirp(r.Context(), database.AttachMediaToChirpParams{
+			ChirpID: uuid.NullUUID{UUID: chirp.ID, Valid: true},
+			Ids:     mediaIDs,
+			UserID:  userID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't attach media", err)
+			return
+		}
+		if len(dbMedia) != len(mediaIDs) {
+			respondWithError(w, http.StatusBadRequest, "Unknown or already attached media", nil)
+			return
+		}
+		for _, m := range dbMedia {
+			media = append(media, mediaFromDB(m))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp", err)
+		return
+	}
+
 	// call the 'respondWithJason' method from json.go:
 	respondWithJSON(w, http.StatusCreated, Chirp{
 		ID:        chirp.ID,
@@ -63,6 +144,8 @@ func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request
 		UpdatedAt: chirp.UpdatedAt,
 		Body:      chirp.Body,
 		UserID:    chirp.UserID,
+		Edited:    chirp.Edited,
+		Media:     media,
 	})
 }
 
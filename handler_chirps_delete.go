@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/craigbucher/learn-http-servers/internal/rbac"
+	"github.com/craigbucher/learn-http-servers/internal/scope"
+	"github.com/google/uuid"
+)
+
+// handles DELETE /api/chirps/{chirpID}. The chirp is only tombstoned: it disappears from every read
+// straight away, but an admin can restore it until the purge job removes it for good:
+func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticateClaims(r, scope.ChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	dbChirp, err := cfg.db.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find chirp", err)
+		return
+	}
+	// only the author, or a moderator, may delete a chirp:
+	if dbChirp.UserID != claims.UserID && !rbac.Has(claims.Roles, rbac.ModerateChirps) {
+		respondWithError(w, http.StatusForbidden, "You can't delete this chirp", nil)
+		return
+	}
+
+	_, err = cfg.db.SoftDeleteChirp(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
+		return
+	}
+	actor, onBehalfOf := auditActor(claims)
+	cfg.recordAudit(r, auditEvent{
+		Actor:      actor,
+		OnBehalfOf: onBehalfOf,
+		Action:     auditChirpDeleted,
+		Target:     auditTarget("chirp", chirpID),
+		// a moderator deleting someone else's chirp is the case worth being able to find:
+		Details: map[string]any{"author_id": dbChirp.UserID},
+	})
+
+	// 204 No Content: success, and nothing to send back:
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -27,14 +27,20 @@ func (cfg *apiConfig) handlerChirpsGet(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Create a new value with fields copied from dbChirp:
-	// Serializes that value to JSON, sets status 200, writes to the ResponseWriter:
-	respondWithJSON(w, http.StatusOK, Chirp{
+	chirps := []Chirp{{
 		ID:        dbChirp.ID,
 		CreatedAt: dbChirp.CreatedAt,
 		UpdatedAt: dbChirp.UpdatedAt,
 		UserID:    dbChirp.UserID,
 		Body:      dbChirp.Body,
-	})
+		Edited:    dbChirp.Edited,
+	}}
+	if err := cfg.loadChirpMedia(r.Context(), chirps); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp media", err)
+		return
+	}
+	// Serializes that value to JSON, sets status 200, writes to the ResponseWriter:
+	respondWithJSON(w, http.StatusOK, chirps[0])
 }
 
 func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Request) {
@@ -58,9 +64,16 @@ func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Reque
 			UpdatedAt: dbChirp.UpdatedAt,
 			UserID:    dbChirp.UserID,
 			Body:      dbChirp.Body,
+			Edited:    dbChirp.Edited,
 		})
 	}
 
+	// look up the media for every chirp at once instead of one query per chirp:
+	if err := cfg.loadChirpMedia(r.Context(), chirps); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirp media", err)
+		return
+	}
+
 	// write: a successful JSON HTTP response:
 	respondWithJSON(w, http.StatusOK, chirps)
 }
\ No newline at end of file
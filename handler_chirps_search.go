@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/craigbucher/learn-http-servers/internal/database"
+)
+
+// ts_headline doesn't HTML-escape the chirp body, so we have Postgres wrap matches in private-use
+// characters, escape the whole snippet, and only then swap the markers for real <mark> tags.
+// Nothing stops a client sending those characters, so they're stripped from the body before
+// highlighting and from the query before searching:
+const (
+	headlineStartSel = "\uE000"
+	headlineStopSel  = "\uE001"
+)
+
+// stripHeadlineMarkers removes any headline marker characters from s:
+func stripHeadlineMarkers(s string) string {
+	return strings.NewReplacer(headlineStartSel, "", headlineStopSel, "").Replace(s)
+}
+
+// A search hit is a normal Chirp plus how well it matched and a highlighted excerpt of the body:
+type ChirpSearchResult struct {
+	Chirp
+	Rank    float32 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// handles GET /api/search/chirps?q=...&limit=...&offset=...
+func (cfg *apiConfig) handlerChirpsSearch(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Results []ChirpSearchResult `json:"results"`
+		Total   int64               `json:"total"`
+		Limit   int32               `json:"limit"`
+		Offset  int32               `json:"offset"`
+	}
+
+	q := strings.TrimSpace(stripHeadlineMarkers(r.URL.Query().Get("q")))
+	if q == "" {
+		respondWithError(w, http.StatusBadRequest, "Search query q is required", nil)
+		return
+	}
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	// split the raw query into the part websearch_to_tsquery can handle and the prefix terms:
+	webQuery, prefixQuery := buildSearchQuery(q)
+
+	dbResults, err := cfg.db.SearchChirps(r.Context(), database.SearchChirpsParams{
+		Query:           webQuery,
+		PrefixQuery:     prefixQuery,
+		StripChars:      headlineStartSel + headlineStopSel,
+		HeadlineOptions: fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=25, MinWords=10, MaxFragments=2", headlineStartSel, headlineStopSel),
+		PageLimit:       limit,
+		PageOffset:      offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps", err)
+		return
+	}
+	total, err := cfg.db.CountSearchChirps(r.Context(), database.CountSearchChirpsParams{
+		Query:       webQuery,
+		PrefixQuery: prefixQuery,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps", err)
+		return
+	}
+
+	results := []ChirpSearchResult{}
+	for _, dbResult := range dbResults {
+		results = append(results, ChirpSearchResult{
+			Chirp: Chirp{
+				ID:        dbResult.ID,
+				CreatedAt: dbResult.CreatedAt,
+				UpdatedAt: dbResult.UpdatedAt,
+				UserID:    dbResult.UserID,
+				Body:      dbResult.Body,
+				Edited:    dbResult.Edited,
+			},
+			Rank:    dbResult.Rank,
+			Snippet: highlightSnippet(dbResult.Snippet),
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Results: results,
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+	})
+}
+
+// buildSearchQuery splits a user's search string into a websearch_to_tsquery input (phrases,
+// -negation, OR) and a to_tsquery string holding any prefix terms such as "chir*" or "-chir*",
+// which websearch_to_tsquery has no syntax for. Words inside "quoted phrases" are never treated
+// as prefixes:
+func buildSearchQuery(q string) (webQuery, prefixQuery string) {
+	webTerms := []string{}
+	prefixTerms := []string{}
+	inPhrase := false
+	for _, field := range strings.Fields(q) {
+		quotes := strings.Count(field, `"`)
+		if !inPhrase && quotes == 0 && strings.HasSuffix(field, "*") {
+			if term, ok := prefixTerm(field); ok {
+				prefixTerms = append(prefixTerms, term)
+				continue
+			}
+		}
+		// an odd number of quotes opens or closes a phrase:
+		if quotes%2 == 1 {
+			inPhrase = !inPhrase
+		}
+		webTerms = append(webTerms, field)
+	}
+	return strings.Join(webTerms, " "), strings.Join(prefixTerms, " & ")
+}
+
+// prefixTerm turns "word*" into "word:*" (and "-word*" into "!word:*"). Everything except letters
+// and digits is dropped so user input can't inject to_tsquery operators:
+func prefixTerm(field string) (string, bool) {
+	negate := strings.HasPrefix(field, "-")
+	word := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, field)
+	if word == "" {
+		return "", false
+	}
+	term := word + ":*"
+	if negate {
+		term = "!" + term
+	}
+	return term, true
+}
+
+// highlightSnippet escapes a ts_headline excerpt for safe display and turns the match markers into
+// <mark> tags:
+func highlightSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, headlineStartSel, "<mark>")
+	return strings.ReplaceAll(escaped, headlineStopSel, "</mark>")
+}
@@ -0,0 +1,87 @@
+package main
+
+import "testing"
+
+func TestBuildSearchQuery(t *testing.T) {
+	tests := []struct {
+		name       string
+		q          string
+		wantWeb    string
+		wantPrefix string
+	}{
+		{"plain words", "hello world", "hello world", ""},
+		{"prefix term", "chir* hello", "hello", "chir:*"},
+		{"negated prefix", "-chir*", "", "!chir:*"},
+		{"several prefixes", "chir* twe*", "", "chir:* & twe:*"},
+		{"star inside a phrase", `"big chir*" deal`, `"big chir*" deal`, ""},
+		{"phrase then prefix", `"big deal" chir*`, `"big deal"`, "chir:*"},
+		{"operators are dropped", "ch|ir&!*", "", "chir:*"},
+		{"nothing left of the prefix", "!*", "!*", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			web, prefix := buildSearchQuery(tt.q)
+			if web != tt.wantWeb || prefix != tt.wantPrefix {
+				t.Errorf("buildSearchQuery(%q) = %q, %q; want %q, %q", tt.q, web, prefix, tt.wantWeb, tt.wantPrefix)
+			}
+		})
+	}
+}
+
+func TestPrefixTerm(t *testing.T) {
+	tests := []struct {
+		field  string
+		want   string
+		wantOK bool
+	}{
+		{"chir*", "chir:*", true},
+		{"-chir*", "!chir:*", true},
+		{"café*", "café:*", true},
+		{"a:*|b*", "ab:*", true},
+		{"-*", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			got, ok := prefixTerm(tt.field)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("prefixTerm(%q) = %q, %v; want %q, %v", tt.field, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	tests := []struct {
+		name    string
+		snippet string
+		want    string
+	}{
+		{"no matches", "just text", "just text"},
+		{"one match", "say " + headlineStartSel + "hello" + headlineStopSel + " there", "say <mark>hello</mark> there"},
+		{"HTML is escaped", "<b>" + headlineStartSel + "hi" + headlineStopSel + "</b> & co", "&lt;b&gt;<mark>hi</mark>&lt;/b&gt; &amp; co"},
+		{"quotes are escaped", "\"" + headlineStartSel + "x" + headlineStopSel + "\"", "&#34;<mark>x</mark>&#34;"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlightSnippet(tt.snippet); got != tt.want {
+				t.Errorf("highlightSnippet(%q) = %q, want %q", tt.snippet, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripHeadlineMarkers(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"hello", "hello"},
+		{headlineStartSel + "<script>" + headlineStopSel, "<script>"},
+		{"a" + headlineStopSel + "b" + headlineStartSel + "c", "abc"},
+	}
+	for _, tt := range tests {
+		if got := stripHeadlineMarkers(tt.in); got != tt.want {
+			t.Errorf("stripHeadlineMarkers(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/scope"
+	"github.com/google/uuid"
+)
+
+// A ChirpRevision is a body a chirp had before one of its edits:
+type ChirpRevision struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	Body      string    `json:"body"`
+}
+
+// handles PATCH /api/chirps/{chirpID}. Only the author can edit, and only within cfg.chirpEditWindow
+// of posting:
+func (cfg *apiConfig) handlerChirpsUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	userID, err := cfg.authenticate(r, scope.ChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	// edits go through exactly the same length and profanity rules as new chirps:
+	cleaned, err := validateChirp(params.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	// load (and lock) the current version so we can check ownership and save its body:
+	current, err := qtx.GetChirpForEdit(r.Context(), database.GetChirpForEditParams{
+		ID:                chirpID,
+		EditWindowSeconds: cfg.chirpEditWindow.Seconds(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find chirp", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+		return
+	}
+	if current.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't edit this chirp", nil)
+		return
+	}
+	if !current.Editable {
+		respondWithError(w, http.StatusForbidden, "The edit window for this chirp has passed", nil)
+		return
+	}
+
+	chirp := Chirp{
+		ID:        current.ID,
+		CreatedAt: current.CreatedAt,
+		UpdatedAt: current.UpdatedAt,
+		UserID:    current.UserID,
+		Body:      current.Body,
+		Edited:    current.Edited,
+	}
+	// an "edit" that doesn't change anything doesn't deserve a revision:
+	if cleaned != current.Body {
+		_, err = qtx.CreateChirpRevision(r.Context(), database.CreateChirpRevisionParams{
+			ChirpID: current.ID,
+			Body:    current.Body,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save chirp revision", err)
+			return
+		}
+		updated, err := qtx.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+			ID:   current.ID,
+			Body: cleaned,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+			return
+		}
+		chirp.UpdatedAt = updated.UpdatedAt
+		chirp.Body = updated.Body
+		chirp.Edited = updated.Edited
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+		return
+	}
+
+	chirps := []Chirp{chirp}
+	if err := cfg.loadChirpMedia(r.Context(), chirps); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp media", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirps[0])
+}
+
+// handles GET /api/chirps/{chirpID}/revisions, oldest first:
+func (cfg *apiConfig) handlerChirpsRevisions(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+	// make sure the chirp exists, so a bad ID is a 404 rather than an empty list:
+	if _, err := cfg.db.GetChirp(r.Context(), chirpID); err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
+		return
+	}
+
+	dbRevisions, err := cfg.db.GetChirpRevisions(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve revisions", err)
+		return
+	}
+	revisions := []ChirpRevision{}
+	for _, dbRevision := range dbRevisions {
+		revisions = append(revisions, ChirpRevision{
+			ID:        dbRevision.ID,
+			CreatedAt: dbRevision.CreatedAt,
+			ChirpID:   dbRevision.ChirpID,
+			Body:      dbRevision.Body,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, revisions)
+}
@@ -1,25 +1,38 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/google/uuid"
 )
 
+// access tokens are short-lived so a leaked one isn't useful for long:
+const accessTokenExpiry = time.Hour
+
+// the second login step has to be finished within this long:
+const mfaChallengeExpiry = 5 * time.Minute
+
+// the login endpoints only ever need a few fields, so anything bigger is refused before it's read:
+const maxLoginBodyBytes = 16 << 10
+
 // Create a method on *apiConfig that handles HTTP requests to a login endpoint:
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	// Create a local struct to decode the JSON body:
 	type parameters struct {
 		Password string `json:"password"`
 		Email    string `json:"email"`
+		// "cookie" for the web app, which gets a session cookie instead of tokens:
+		Session string `json:"session"`
 	}
-	// Create a local struct used to encode the JSON response:
-	// It embeds a User type (Embedding means the User fields appear at the top level of the JSON)
-	type response struct {
-		User
-	}
-
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodyBytes)
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
 	err := decoder.Decode(&params)
@@ -27,29 +40,208 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
+	cookieSession, err := parseSessionMode(params.Session)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if cookieSession {
+		if err := cfg.checkLoginOrigin(r); err != nil {
+			respondWithError(w, http.StatusForbidden, "Cross-site login requests aren't allowed", err)
+			return
+		}
+	}
 
-	// Call a DB method to fetch a user by email, passing the request context and the email from the 
+	// count the attempt, or refuse straight away if this email or IP has failed too often lately
+	// (see login_throttle.go):
+	attempt, ok := cfg.checkLoginAllowed(w, r, params.Email)
+	if !ok {
+		return
+	}
+
+	// Call a DB method to fetch a user by email, passing the request context and the email from the
 	// parsed params. Returns the user record and an err:
 	user, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
-	if err != nil {
+	if errors.Is(err, sql.ErrNoRows) {
+		// hash the password anyway so an unknown email takes as long as a wrong password, and count
+		// the failure the same way too:
+		if err := auth.CheckDummyPassword(params.Password); err != nil {
+			respondWithHashError(w, "Couldn't log in", err)
+			return
+		}
+		cfg.recordLoginFailure(r, attempt, nil)
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log in", err)
+		return
+	}
 
-	// verify the login password against the stored bcrypt hash:
+	// verify the login password against the stored hash (bcrypt or Argon2id):
 	err = auth.CheckPasswordHash(params.Password, user.HashedPassword)
+	if errors.Is(err, auth.ErrHashBusy) {
+		respondWithHashError(w, "Couldn't log in", err)
+		return
+	}
 	if err != nil {
+		cfg.recordLoginFailure(r, attempt, &user)
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
+	cfg.forgiveLoginAttempt(r.Context(), r)
+
+	// now that we have the plain password, upgrade a hash made with older or weaker settings:
+	if auth.NeedsRehash(user.HashedPassword) {
+		cfg.rehashPassword(r.Context(), user, params.Password)
+	}
+
+	cfg.loginOrChallenge(w, r, user, cookieSession)
+}
+
+// loginOrChallenge finishes a first login step (a password, or a sign-in with an external
+// provider): with two-factor auth on it answers with a challenge instead of tokens:
+func (cfg *apiConfig) loginOrChallenge(w http.ResponseWriter, r *http.Request, user database.User, cookieSession bool) {
+	type mfaResponse struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+
+	if user.TotpEnabledAt.Valid {
+		mfaToken, err := auth.MakeMFAChallengeToken(user.ID, cfg.mfaSecret, mfaChallengeExpiry)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create MFA challenge", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, mfaResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		})
+		return
+	}
+
+	cfg.completeLogin(w, r, user, cookieSession)
+}
+
+// completeLogin issues the access and refresh tokens (or starts a cookie session) once the user has
+// passed every login step:
+func (cfg *apiConfig) completeLogin(w http.ResponseWriter, r *http.Request, user database.User, cookieSession bool) {
+	// Create a local struct used to encode the JSON response:
+	// It embeds a User type (Embedding means the User fields appear at the top level of the JSON)
+	type response struct {
+		User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	// a cookie session only needs the CSRF token to go with it:
+	type sessionResponse struct {
+		User
+		CSRFToken string `json:"csrf_token"`
+	}
+
+	// every step passed, so the account's failed attempts no longer count:
+	cfg.clearLoginFailures(r.Context(), user.Email)
+
+	// logging in during the deletion grace period means the user changed their mind:
+	if user.DeletionScheduledAt.Valid {
+		err := cfg.db.CancelUserDeletion(r.Context(), user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't cancel account deletion", err)
+			return
+		}
+		user.DeletionScheduledAt.Valid = false
+	}
+
+	if cookieSession {
+		csrfToken, err := cfg.startCookieSession(w, r, user)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
+			return
+		}
+		cfg.queueAudit(r, auditEvent{
+			Actor:   user.ID,
+			Action:  auditLogin,
+			Target:  auditTarget("user", user.ID),
+			Details: map[string]any{"session": "cookie"},
+		})
+		respondWithJSON(w, http.StatusOK, sessionResponse{
+			User:      userFromDB(user),
+			CSRFToken: csrfToken,
+		})
+		return
+	}
+
+	// a long-lived refresh token to get new access tokens with. Only its hash is stored, so a
+	// database leak doesn't hand out working sessions:
+	refreshToken, err := auth.MakeToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		return
+	}
+
+	// the session (which shows up in the user's device list) and its refresh token are created
+	// together, so ending the session can always find the token to revoke:
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	session, err := createSession(r.Context(), qtx, r, user.ID, sql.NullString{}, refreshTokenExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
+		return
+	}
+	_, err = qtx.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		TokenHash:        auth.HashToken(refreshToken),
+		UserID:           user.ID,
+		SessionID:        uuid.NullUUID{UUID: session.ID, Valid: true},
+		ExpiresInSeconds: refreshTokenExpiry.Seconds(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
+		return
+	}
+
+	// and an access token the client sends back as "Authorization: Bearer <token>":
+	accessToken, err := cfg.makeAccessToken(r.Context(), user.ID, session.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access token", err)
+		return
+	}
+
+	cfg.queueAudit(r, auditEvent{
+		Actor:   user.ID,
+		Action:  auditLogin,
+		Target:  auditTarget("user", user.ID),
+		Details: map[string]any{"session": "token", "session_id": session.ID},
+	})
 
 	// send a successful JSON response with the public user fields (no password!)
 	respondWithJSON(w, http.StatusOK, response{
-		User: User{
-			ID:        user.ID,
-			Email:     user.Email,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
-		},
+		User:         userFromDB(user),
+		Token:        accessToken,
+		RefreshToken: refreshToken,
 	})
 }
+
+// rehashPassword replaces user's stored hash with one made with the current settings. It's
+// best-effort: if it fails the old hash still works and we'll try again next login:
+func (cfg *apiConfig) rehashPassword(ctx context.Context, user database.User, password string) {
+	hash, err := auth.HashPassword(password)
+	if err == nil {
+		_, err = cfg.db.UpdateUserPassword(ctx, database.UpdateUserPasswordParams{
+			ID:             user.ID,
+			HashedPassword: hash,
+		})
+	}
+	if err != nil {
+		log.Printf("Error upgrading password hash for user %s: %s", user.ID, err)
+	}
+}
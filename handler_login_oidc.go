@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/oauth"
+	"github.com/craigbucher/learn-http-servers/internal/oidc"
+)
+
+const (
+	// how long the user has to get through the provider's login page and come back:
+	oidcLoginExpiry = 10 * time.Minute
+	// the state is also kept in a cookie, so a callback only works in the browser that started it:
+	oidcStateCookie = "chirpy_oidc_state"
+)
+
+var (
+	// we only trust an email address the provider says it has checked:
+	errIdentityEmailUnverified = errors.New("identity provider hasn't verified the email address")
+	// an unverified local account with the same email might have been registered by someone else
+	// to catch the real owner's sign-in, so it isn't linked automatically:
+	errIdentityAccountUnverified = errors.New("existing account's email address isn't verified")
+	errIdentityEmailTaken        = errors.New("email address belongs to another account")
+)
+
+// handles GET /api/login/oidc: sends the browser off to the provider's login page. ?session=cookie
+// asks for a cookie session at the end, like POST /api/login's "session":
+func (cfg *apiConfig) handlerLoginOIDC(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Sign-in with an external provider isn't enabled", nil)
+		return
+	}
+	cookieSession, err := parseSessionMode(r.URL.Query().Get("session"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	// state ties the callback to this request, nonce ties the ID token to it, and the PKCE verifier
+	// makes a stolen code useless to anyone else:
+	state, err := auth.MakeToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+	nonce, err := auth.MakeToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+	verifier, err := oauth.NewCodeVerifier()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+
+	authURL, err := cfg.oidc.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't reach the identity provider", err)
+		return
+	}
+	err = cfg.db.CreateOIDCLoginState(r.Context(), database.CreateOIDCLoginStateParams{
+		StateHash:        auth.HashToken(state),
+		Nonce:            nonce,
+		CodeVerifier:     verifier,
+		ExpiresInSeconds: oidcLoginExpiry.Seconds(),
+		CookieSession:    cookieSession,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+
+	// SameSite=Lax still sends the cookie on the provider's top-level redirect back to us:
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/login/oidc",
+		MaxAge:   int(oidcLoginExpiry.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.publicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handles GET /api/login/oidc/callback, where the provider sends the browser back with a code. The
+// answer is the same as POST /api/login's: tokens (or a cookie session, if that's what the login
+// started with), or a two-factor challenge:
+func (cfg *apiConfig) handlerLoginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Sign-in with an external provider isn't enabled", nil)
+		return
+	}
+	query := r.URL.Query()
+
+	// whatever happens next, the state can't be used again:
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/api/login/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.publicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired login state", err)
+		return
+	}
+	loginState, err := cfg.db.ConsumeOIDCLoginState(r.Context(), auth.HashToken(state))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired login state", err)
+		return
+	}
+
+	// the user said no, or the provider couldn't log them in:
+	if e := query.Get("error"); e != "" {
+		respondWithError(w, http.StatusUnauthorized, "Sign-in was cancelled or refused", errors.New(e))
+		return
+	}
+
+	rawIDToken, err := cfg.oidc.Exchange(r.Context(), query.Get("code"), loginState.CodeVerifier)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't sign in with the identity provider", err)
+		return
+	}
+	idToken, err := cfg.oidc.VerifyIDToken(r.Context(), rawIDToken, loginState.Nonce)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't sign in with the identity provider", err)
+		return
+	}
+
+	user, err := cfg.userForIdentity(r.Context(), idToken)
+	switch {
+	case errors.Is(err, errIdentityEmailUnverified):
+		respondWithError(w, http.StatusForbidden, "Your identity provider hasn't verified your email address", err)
+		return
+	case errors.Is(err, errIdentityAccountUnverified):
+		respondWithError(w, http.StatusConflict, "An account with this email already exists; log in with your password and verify your email first", err)
+		return
+	case errors.Is(err, errIdentityEmailTaken):
+		respondWithError(w, http.StatusConflict, "Email is already registered", err)
+		return
+	case err != nil:
+		respondWithHashError(w, "Couldn't sign in", err)
+		return
+	}
+
+	cfg.loginOrChallenge(w, r, user, loginState.CookieSession)
+}
+
+// userForIdentity finds the Chirpy account for a provider identity. An identity seen before maps
+// straight to its account; otherwise it's linked to the account with the same (verified) email
+// address, whatever its case, or a new account is created for it:
+func (cfg *apiConfig) userForIdentity(ctx context.Context, idToken oidc.IDToken) (database.User, error) {
+	user, err := cfg.db.GetUserByIdentity(ctx, database.GetUserByIdentityParams{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+	})
+	if err == nil {
+		err = cfg.db.TouchUserIdentity(ctx, database.TouchUserIdentityParams{
+			Issuer:  idToken.Issuer,
+			Subject: idToken.Subject,
+			Email:   idToken.Email,
+		})
+		return user, err
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if idToken.Email == "" || !idToken.EmailVerified {
+		return database.User{}, errIdentityEmailUnverified
+	}
+
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	user, err = qtx.GetUserByEmailForIdentity(ctx, idToken.Email)
+	switch {
+	case err == nil:
+		if !user.EmailVerifiedAt.Valid {
+			return database.User{}, errIdentityAccountUnverified
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		user, err = cfg.createUserForIdentity(ctx, qtx, idToken)
+		if isUniqueViolation(err, "users_email_key") {
+			// the address belongs to a deleted account, or someone signed up with it just now:
+			return database.User{}, errIdentityEmailTaken
+		}
+		if err != nil {
+			return database.User{}, err
+		}
+	default:
+		return database.User{}, err
+	}
+
+	err = qtx.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+		UserID:  user.ID,
+		Email:   idToken.Email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	return user, tx.Commit()
+}
+
+// createUserForIdentity makes a new account from what the provider told us. It gets a random
+// password nobody knows (the user can set one with a password reset), the provider's username as
+// its handle if that's allowed and free, and their name as its display name:
+func (cfg *apiConfig) createUserForIdentity(ctx context.Context, qtx *database.Queries, idToken oidc.IDToken) (database.User, error) {
+	password, err := auth.MakeToken()
+	if err != nil {
+		return database.User{}, err
+	}
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	handle, err := validateHandle(idToken.PreferredUsername)
+	if err == nil {
+		_, err = qtx.GetUserByHandle(ctx, handle)
+		if err == nil {
+			err = errors.New("handle is taken")
+		} else if errors.Is(err, sql.ErrNoRows) {
+			err = nil
+		}
+	}
+	if err != nil {
+		handle, err = placeholderHandle()
+		if err != nil {
+			return database.User{}, err
+		}
+	}
+
+	displayName := strings.TrimSpace(idToken.Name)
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+		displayName = string([]rune(displayName)[:maxDisplayNameLength])
+	}
+
+	return qtx.CreateVerifiedUser(ctx, database.CreateVerifiedUserParams{
+		Email:          idToken.Email,
+		HashedPassword: hashedPassword,
+		Handle:         handle,
+		DisplayName:    displayName,
+	})
+}
+
+// cleanupOIDCLoginStates forgets logins that were started but never came back:
+func (cfg *apiConfig) cleanupOIDCLoginStates(ctx context.Context) error {
+	return cfg.db.DeleteExpiredOIDCLoginStates(ctx)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/oidc"
+	_ "github.com/lib/pq"
+)
+
+// testDBConfig connects to the migrated database in TEST_DB_URL, skipping the test if there isn't one:
+func testDBConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	dbURL := os.Getenv("TEST_DB_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DB_URL not set")
+	}
+	dbConn, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+	return &apiConfig{
+		db:                    database.New(dbConn),
+		dbConn:                dbConn,
+		accountDeletionChirps: chirpPolicyAnonymize,
+		auditKey:              []byte("test audit key"),
+	}
+}
+
+func TestOIDCLoginAfterAnonymization(t *testing.T) {
+	cfg := testDBConfig(t)
+	ctx := context.Background()
+
+	token, err := auth.MakeToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idToken := oidc.IDToken{
+		Issuer:        "https://idp.example.com",
+		Subject:       token,
+		Email:         "oidc-" + token[:12] + "@example.com",
+		EmailVerified: true,
+	}
+
+	user, err := cfg.userForIdentity(ctx, idToken)
+	if err != nil {
+		t.Fatalf("first sign-in: %s", err)
+	}
+
+	// a second identity with the same address in another case links to the same account:
+	other := idToken
+	other.Subject = token + "-other"
+	other.Email = strings.ToUpper(idToken.Email)
+	linked, err := cfg.userForIdentity(ctx, other)
+	if err != nil {
+		t.Fatalf("sign-in with uppercased email: %s", err)
+	}
+	if linked.ID != user.ID {
+		t.Errorf("uppercased email linked to %s, want %s", linked.ID, user.ID)
+	}
+
+	_, err = cfg.db.ScheduleUserDeletion(ctx, database.ScheduleUserDeletionParams{
+		GracePeriodSeconds: -1,
+		ID:                 user.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.processAccountDeletions(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := cfg.userForIdentity(ctx, idToken)
+	if err == nil && again.ID == user.ID {
+		t.Errorf("signing in after anonymization reached the anonymized account %s", user.ID)
+	}
+}
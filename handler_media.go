@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/blobstore"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/media"
+	"github.com/craigbucher/learn-http-servers/internal/scope"
+	"github.com/google/uuid"
+)
+
+// a chirp can carry at most this many images:
+const maxMediaPerChirp = 4
+
+// how long a presigned media URL we redirect to stays valid:
+const mediaURLExpiry = 15 * time.Minute
+
+// API shape of an uploaded image. The URLs point back at our own media endpoints rather than
+// exposing blob store keys:
+type Media struct {
+	ID           uuid.UUID `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UserID       uuid.UUID `json:"user_id"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int32     `json:"width"`
+	Height       int32     `json:"height"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+}
+
+func mediaFromDB(m database.Medium) Media {
+	return Media{
+		ID:           m.ID,
+		CreatedAt:    m.CreatedAt,
+		UserID:       m.UserID,
+		ContentType:  m.ContentType,
+		Size:         m.SizeBytes,
+		Width:        m.Width,
+		Height:       m.Height,
+		URL:          "/api/media/" + m.ID.String(),
+		ThumbnailURL: "/api/media/" + m.ID.String() + "/thumbnail",
+	}
+}
+
+// handles POST /api/media, a multipart/form-data upload with the image in a "file" field:
+func (cfg *apiConfig) handlerMediaCreate(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scope.MediaWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	// cap the whole request body at the file limit plus some room for the other form fields, so a
+	// client can't stream gigabytes at us:
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.maxUploadBytes+1<<20)
+	// keep up to 1MB of the form in memory; anything bigger is spooled to temp files:
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "File is too large", err)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse multipart form", err)
+		return
+	}
+	// delete any temp files ParseMultipartForm created once we're done:
+	defer r.MultipartForm.RemoveAll()
+
+	// like POST /api/chirps, an old-style user_id field is still accepted if it matches the token:
+	if s := r.FormValue("user_id"); s != "" {
+		formUserID, err := uuid.Parse(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+			return
+		}
+		if formUserID != userID {
+			respondWithError(w, http.StatusForbidden, "user_id doesn't match the access token", nil)
+			return
+		}
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Missing file", err)
+		return
+	}
+	defer file.Close()
+	// read one byte more than allowed so we can tell "exactly at the limit" from "over it":
+	data, err := io.ReadAll(io.LimitReader(file, cfg.maxUploadBytes+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read file", err)
+		return
+	}
+	if int64(len(data)) > cfg.maxUploadBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "File is too large", nil)
+		return
+	}
+
+	// check the magic bytes, strip EXIF and friends, and make a thumbnail:
+	img, err := media.Process(data)
+	if errors.Is(err, media.ErrUnsupportedType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Only JPEG, PNG and GIF images are allowed", err)
+		return
+	}
+	if errors.Is(err, media.ErrTooManyPixels) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Image dimensions are too large", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read image", err)
+		return
+	}
+
+	// the ID is generated here rather than in SQL because the blob keys are derived from it and the
+	// blobs are written before the row:
+	id := uuid.New()
+	blobKey := "media/" + id.String() + "/original"
+	thumbnailKey := "media/" + id.String() + "/thumbnail"
+	if err := cfg.blobs.Put(r.Context(), blobKey, bytes.NewReader(img.Data), img.ContentType); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store file", err)
+		return
+	}
+	if err := cfg.blobs.Put(r.Context(), thumbnailKey, bytes.NewReader(img.Thumbnail), img.ThumbnailContentType); err != nil {
+		cfg.deleteBlobs(blobKey)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store file", err)
+		return
+	}
+
+	dbMedia, err := cfg.db.CreateMedia(r.Context(), database.CreateMediaParams{
+		ID:                   id,
+		UserID:               userID,
+		ContentType:          img.ContentType,
+		SizeBytes:            int64(len(img.Data)),
+		Width:                int32(img.Width),
+		Height:               int32(img.Height),
+		BlobKey:              blobKey,
+		ThumbnailKey:         thumbnailKey,
+		ThumbnailContentType: img.ThumbnailContentType,
+	})
+	if err != nil {
+		cfg.deleteBlobs(blobKey, thumbnailKey)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save media", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, mediaFromDB(dbMedia))
+}
+
+// handles GET /api/media/{mediaID}
+func (cfg *apiConfig) handlerMediaGet(w http.ResponseWriter, r *http.Request) {
+	cfg.serveMedia(w, r, false)
+}
+
+// handles GET /api/media/{mediaID}/thumbnail
+func (cfg *apiConfig) handlerMediaThumbnailGet(w http.ResponseWriter, r *http.Request) {
+	cfg.serveMedia(w, r, true)
+}
+
+func (cfg *apiConfig) serveMedia(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	mediaID, err := uuid.Parse(r.PathValue("mediaID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid media ID", err)
+		return
+	}
+	dbMedia, err := cfg.db.GetMedia(r.Context(), mediaID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get media", err)
+		return
+	}
+
+	key, contentType := dbMedia.BlobKey, dbMedia.ContentType
+	if thumbnail {
+		key, contentType = dbMedia.ThumbnailKey, dbMedia.ThumbnailContentType
+	}
+
+	// if the store can presign URLs (S3), send the client straight to it instead of streaming the 
+	// bytes through our server:
+	if presigner, ok := cfg.blobs.(blobstore.Presigner); ok {
+		url, err := presigner.PresignGet(r.Context(), key, mediaURLExpiry)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get media", err)
+			return
+		}
+		// the redirect may be cached, but only for less time than the URL it points to stays valid:
+		w.Header().Set("Cache-Control", "private, max-age=600")
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+	blob, err := cfg.blobs.Get(r.Context(), key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get media", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get media", err)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	if !thumbnail {
+		w.Header().Set("Content-Length", strconv.FormatInt(dbMedia.SizeBytes, 10))
+	}
+	// stop browsers from second-guessing the type we validated on upload:
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// a media ID always refers to the same bytes, so it can be cached forever:
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, blob)
+}
+
+// loadChirpMedia fills in the Media field of each chirp with a single query:
+func (cfg *apiConfig) loadChirpMedia(ctx context.Context, chirps []Chirp) error {
+	if len(chirps) == 0 {
+		return nil
+	}
+	chirpIDs := make([]uuid.UUID, 0, len(chirps))
+	for _, chirp := range chirps {
+		chirpIDs = append(chirpIDs, chirp.ID)
+	}
+	dbMedia, err := cfg.db.GetMediaForChirps(ctx, chirpIDs)
+	if err != nil {
+		return err
+	}
+	byChirp := map[uuid.UUID][]Media{}
+	for _, m := range dbMedia {
+		byChirp[m.ChirpID.UUID] = append(byChirp[m.ChirpID.UUID], mediaFromDB(m))
+	}
+	for i := range chirps {
+		chirps[i].Media = byChirp[chirps[i].ID]
+	}
+	return nil
+}
+
+// deleteBlobs is best-effort cleanup after a failed upload; it uses its own context because the
+// request's may already be cancelled:
+func (cfg *apiConfig) deleteBlobs(keys ...string) {
+	for _, key := range keys {
+		cfg.blobs.Delete(context.Background(), key)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/oauth"
+	"github.com/craigbucher/learn-http-servers/internal/scope"
+	"github.com/craigbucher/learn-http-servers/internal/totp"
+	"github.com/google/uuid"
+)
+
+// an authorization code has to be traded for tokens almost straight away:
+const oauthCodeExpiry = 10 * time.Minute
+
+// authorizeRequest is a checked request to the authorization endpoint:
+type authorizeRequest struct {
+	client        database.OauthClient
+	redirectURI   string
+	scopes        []scope.Scope
+	state         string
+	codeChallenge string
+}
+
+// parseAuthorizeRequest checks the query (or, once the form is submitted, the form) sent to
+// /oauth/authorize. If the client or redirect URI is wrong there's nowhere safe to send the user
+// back to, so it returns redirect == false and the error is shown on our own page; anything else
+// goes back to the app as an error redirect:
+func (cfg *apiConfig) parseAuthorizeRequest(r *http.Request, values url.Values) (req authorizeRequest, redirect bool, err *oauth.Error) {
+	clientID, parseErr := uuid.Parse(values.Get("client_id"))
+	if parseErr != nil {
+		return req, false, oauth.NewError(oauth.ErrInvalidClient, "unknown client_id")
+	}
+	client, dbErr := cfg.db.GetOAuthClient(r.Context(), clientID)
+	if dbErr != nil {
+		return req, false, oauth.NewError(oauth.ErrInvalidClient, "unknown client_id")
+	}
+	req.client = client
+
+	// redirect_uri can only be left out when the client registered exactly one:
+	req.redirectURI = values.Get("redirect_uri")
+	if req.redirectURI == "" && len(client.RedirectUris) == 1 {
+		req.redirectURI = client.RedirectUris[0]
+	}
+	// an exact match, so an attacker can't send codes to a path or host the app didn't register:
+	if !slices.Contains(client.RedirectUris, req.redirectURI) {
+		return req, false, oauth.NewError(oauth.ErrInvalidRequest, "redirect_uri isn't registered for this client")
+	}
+
+	req.state = values.Get("state")
+	if values.Get("response_type") != "code" {
+		return req, true, oauth.NewError(oauth.ErrUnsupportedResponse, "response_type must be code")
+	}
+	scopes, scopeErr := oauth.ParseScope(values.Get("scope"))
+	if scopeErr != nil {
+		errors.As(scopeErr, &err)
+		return req, true, err
+	}
+	req.scopes = scopes
+	if pkceErr := oauth.ValidateCodeChallenge(values.Get("code_challenge"), values.Get("code_challenge_method")); pkceErr != nil {
+		errors.As(pkceErr, &err)
+		return req, true, err
+	}
+	req.codeChallenge = values.Get("code_challenge")
+	return req, true, nil
+}
+
+// redirectAuthorizeError sends the user back to the app with an error (RFC 6749 section 4.1.2.1):
+func redirectAuthorizeError(w http.ResponseWriter, r *http.Request, req authorizeRequest, oauthErr *oauth.Error) {
+	params := url.Values{
+		"error":             {oauthErr.Code},
+		"error_description": {oauthErr.Description},
+	}
+	if req.state != "" {
+		params.Set("state", req.state)
+	}
+	http.Redirect(w, r, oauth.RedirectURL(req.redirectURI, params), http.StatusSeeOther)
+}
+
+// handles GET /oauth/authorize, the page an app sends the user to. It explains what the app is
+// asking for and lets the user log in to allow it, or deny it:
+func (cfg *apiConfig) handlerOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	req, redirect, oauthErr := cfg.parseAuthorizeRequest(r, r.URL.Query())
+	if oauthErr != nil {
+		if redirect {
+			redirectAuthorizeError(w, r, req, oauthErr)
+			return
+		}
+		renderConsentPage(w, http.StatusBadRequest, consentPageData{Fatal: oauthErr.Description})
+		return
+	}
+	renderConsentPage(w, http.StatusOK, newConsentPageData(req, r.URL.Query(), ""))
+}
+
+// handles POST /oauth/authorize, the consent form being submitted. The user proves who they are
+// with their password (and two-factor code if they use one) on the form itself, so an app can
+// never see the password:
+func (cfg *apiConfig) handlerOAuthAuthorizeSubmit(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodyBytes)
+	if err := r.ParseForm(); err != nil {
+		renderConsentPage(w, http.StatusBadRequest, consentPageData{Fatal: "Couldn't read the form"})
+		return
+	}
+	req, redirect, oauthErr := cfg.parseAuthorizeRequest(r, r.PostForm)
+	if oauthErr != nil {
+		if redirect {
+			redirectAuthorizeError(w, r, req, oauthErr)
+			return
+		}
+		renderConsentPage(w, http.StatusBadRequest, consentPageData{Fatal: oauthErr.Description})
+		return
+	}
+	if r.PostForm.Get("decision") != "allow" {
+		redirectAuthorizeError(w, r, req, oauth.NewError(oauth.ErrAccessDenied, "the user denied the request"))
+		return
+	}
+
+	// from here on, problems are shown on the form so the user can try again:
+	email := strings.TrimSpace(r.PostForm.Get("email"))
+	retry := func(status int, message string) {
+		data := newConsentPageData(req, r.PostForm, message)
+		data.Email = email
+		renderConsentPage(w, status, data)
+	}
+
+	// the same throttling, and the same attempt counting, as POST /api/login:
+	attempt, retryAfter, err := cfg.beginLoginAttempt(r, email)
+	if err != nil {
+		log.Printf("Error counting login attempt: %s", err)
+		retry(http.StatusInternalServerError, "Something went wrong, please try again")
+		return
+	}
+	if retryAfter > 0 {
+		retry(http.StatusTooManyRequests, "Too many failed login attempts, try again later")
+		return
+	}
+	password := r.PostForm.Get("password")
+	user, err := cfg.db.GetUserByEmail(r.Context(), email)
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := auth.CheckDummyPassword(password); err != nil {
+			retry(http.StatusServiceUnavailable, "The server is busy, please try again in a moment")
+			return
+		}
+		cfg.recordLoginFailure(r, attempt, nil)
+		retry(http.StatusUnauthorized, "Incorrect email or password")
+		return
+	}
+	if err != nil {
+		log.Printf("Error looking up user: %s", err)
+		retry(http.StatusInternalServerError, "Something went wrong, please try again")
+		return
+	}
+	err = auth.CheckPasswordHash(password, user.HashedPassword)
+	if errors.Is(err, auth.ErrHashBusy) {
+		retry(http.StatusServiceUnavailable, "The server is busy, please try again in a moment")
+		return
+	}
+	if err != nil {
+		cfg.recordLoginFailure(r, attempt, &user)
+		retry(http.StatusUnauthorized, "Incorrect email or password")
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		code := strings.TrimSpace(r.PostForm.Get("code"))
+		if code == "" {
+			retry(http.StatusUnauthorized, "Enter the code from your authenticator app, or a recovery code")
+			return
+		}
+		if !cfg.useSecondFactor(r, user, code) {
+			cfg.recordLoginFailure(r, attempt, &user)
+			retry(http.StatusUnauthorized, "That code isn't right")
+			return
+		}
+	}
+	cfg.forgiveLoginAttempt(r.Context(), r)
+	cfg.clearLoginFailures(r.Context(), email)
+	if auth.NeedsRehash(user.HashedPassword) {
+		cfg.rehashPassword(r.Context(), user, password)
+	}
+	// signing in here is a login like any other, so during the deletion grace period it means the
+	// user changed their mind (the same as in completeLogin):
+	if user.DeletionScheduledAt.Valid {
+		if err := cfg.db.CancelUserDeletion(r.Context(), user.ID); err != nil {
+			log.Printf("Error cancelling account deletion: %s", err)
+			retry(http.StatusInternalServerError, "Something went wrong, please try again")
+			return
+		}
+	}
+
+	code, err := auth.MakeToken()
+	if err != nil {
+		log.Printf("Error making authorization code: %s", err)
+		retry(http.StatusInternalServerError, "Something went wrong, please try again")
+		return
+	}
+	err = cfg.db.CreateOAuthAuthorizationCode(r.Context(), database.CreateOAuthAuthorizationCodeParams{
+		CodeHash:         auth.HashToken(code),
+		ClientID:         req.client.ID,
+		UserID:           user.ID,
+		RedirectUri:      req.redirectURI,
+		Scopes:           scope.Strings(req.scopes),
+		CodeChallenge:    req.codeChallenge,
+		ExpiresInSeconds: oauthCodeExpiry.Seconds(),
+	})
+	if err != nil {
+		log.Printf("Error saving authorization code: %s", err)
+		retry(http.StatusInternalServerError, "Something went wrong, please try again")
+		return
+	}
+
+	cfg.queueAudit(r, auditEvent{
+		Actor:   user.ID,
+		Action:  auditLogin,
+		Target:  auditTarget("user", user.ID),
+		Details: map[string]any{"oauth_client_id": req.client.ID},
+	})
+
+	params := url.Values{"code": {code}}
+	if req.state != "" {
+		params.Set("state", req.state)
+	}
+	http.Redirect(w, r, oauth.RedirectURL(req.redirectURI, params), http.StatusSeeOther)
+}
+
+// useSecondFactor accepts either a current TOTP code or one of the user's recovery codes, for
+// forms that only have room for one box:
+func (cfg *apiConfig) useSecondFactor(r *http.Request, user database.User, code string) bool {
+	if len(code) == 6 && strings.Trim(code, "0123456789") == "" {
+		return cfg.useTOTPCode(r, user, code)
+	}
+	rows, err := cfg.db.UseRecoveryCode(r.Context(), database.UseRecoveryCodeParams{
+		CodeHash: auth.HashToken(totp.NormalizeRecoveryCode(code)),
+		UserID:   user.ID,
+	})
+	return err == nil && rows == 1
+}
+
+// consentPageData fills in consentPage:
+type consentPageData struct {
+	// set instead of everything else when the request is too broken to show a form for:
+	Fatal       string
+	Error       string
+	ClientName  string
+	RedirectTo  string
+	Permissions []string
+	Email       string
+	// the authorization request, carried through the form as hidden fields:
+	Params map[string]string
+}
+
+func newConsentPageData(req authorizeRequest, values url.Values, message string) consentPageData {
+	permissions := make([]string, 0, len(req.scopes))
+	for _, s := range req.scopes {
+		permissions = append(permissions, scope.Describe(s))
+	}
+	params := map[string]string{}
+	for _, name := range []string{"client_id", "redirect_uri", "response_type", "scope", "state", "code_challenge", "code_challenge_method"} {
+		params[name] = values.Get(name)
+	}
+	redirectTo := req.redirectURI
+	if u, err := url.Parse(req.redirectURI); err == nil {
+		redirectTo = u.Host
+	}
+	return consentPageData{
+		Error:       message,
+		ClientName:  req.client.Name,
+		RedirectTo:  redirectTo,
+		Permissions: permissions,
+		Params:      params,
+	}
+}
+
+// html/template escapes everything the app controls (its name, the state parameter), so a client
+// can't inject markup into the page:
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>Authorize app - Chirpy</title>
+  </head>
+  <body>
+    {{if .Fatal}}
+    <h1>Something's wrong with this link</h1>
+    <p>{{.Fatal}}</p>
+    {{else}}
+    <h1>{{.ClientName}} wants to use your Chirpy account</h1>
+    <p>If you allow it, {{.ClientName}} will be able to:</p>
+    <ul>
+      {{range .Permissions}}<li>{{.}}</li>
+      {{end}}
+    </ul>
+    <p>You'll be sent back to {{.RedirectTo}}. {{.ClientName}} never sees your password.</p>
+    {{if .Error}}<p role="alert"><strong>{{.Error}}</strong></p>{{end}}
+    <form method="post" action="/oauth/authorize">
+      {{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+      {{end}}
+      <p><label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username"></label></p>
+      <p><label>Password <input type="password" name="password" autocomplete="current-password"></label></p>
+      <p><label>Two-factor code (if you use one) <input type="text" name="code" autocomplete="one-time-code"></label></p>
+      <button type="submit" name="decision" value="allow">Allow</button>
+      <button type="submit" name="decision" value="deny">Deny</button>
+    </form>
+    {{end}}
+  </body>
+</html>
+`))
+
+func renderConsentPage(w http.ResponseWriter, status int, data consentPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// never cache a page with a login form on it, and don't let other sites frame it to trick users
+	// into clicking Allow:
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+	if err := consentPage.Execute(w, data); err != nil {
+		log.Printf("Error rendering consent page: %s", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/oauth"
+	"github.com/craigbucher/learn-http-servers/internal/scope"
+	"github.com/google/uuid"
+)
+
+const (
+	// how long an app's name can be:
+	maxOAuthClientNameLength = 100
+	// and how many redirect URIs it can register:
+	maxOAuthRedirectURIs = 10
+)
+
+// API shape of a registered OAuth client. The secret is only ever shown once, when it's created:
+type OAuthClient struct {
+	ID           uuid.UUID `json:"client_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+}
+
+func oauthClientFromDB(c database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ID:           c.ID,
+		CreatedAt:    c.CreatedAt,
+		Name:         c.Name,
+		RedirectURIs: c.RedirectUris,
+		Confidential: c.SecretHash.Valid,
+	}
+}
+
+// handles POST /api/oauth/clients, registering an app owned by the logged-in user. Confidential
+// clients (ones with a server that can keep a secret) get a client secret; public ones don't:
+func (cfg *apiConfig) handlerOAuthClientsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+	type response struct {
+		OAuthClient
+		ClientSecret string `json:"client_secret,omitempty"`
+	}
+
+	userID, err := cfg.authenticate(r, scope.Account)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	name := strings.TrimSpace(params.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxOAuthClientNameLength {
+		respondWithError(w, http.StatusBadRequest, "Name must be 1-100 characters", nil)
+		return
+	}
+	if len(params.RedirectURIs) == 0 || len(params.RedirectURIs) > maxOAuthRedirectURIs {
+		respondWithError(w, http.StatusBadRequest, "Between 1 and 10 redirect URIs are required", nil)
+		return
+	}
+	for _, uri := range params.RedirectURIs {
+		if err := oauth.ValidateRedirectURI(uri); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+
+	secret := ""
+	secretHash := sql.NullString{}
+	if params.Confidential {
+		secret, err = auth.MakeToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't register client", err)
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	client, err := cfg.db.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		OwnerID:      userID,
+		Name:         name,
+		RedirectUris: params.RedirectURIs,
+		SecretHash:   secretHash,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't register client", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		OAuthClient:  oauthClientFromDB(client),
+		ClientSecret: secret,
+	})
+}
+
+// handles GET /api/oauth/clients, listing the apps the logged-in user has registered:
+func (cfg *apiConfig) handlerOAuthClientsList(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scope.Account)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	dbClients, err := cfg.db.ListOAuthClients(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get clients", err)
+		return
+	}
+	clients := make([]OAuthClient, 0, len(dbClients))
+	for _, c := range dbClients {
+		clients = append(clients, oauthClientFromDB(c))
+	}
+	respondWithJSON(w, http.StatusOK, clients)
+}
+
+// handles DELETE /api/oauth/clients/{clientID}. Every token the app holds stops working with it:
+func (cfg *apiConfig) handlerOAuthClientsDelete(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scope.Account)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid client ID", err)
+		return
+	}
+
+	rows, err := cfg.db.DeleteOAuthClient(r.Context(), database.DeleteOAuthClientParams{
+		ID:      clientID,
+		OwnerID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete client", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't find client", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
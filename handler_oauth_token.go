@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/oauth"
+	"github.com/craigbucher/learn-http-servers/internal/scope"
+	"github.com/google/uuid"
+)
+
+// apps hold on to refresh tokens so the user doesn't have to approve them again; each one is single
+// use and replaced every time it's redeemed:
+const oauthRefreshTokenExpiry = 30 * 24 * time.Hour
+
+// used codes and dead tokens are kept this long after they expire, so a replay is still recognised:
+const oauthRetention = 24 * time.Hour
+
+// the token kinds stored in oauth_tokens:
+const (
+	oauthKindAccess  = "access"
+	oauthKindRefresh = "refresh"
+)
+
+// respondWithOAuthError writes an error in the shape RFC 6749 section 5.2 asks for:
+func respondWithOAuthError(w http.ResponseWriter, code int, oauthErr *oauth.Error) {
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, oauthErr)
+}
+
+// respondWithOAuthServerError logs err and answers 500, still in the RFC 6749 shape so the app's
+// OAuth library can make sense of it:
+func respondWithOAuthServerError(w http.ResponseWriter, err error) {
+	log.Printf("OAuth server error: %s", err)
+	respondWithOAuthError(w, http.StatusInternalServerError, oauth.NewError(oauth.ErrServerError, "something went wrong, try again later"))
+}
+
+// authenticateOAuthClient works out which client is calling the token, introspection or revocation
+// endpoint. Confidential clients send their secret with HTTP Basic auth or in the form; public
+// clients only send client_id, and are held to PKCE instead:
+func (cfg *apiConfig) authenticateOAuthClient(r *http.Request) (database.OauthClient, *oauth.Error) {
+	clientIDParam, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 has both halves form-encoded before they're put in the header:
+		clientIDParam, _ = url.QueryUnescape(clientIDParam)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientIDParam = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	invalid := oauth.NewError(oauth.ErrInvalidClient, "client authentication failed")
+	clientID, err := uuid.Parse(clientIDParam)
+	if err != nil {
+		return database.OauthClient{}, invalid
+	}
+	client, err := cfg.db.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, invalid
+	}
+	if !client.SecretHash.Valid {
+		if secret != "" {
+			return database.OauthClient{}, invalid
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash.String)) != 1 {
+		return database.OauthClient{}, invalid
+	}
+	return client, nil
+}
+
+// handles POST /oauth/token, where apps trade an authorization code (or a refresh token) for tokens:
+func (cfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, oauth.NewError(oauth.ErrInvalidRequest, "couldn't parse form"))
+		return
+	}
+	client, oauthErr := cfg.authenticateOAuthClient(r)
+	if oauthErr != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, oauthErr)
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		cfg.exchangeAuthorizationCode(w, r, client)
+	case "refresh_token":
+		cfg.exchangeOAuthRefreshToken(w, r, client)
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, oauth.NewError(oauth.ErrUnsupportedGrantType, "grant_type must be authorization_code or refresh_token"))
+	}
+}
+
+func (cfg *apiConfig) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	invalidGrant := oauth.NewError(oauth.ErrInvalidGrant, "the authorization code is invalid, expired or already used")
+	codeHash := auth.HashToken(r.PostForm.Get("code"))
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithOAuthServerError(w, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	code, err := qtx.ConsumeOAuthAuthorizationCode(r.Context(), codeHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		// a code that exists but can't be consumed has probably been stolen and replayed, so
+		// everything issued from it is revoked (RFC 6749 section 4.1.2):
+		cfg.revokeOAuthGrantForCode(r.Context(), codeHash)
+		respondWithOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	}
+	if err != nil {
+		respondWithOAuthServerError(w, err)
+		return
+	}
+	// the code has to come back from the app it was issued to, with the same redirect URI and the
+	// verifier for its PKCE challenge:
+	redirectURI := r.PostForm.Get("redirect_uri")
+	if redirectURI == "" && len(client.RedirectUris) == 1 {
+		redirectURI = client.RedirectUris[0]
+	}
+	if code.ClientID != client.ID || code.RedirectUri != redirectURI ||
+		!oauth.VerifyCodeVerifier(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		// commit anyway, so the code is used up and can't be tried again:
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error using up authorization code: %s", err)
+		}
+		respondWithOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	}
+
+	resp, err := cfg.issueOAuthTokens(r.Context(), qtx, code.GrantID, client.ID, code.UserID, code.Scopes, code.Scopes)
+	if err != nil {
+		respondWithOAuthServerError(w, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithOAuthServerError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) exchangeOAuthRefreshToken(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	invalidGrant := oauth.NewError(oauth.ErrInvalidGrant, "the refresh token is invalid, expired or revoked")
+	tokenHash := auth.HashToken(r.PostForm.Get("refresh_token"))
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithOAuthServerError(w, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	old, err := qtx.ConsumeOAuthRefreshToken(r.Context(), tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		// a refresh token that's already been swapped for a new one is being replayed, so one of the
+		// two holders is an attacker and neither gets to keep the grant:
+		if token, err := cfg.db.GetOAuthToken(r.Context(), tokenHash); err == nil && token.Kind == oauthKindRefresh && token.RevokedAt.Valid {
+			if err := cfg.db.RevokeOAuthGrant(r.Context(), token.GrantID); err != nil {
+				log.Printf("Error revoking replayed OAuth grant %s: %s", token.GrantID, err)
+			}
+		}
+		respondWithOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	}
+	if err != nil {
+		respondWithOAuthServerError(w, err)
+		return
+	}
+	if old.ClientID != client.ID {
+		respondWithOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	}
+	// deleted accounts can't be looked up, so their grants end here:
+	if _, err := qtx.GetUserByID(r.Context(), old.UserID); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	}
+
+	// the app may ask for fewer scopes than it was granted, but never more. Only the new access token
+	// is narrowed; the refresh token keeps the whole grant, so a later refresh can ask for the rest
+	// again (RFC 6749 section 6):
+	scopes := old.Scopes
+	if s := r.PostForm.Get("scope"); s != "" {
+		requested, err := oauth.ParseScope(s)
+		if err != nil {
+			var oauthErr *oauth.Error
+			errors.As(err, &oauthErr)
+			respondWithOAuthError(w, http.StatusBadRequest, oauthErr)
+			return
+		}
+		scopes = make([]string, 0, len(requested))
+		for _, rs := range requested {
+			if !slices.Contains(old.Scopes, string(rs)) {
+				respondWithOAuthError(w, http.StatusBadRequest, oauth.NewError(oauth.ErrInvalidScope, "%q wasn't granted", rs))
+				return
+			}
+			scopes = append(scopes, string(rs))
+		}
+	}
+
+	resp, err := cfg.issueOAuthTokens(r.Context(), qtx, old.GrantID, client.ID, old.UserID, old.Scopes, scopes)
+	if err != nil {
+		respondWithOAuthServerError(w, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithOAuthServerError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// oauthTokenResponse is the successful token response from RFC 6749 section 5.1:
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// issueOAuthTokens creates a new access and refresh token pair for a grant. The refresh token gets
+// every scope granted; the access token gets scopes, which may be fewer:
+func (cfg *apiConfig) issueOAuthTokens(ctx context.Context, qtx *database.Queries, grantID, clientID, userID uuid.UUID, granted, scopes []string) (oauthTokenResponse, error) {
+	accessToken, err := oauth.NewAccessToken()
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+	refreshToken, err := oauth.NewRefreshToken()
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+	for _, t := range []struct {
+		token, kind string
+		scopes      []string
+		expiry      time.Duration
+	}{
+		{accessToken, oauthKindAccess, scopes, accessTokenExpiry},
+		{refreshToken, oauthKindRefresh, granted, oauthRefreshTokenExpiry},
+	} {
+		err := qtx.CreateOAuthToken(ctx, database.CreateOAuthTokenParams{
+			TokenHash:        auth.HashToken(t.token),
+			Kind:             t.kind,
+			GrantID:          grantID,
+			ClientID:         clientID,
+			UserID:           userID,
+			Scopes:           t.scopes,
+			ExpiresInSeconds: t.expiry.Seconds(),
+		})
+		if err != nil {
+			return oauthTokenResponse{}, err
+		}
+	}
+	return oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenExpiry.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        oauth.FormatScope(scopes),
+	}, nil
+}
+
+// revokeOAuthGrantForCode revokes every token issued from an authorization code, if it exists:
+func (cfg *apiConfig) revokeOAuthGrantForCode(ctx context.Context, codeHash string) {
+	grantID, err := cfg.db.GetOAuthAuthorizationCodeGrant(ctx, codeHash)
+	if err != nil {
+		return
+	}
+	if err := cfg.db.RevokeOAuthGrant(ctx, grantID); err != nil {
+		log.Printf("Error revoking OAuth grant %s: %s", grantID, err)
+	}
+}
+
+// handles POST /oauth/introspect (RFC 7662), which tells a client whether a token is still good and
+// what it's for. Clients can only inspect their own tokens; anyone else's look inactive:
+func (cfg *apiConfig) handlerOAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		ClientID  string `json:"client_id,omitempty"`
+		Username  string `json:"username,omitempty"`
+		Subject   string `json:"sub,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+		IssuedAt  int64  `json:"iat,omitempty"`
+		ExpiresAt int64  `json:"exp,omitempty"`
+	}
+
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, oauth.NewError(oauth.ErrInvalidRequest, "couldn't parse form"))
+		return
+	}
+	client, oauthErr := cfg.authenticateOAuthClient(r)
+	if oauthErr != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, oauthErr)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	token, err := cfg.db.GetActiveOAuthToken(r.Context(), auth.HashToken(r.PostForm.Get("token")))
+	if err != nil || token.ClientID != client.ID {
+		respondWithJSON(w, http.StatusOK, response{Active: false})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Active:    true,
+		Scope:     oauth.FormatScope(token.Scopes),
+		ClientID:  token.ClientID.String(),
+		Username:  token.Handle,
+		Subject:   token.UserID.String(),
+		TokenType: token.Kind + "_token",
+		IssuedAt:  token.CreatedAt.Unix(),
+		ExpiresAt: token.ExpiresAt.Unix(),
+	})
+}
+
+// handles POST /oauth/revoke (RFC 7009). Revoking a refresh token ends the whole grant, access
+// tokens included. Unknown tokens get the same 200 as known ones, as the spec asks:
+func (cfg *apiConfig) handlerOAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, oauth.NewError(oauth.ErrInvalidRequest, "couldn't parse form"))
+		return
+	}
+	client, oauthErr := cfg.authenticateOAuthClient(r)
+	if oauthErr != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, oauthErr)
+		return
+	}
+
+	tokenHash := auth.HashToken(r.PostForm.Get("token"))
+	token, err := cfg.db.GetOAuthToken(r.Context(), tokenHash)
+	if err != nil || token.ClientID != client.ID {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if token.Kind == oauthKindRefresh {
+		err = cfg.db.RevokeOAuthGrant(r.Context(), token.GrantID)
+	} else {
+		err = cfg.db.RevokeOAuthToken(r.Context(), tokenHash)
+	}
+	if err != nil {
+		respondWithOAuthServerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handles GET /.well-known/oauth-authorization-server (RFC 8414), so client libraries can find
+// the endpoints above on their own:
+func (cfg *apiConfig) handlerOAuthMetadata(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Issuer                            string   `json:"issuer"`
+		AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+		TokenEndpoint                     string   `json:"token_endpoint"`
+		IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+		RevocationEndpoint                string   `json:"revocation_endpoint"`
+		ResponseTypesSupported            []string `json:"response_types_supported"`
+		GrantTypesSupported               []string `json:"grant_types_supported"`
+		CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+		TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+		ScopesSupported                   []string `json:"scopes_supported"`
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Issuer:                            cfg.publicURL,
+		AuthorizationEndpoint:             cfg.publicURL + "/oauth/authorize",
+		TokenEndpoint:                     cfg.publicURL + "/oauth/token",
+		IntrospectionEndpoint:             cfg.publicURL + "/oauth/introspect",
+		RevocationEndpoint:                cfg.publicURL + "/oauth/revoke",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		ScopesSupported:                   scope.Strings(scope.Grantable()),
+	})
+}
+
+// cleanupOAuth deletes codes and tokens that expired more than oauthRetention ago:
+func (cfg *apiConfig) cleanupOAuth(ctx context.Context) error {
+	if err := cfg.db.DeleteStaleOAuthCodes(ctx, oauthRetention.Seconds()); err != nil {
+		return err
+	}
+	return cfg.db.DeleteStaleOAuthTokens(ctx, oauthRetention.Seconds())
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/mailer"
+)
+
+// a reset link is only good for a short while, since the inbox it sits in might not stay private:
+const passwordResetExpiry = time.Hour
+
+// how many reset emails can be asked for in passwordResetWindow, per address and per client IP, so
+// the endpoint can't be used to flood someone's inbox. The IP limit is looser because many people
+// can share one:
+const (
+	passwordResetWindow     = time.Hour
+	passwordResetEmailLimit = 3
+	passwordResetIPLimit    = 20
+)
+
+// handles POST /api/password/forgot. It answers 202 whether or not the email belongs to an account,
+// and does the lookup after responding so the timing doesn't give it away either:
+func (cfg *apiConfig) handlerPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// the limits are counted whether or not the address has an account, so a 429 gives nothing away:
+	if !cfg.checkPasswordResetAllowed(w, r, params.Email) {
+		return
+	}
+
+	// the request's context is cancelled as soon as we respond, so the work gets its own:
+	go func() {
+		if err := cfg.sendPasswordReset(context.Background(), params.Email); err != nil {
+			log.Printf("Error sending password reset: %s", err)
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// checkPasswordResetAllowed counts a reset request against the email and the client's IP, and
+// responds with 429 and returns false if either has asked too often. The counts share the
+// login_failures table (see login_throttle.go) under their own keys:
+func (cfg *apiConfig) checkPasswordResetAllowed(w http.ResponseWriter, r *http.Request, email string) bool {
+	for _, k := range []struct {
+		key   string
+		limit int32
+	}{
+		{"reset:" + accountLoginKey(email), passwordResetEmailLimit},
+		{"reset:" + ipLoginKey(r), passwordResetIPLimit},
+	} {
+		requests, err := cfg.db.RecordLoginAttempt(r.Context(), database.RecordLoginAttemptParams{
+			Key:           k.key,
+			WindowSeconds: passwordResetWindow.Seconds(),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't send password reset", err)
+			return false
+		}
+		if requests > k.limit {
+			w.Header().Set("Retry-After", strconv.Itoa(int(passwordResetWindow.Seconds())))
+			respondWithError(w, http.StatusTooManyRequests, "Too many password reset requests, try again later", nil)
+			return false
+		}
+	}
+	return true
+}
+
+// sendPasswordReset emails a reset link if email belongs to an account, and quietly does nothing
+// if it doesn't:
+func (cfg *apiConfig) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := cfg.db.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil
+	}
+
+	token, err := auth.MakeToken()
+	if err != nil {
+		return err
+	}
+	err = cfg.db.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash:        auth.HashToken(token),
+		UserID:           user.ID,
+		ExpiresInSeconds: passwordResetExpiry.Seconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	msg, err := mailer.Render("password_reset", user.Email, map[string]string{
+		"Name":      displayNameOrHandle(user),
+		"Link":      cfg.publicURL + "/reset-password?token=" + token,
+		"ExpiresIn": "hour",
+	})
+	if err != nil {
+		return err
+	}
+	return cfg.mailer.Send(ctx, msg)
+}
+
+// handles POST /api/password/reset with the token from the emailed link and the new password:
+func (cfg *apiConfig) handlerPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	userID, err := qtx.ConsumePasswordResetToken(r.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token", err)
+		return
+	}
+	// the policy needs the account's email and handle, so it's checked once we know whose token this
+	// is; returning here rolls back and leaves the token usable for another try:
+	user, err := qtx.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	if !cfg.checkPassword(w, params.Password, user.Email, user.Handle) {
+		return
+	}
+	hashedPassword, err := auth.HashPassword(params.Password)
+	if err != nil {
+		respondWithHashError(w, "Couldn't hash password", err)
+		return
+	}
+	rows, err := qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		ID:             userID,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	// no row means the account was deleted after the link was sent:
+	if rows == 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token", nil)
+		return
+	}
+	if err := qtx.InvalidatePasswordResetTokens(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	// whoever had the old password may have logged in with it, so end every session, along with
+	// any API keys they could have created and any apps they could have authorized:
+	if err := qtx.RevokeAllRefreshTokensForUser(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	if err := qtx.RevokeAllSessionsForUser(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	if err := qtx.RevokeAllAPIKeysForUser(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	if err := qtx.RevokeAllOAuthTokensForUser(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	err = cfg.writeAuditEvent(r.Context(), qtx, r, auditEvent{
+		Actor:  userID,
+		Action: auditPasswordReset,
+		Target: auditTarget("user", userID),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+)
+
+// refresh tokens last much longer than access tokens; the client trades one in at POST /api/refresh
+// whenever its access token expires:
+const refreshTokenExpiry = 60 * 24 * time.Hour
+
+// handles POST /api/refresh, with the refresh token sent as "Authorization: Bearer <token>":
+func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Token string `json:"token"`
+	}
+
+	refreshToken, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't find token", err)
+		return
+	}
+
+	// we only ever store the hash, so that's what we look up:
+	token, err := cfg.db.GetActiveRefreshToken(r.Context(), auth.HashToken(refreshToken))
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		return
+	}
+	// a refresh is the closest thing to a page view a token client does, so it counts as the
+	// session being seen (only informational, so a failure doesn't fail the request):
+	if token.SessionID.Valid {
+		if err := cfg.db.TouchSession(r.Context(), token.SessionID.UUID); err != nil {
+			log.Printf("Couldn't update last use of session %s: %s", token.SessionID.UUID, err)
+		}
+	}
+
+	accessToken, err := cfg.makeAccessToken(r.Context(), token.UserID, token.SessionID.UUID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access token", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Token: accessToken,
+	})
+}
+
+// handles POST /api/revoke, which logs a refresh token out, ending its session:
+func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
+	refreshToken, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't find token", err)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	err = qtx.RevokeSessionForRefreshToken(r.Context(), auth.HashToken(refreshToken))
+	if err == nil {
+		err = qtx.RevokeRefreshToken(r.Context(), auth.HashToken(refreshToken))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/scope"
+	"github.com/google/uuid"
+)
+
+// API shape of a session: enough for the user to recognise each device they're logged in on:
+type Session struct {
+	ID uuid.UUID `json:"id"`
+	// "cookie" for the web app, "token" for clients that logged in for access and refresh tokens:
+	Kind       string    `json:"kind"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	// whether this is the session the request came with:
+	Current bool `json:"current"`
+}
+
+func sessionFromDB(s database.Session, currentID uuid.UUID) Session {
+	kind := "token"
+	if s.TokenHash.Valid {
+		kind = "cookie"
+	}
+	return Session{
+		ID:         s.ID,
+		Kind:       kind,
+		CreatedAt:  s.CreatedAt,
+		LastSeenAt: s.LastSeenAt,
+		ExpiresAt:  s.ExpiresAt,
+		UserAgent:  s.UserAgent,
+		IP:         s.Ip,
+		Current:    s.ID == currentID,
+	}
+}
+
+// handles GET /api/sessions, listing the devices the user is logged in on:
+func (cfg *apiConfig) handlerSessionsList(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticateClaims(r, scope.Account)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	dbSessions, err := cfg.db.ListSessions(r.Context(), claims.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get sessions", err)
+		return
+	}
+	sessions := make([]Session, 0, len(dbSessions))
+	for _, s := range dbSessions {
+		sessions = append(sessions, sessionFromDB(s, claims.SessionID))
+	}
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+// handles DELETE /api/sessions/{sessionID}, logging that device out: its refresh token stops working
+// straight away, and any access token it still holds within the hour. Ending the current session
+// also clears its cookies:
+func (cfg *apiConfig) handlerSessionsDelete(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticateClaims(r, scope.Account)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID", err)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't end session", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	// the user ID is part of the WHERE clause, so someone else's session looks like a missing one:
+	rows, err := qtx.RevokeSession(r.Context(), database.RevokeSessionParams{
+		ID:     sessionID,
+		UserID: claims.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't end session", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't find session", nil)
+		return
+	}
+	if err := qtx.RevokeRefreshTokensForSession(r.Context(), uuid.NullUUID{UUID: sessionID, Valid: true}); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't end session", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't end session", err)
+		return
+	}
+	if sessionID == claims.SessionID {
+		cfg.clearSessionCookies(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handles DELETE /api/sessions: "log out everywhere else". Every session but the one the request
+// came with ends, along with its refresh token:
+func (cfg *apiConfig) handlerSessionsDeleteOthers(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticateClaims(r, scope.Account)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't end sessions", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	// an access token from before sessions existed has no session to keep, so everything goes:
+	err = qtx.RevokeOtherSessionsForUser(r.Context(), database.RevokeOtherSessionsForUserParams{
+		UserID:        claims.UserID,
+		KeepSessionID: claims.SessionID,
+	})
+	if err == nil {
+		err = qtx.RevokeOtherRefreshTokensForUser(r.Context(), database.RevokeOtherRefreshTokensForUserParams{
+			UserID:        claims.UserID,
+			KeepSessionID: claims.SessionID,
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't end sessions", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handles POST /api/logout for the web app: ends the cookie session the request came with. It
+// succeeds even if the session had already ended, so the browser's cookies always get cleared:
+func (cfg *apiConfig) handlerLogout(w http.ResponseWriter, r *http.Request) {
+	if token, ok := sessionToken(r); ok {
+		err := cfg.db.RevokeSessionByHash(r.Context(), auth.HashToken(token))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't end session", err)
+			return
+		}
+	}
+	cfg.clearSessionCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cleanupSessions deletes sessions that can never be used again:
+func (cfg *apiConfig) cleanupSessions(ctx context.Context) error {
+	return cfg.db.DeleteDeadSessions(ctx)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/scope"
+	"github.com/craigbucher/learn-http-servers/internal/totp"
+)
+
+const (
+	// the name authenticator apps show next to the account:
+	totpIssuer = "Chirpy"
+	// how many recovery codes a user gets when they turn on two-factor auth:
+	recoveryCodeCount = 10
+)
+
+// handles POST /api/users/me/totp, the first step of turning on two-factor auth. It returns a new
+// secret for the user to add to their authenticator app, either by scanning the QR code from
+// GET /api/users/me/totp/qr.png or by typing the secret in:
+func (cfg *apiConfig) handlerTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+		QRCodeURL  string `json:"qr_code_url"`
+	}
+
+	userID, err := cfg.authenticate(r, scope.Account)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate secret", err)
+		return
+	}
+	rows, err := cfg.db.SetPendingTOTPSecret(r.Context(), database.SetPendingTOTPSecretParams{
+		ID:         userID,
+		TotpSecret: sql.NullString{String: secret, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start enrollment", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	// no-store: the secret is as sensitive as a password:
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, response{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(totpIssuer, user.Email, secret),
+		QRCodeURL:  "/api/users/me/totp/qr.png",
+	})
+}
+
+// handles GET /api/users/me/totp/qr.png, the pending secret as a QR code:
+func (cfg *apiConfig) handlerTOTPQRCode(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scope.Account)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	// once enrollment is confirmed the secret is never shown again:
+	if !user.TotpSecret.Valid || user.TotpEnabledAt.Valid {
+		respondWithError(w, http.StatusNotFound, "No two-factor enrollment in progress", nil)
+		return
+	}
+
+	png, err := totp.QRCode(totp.URI(totpIssuer, user.Email, user.TotpSecret.String), 256)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create QR code", err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(png)
+}
+
+// handles POST /api/users/me/totp/confirm. A correct code shows the app was set up properly, so
+// two-factor auth is switched on and the user gets their recovery codes (the only time they're
+// shown in plain text):
+func (cfg *apiConfig) handlerTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	userID, err := cfg.authenticate(r, scope.Account)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+	if !user.TotpSecret.Valid {
+		respondWithError(w, http.StatusBadRequest, "Start enrollment first", nil)
+		return
+	}
+	if !cfg.useTOTPCode(r, user, params.Code) {
+		respondWithError(w, http.StatusBadRequest, "Invalid code", nil)
+		return
+	}
+
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate recovery codes", err)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	if err := qtx.DeleteRecoveryCodes(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+	for _, code := range codes {
+		err := qtx.CreateRecoveryCode(r.Context(), database.CreateRecoveryCodeParams{
+			CodeHash: auth.HashToken(code),
+			UserID:   userID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+			return
+		}
+	}
+	if err := qtx.EnableTOTP(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, response{
+		RecoveryCodes: codes,
+	})
+}
+
+// handles POST /api/login/mfa, the second login step. The body carries the mfa_token from
+// POST /api/login and either a code from the authenticator app or one of the recovery codes:
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+		Session      string `json:"session"`
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodyBytes)
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	cookieSession, err := parseSessionMode(params.Session)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if cookieSession {
+		if err := cfg.checkLoginOrigin(r); err != nil {
+			respondWithError(w, http.StatusForbidden, "Cross-site login requests aren't allowed", err)
+			return
+		}
+	}
+
+	userID, err := auth.ValidateMFAChallengeToken(params.MFAToken, cfg.mfaSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token", err)
+		return
+	}
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil || !user.TotpEnabledAt.Valid {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token", err)
+		return
+	}
+	// wrong codes count towards the same lockout as wrong passwords, or the 2FA step could be
+	// brute-forced by logging in again for a fresh challenge each time:
+	attempt, ok := cfg.checkLoginAllowed(w, r, user.Email)
+	if !ok {
+		return
+	}
+
+	switch {
+	case params.Code != "":
+		if !cfg.useTOTPCode(r, user, params.Code) {
+			cfg.recordLoginFailure(r, attempt, &user)
+			respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+			return
+		}
+	case params.RecoveryCode != "":
+		rows, err := cfg.db.UseRecoveryCode(r.Context(), database.UseRecoveryCodeParams{
+			CodeHash: auth.HashToken(totp.NormalizeRecoveryCode(params.RecoveryCode)),
+			UserID:   userID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check recovery code", err)
+			return
+		}
+		if rows == 0 {
+			cfg.recordLoginFailure(r, attempt, &user)
+			respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+			return
+		}
+	default:
+		respondWithError(w, http.StatusBadRequest, "A code or recovery code is required", nil)
+		return
+	}
+
+	cfg.forgiveLoginAttempt(r.Context(), r)
+	cfg.completeLogin(w, r, user, cookieSession)
+}
+
+// useTOTPCode checks code against the user's secret and, if it's right, records its time step so
+// the same code can't be used again:
+func (cfg *apiConfig) useTOTPCode(r *http.Request, user database.User, code string) bool {
+	step, ok := totp.Validate(user.TotpSecret.String, code, time.Now())
+	if !ok {
+		return false
+	}
+	rows, err := cfg.db.UseTOTPStep(r.Context(), database.UseTOTPStepParams{
+		ID:   user.ID,
+		Step: step,
+	})
+	return err == nil && rows == 1
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/trends"
+)
+
+// API shape of a single trending hashtag:
+type Trend struct {
+	Tag      string  `json:"tag"`
+	Count    int     `json:"count"`
+	Expected float64 `json:"expected"`
+	Score    float64 `json:"score"`
+}
+
+// handles GET /api/trends. Trends are never computed per request; we just read whatever snapshot
+// the background aggregator produced last:
+func (cfg *apiConfig) handlerTrendsGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		GeneratedAt time.Time          `json:"generated_at"`
+		Windows     map[string][]Trend `json:"windows"`
+	}
+
+	snapshot := cfg.trends.Snapshot()
+	if snapshot == nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Trends are not available yet", nil)
+		return
+	}
+
+	windows := map[string][]Trend{}
+	for name, windowTrends := range snapshot.Windows {
+		windows[name] = []Trend{}
+		for _, trend := range windowTrends {
+			windows[name] = append(windows[name], Trend{
+				Tag:      trend.Tag,
+				Count:    trend.Count,
+				Expected: trend.Expected,
+				Score:    trend.Score,
+			})
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		GeneratedAt: snapshot.GeneratedAt,
+		Windows:     windows,
+	})
+}
+
+// recentChirpsForTrends is the trends.Source the aggregator reads chirps through:
+func (cfg *apiConfig) recentChirpsForTrends(ctx context.Context, maxAge time.Duration) ([]trends.Post, error) {
+	rows, err := cfg.db.GetRecentChirpBodies(ctx, maxAge.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	posts := make([]trends.Post, 0, len(rows))
+	for _, row := range rows {
+		posts = append(posts, trends.Post{
+			Body: row.Body,
+			Age:  time.Duration(row.AgeSeconds * float64(time.Second)),
+		})
+	}
+	return posts, nil
+}
@@ -12,11 +12,42 @@ import (
 // I created a User struct in my main package. When the database package returns a database.User, I map 
 // it to my main package's User struct before marshalling it to JSON so that I can control the JSON keys:
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"` 		// json means don't unmarshal from JSON, don't marshal to JSON (ignore)
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Email       string    `json:"email"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+	// null until the user has clicked the link we emailed them:
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// only set while the account is waiting out its deletion grace period:
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+	Password    string    `json:"-"` 		// json means don't unmarshal from JSON, don't marshal to JSON (ignore)
+}
+
+// userFromDB maps a database row to the User we send back to the account's owner:
+func userFromDB(user database.User) User {
+	var emailVerifiedAt, deletionScheduledAt *time.Time
+	if user.EmailVerifiedAt.Valid {
+		emailVerifiedAt = &user.EmailVerifiedAt.Time
+	}
+	if user.DeletionScheduledAt.Valid {
+		deletionScheduledAt = &user.DeletionScheduledAt.Time
+	}
+	return User{
+		ID:          user.ID,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		Email:       user.Email,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarUrl,
+		EmailVerifiedAt: emailVerifiedAt,
+		DeletionScheduledAt: deletionScheduledAt,
+	}
 }
 
 //  create a method on apiConfig that handles POST /api/users:
@@ -25,6 +56,7 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 	type parameters struct {
 		Password string `json:"password"`
 		Email string `json:"email"`
+		Handle string `json:"handle"`
 	}
 	// create a struct for the shape of the JSON response; it embeds your local User type (created above)
 	//  so its fields (id, created_at, etc.) are included in the output:
@@ -43,11 +75,39 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	email, err := validateEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	// the handle is optional at signup; without one the account gets a placeholder it can change 
+	// later with PATCH /api/users/me:
+	handle := params.Handle
+	if handle == "" {
+		handle, err = placeholderHandle()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
+			return
+		}
+	} else {
+		handle, err = validateHandle(handle)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+
+	// refuse empty, short, guessable or breached passwords, explaining each problem:
+	if !cfg.checkPassword(w, params.Password, email, params.Handle) {
+		return
+	}
+
 	// calls your bcrypt-based helper to turn the raw password into a secure hash. It returns the 
 	// hash string and an error:
 	hashedPassword, err := auth.HashPassword(params.Password)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		respondWithHashError(w, "Couldn't hash password", err)
 		return
 	}
 
@@ -55,22 +115,30 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		// r.Context(): ties the DB call to the HTTP request (cancels on timeout/abort)
 		// On success, user holds the newly created row (id, timestamps, email)
 	user, err := cfg.db.CreateUser(r.Context(), database.CreateUserParams{
-		Email:          params.Email,
+		Email:          email,
 		HashedPassword: hashedPassword,
+		Handle:         handle,
 	})
+	if isUniqueViolation(err, "users_handle_lower_idx") {
+		respondWithError(w, http.StatusConflict, "Handle is already taken", err)
+		return
+	}
+	if isUniqueViolation(err, "users_email_key") {
+		respondWithError(w, http.StatusConflict, "Email is already registered", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
 		return
 	}
 
+	// the account works straight away, but the address isn't trusted until the user proves it's 
+	// theirs:
+	cfg.sendVerificationEmailAsync(user)
+
 	// Set HTTP status to 201 Created
 	// Write a JSON body shaped like response, containing a User built from the DB user:
 	respondWithJSON(w, http.StatusCreated, response{
-		User: User{
-			ID:        user.ID,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
-			Email:     user.Email,
-		},
+		User: userFromDB(user),
 	})
 }
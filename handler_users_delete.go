@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/scope"
+)
+
+// handles DELETE /api/users/me. Nothing is deleted yet: the account is scheduled for deletion after
+// cfg.accountDeletionGrace, every session, API key and app authorization is revoked, and logging
+// back in before the deadline cancels the whole thing:
+func (cfg *apiConfig) handlerUsersDeleteMe(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+	type response struct {
+		DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+	}
+
+	claims, err := cfg.authenticateClaims(r, scope.Account)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	userID := claims.UserID
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	// a stolen access token alone shouldn't be enough to delete an account, so ask for the password
+	// again:
+	err = auth.CheckPasswordHash(params.Password, user.HashedPassword)
+	if errors.Is(err, auth.ErrHashBusy) {
+		respondWithHashError(w, "Couldn't check password", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password", err)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't schedule deletion", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	user, err = qtx.ScheduleUserDeletion(r.Context(), database.ScheduleUserDeletionParams{
+		ID:                 userID,
+		GracePeriodSeconds: cfg.accountDeletionGrace.Seconds(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't schedule deletion", err)
+		return
+	}
+	// every credential goes: refresh tokens and sessions here, and access tokens stop working
+	// because the account is now scheduled for deletion (see authenticateClaims):
+	err = qtx.RevokeAllRefreshTokensForUser(r.Context(), userID)
+	if err == nil {
+		err = qtx.RevokeAllSessionsForUser(r.Context(), userID)
+	}
+	if err == nil {
+		err = qtx.RevokeAllAPIKeysForUser(r.Context(), userID)
+	}
+	if err == nil {
+		err = qtx.RevokeAllOAuthTokensForUser(r.Context(), userID)
+	}
+	if err == nil {
+		actor, onBehalfOf := auditActor(claims)
+		err = cfg.writeAuditEvent(r.Context(), qtx, r, auditEvent{
+			Actor:      actor,
+			OnBehalfOf: onBehalfOf,
+			Action:     auditDeletionScheduled,
+			Target:     auditTarget("user", userID),
+			Details:    map[string]any{"deletion_scheduled_at": user.DeletionScheduledAt.Time},
+		})
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't schedule deletion", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't schedule deletion", err)
+		return
+	}
+
+	// 202 Accepted: the request is fine, but the work happens later:
+	respondWithJSON(w, http.StatusAccepted, response{
+		DeletionScheduledAt: user.DeletionScheduledAt.Time,
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/blobstore"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/scope"
+	"github.com/google/uuid"
+)
+
+// how long a download link handed out by the status endpoint works; the client can always poll
+// again for a fresh one while the archive itself hasn't expired:
+const exportLinkExpiry = 15 * time.Minute
+
+// API shape of a data export request:
+type DataExport struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Status      string     `json:"status"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Size        int64      `json:"size,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+func dataExportFromDB(e database.DataExport) DataExport {
+	export := DataExport{
+		ID:        e.ID,
+		CreatedAt: e.CreatedAt,
+		Status:    e.Status,
+		Size:      e.SizeBytes.Int64,
+	}
+	if e.CompletedAt.Valid {
+		export.CompletedAt = &e.CompletedAt.Time
+	}
+	if e.ExpiresAt.Valid {
+		export.ExpiresAt = &e.ExpiresAt.Time
+	}
+	return export
+}
+
+// handles POST /api/users/me/export. Building the archive can take a while, so this only queues it
+// and the client polls the returned export until its status is "ready":
+func (cfg *apiConfig) handlerUsersExportCreate(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scope.Account)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	// asking twice while one is still being built just returns the one in progress:
+	dbExport, err := cfg.db.GetActiveDataExport(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		dbExport, err = cfg.db.CreateDataExport(r.Context(), userID)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create export", err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/users/me/export/"+dbExport.ID.String())
+	respondWithJSON(w, http.StatusAccepted, dataExportFromDB(dbExport))
+}
+
+// handles GET /api/users/me/export/{exportID}. Once the export is ready the response includes a
+// short-lived download_url:
+func (cfg *apiConfig) handlerUsersExportGet(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scope.Account)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	exportID, err := uuid.Parse(r.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid export ID", err)
+		return
+	}
+
+	dbExport, err := cfg.db.GetDataExport(r.Context(), database.GetDataExportParams{
+		ID:     exportID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find export", err)
+		return
+	}
+
+	export := dataExportFromDB(dbExport)
+	// the cleanup job hasn't got round to this one yet, but its archive is past its date:
+	if dbExport.Status == "ready" && !dbExport.ExpiresAt.Time.After(time.Now()) {
+		respondWithError(w, http.StatusGone, "Export has expired", nil)
+		return
+	}
+	if dbExport.Status == "ready" {
+		// the link never outlives the archive:
+		expiry := min(exportLinkExpiry, time.Until(dbExport.ExpiresAt.Time))
+		export.DownloadURL, err = cfg.exportDownloadURL(r, dbExport, expiry)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create download link", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, export)
+}
+
+// exportDownloadURL presigns the archive in the blob store when it can (S3), and otherwise signs a
+// link to our own download endpoint:
+func (cfg *apiConfig) exportDownloadURL(r *http.Request, e database.DataExport, expiry time.Duration) (string, error) {
+	if presigner, ok := cfg.blobs.(blobstore.Presigner); ok {
+		return presigner.PresignGet(r.Context(), e.BlobKey.String, expiry)
+	}
+	expires := time.Now().Add(expiry).Unix()
+	return fmt.Sprintf("/api/users/me/export/%s/download?expires=%d&signature=%s",
+		e.ID, expires, cfg.exportSignature(e.ID, expires)), nil
+}
+
+// exportSignature is an HMAC over the export ID and the link's expiry time, so neither can be
+// changed without the server's secret:
+func (cfg *apiConfig) exportSignature(exportID uuid.UUID, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(cfg.exportSecret))
+	fmt.Fprintf(mac, "data-export:%s:%d", exportID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handles GET /api/users/me/export/{exportID}/download. It takes no access token, so the link can
+// be opened straight from a browser; the signature is what proves the user was given it:
+func (cfg *apiConfig) handlerUsersExportDownload(w http.ResponseWriter, r *http.Request) {
+	exportID, err := uuid.Parse(r.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid export ID", err)
+		return
+	}
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid download link", err)
+		return
+	}
+	// hmac.Equal compares in constant time, so the signature can't be guessed byte by byte:
+	signature := r.URL.Query().Get("signature")
+	if !hmac.Equal([]byte(signature), []byte(cfg.exportSignature(exportID, expires))) {
+		respondWithError(w, http.StatusForbidden, "Invalid download link", nil)
+		return
+	}
+	if time.Now().Unix() > expires {
+		respondWithError(w, http.StatusGone, "Download link has expired", nil)
+		return
+	}
+
+	dbExport, err := cfg.db.GetReadyDataExport(r.Context(), exportID)
+	if err != nil {
+		respondWithError(w, http.StatusGone, "Export is no longer available", err)
+		return
+	}
+	blob, err := cfg.blobs.Get(r.Context(), dbExport.BlobKey.String)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read export", err)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Length", strconv.FormatInt(dbExport.SizeBytes.Int64, 10))
+	w.Header().Set("Content-Disposition", `attachment; filename="chirpy-export.zip"`)
+	// personal data: never let a shared cache keep a copy:
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, blob)
+}
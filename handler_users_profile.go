@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/craigbucher/learn-http-servers/internal/audit"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/scope"
+	"github.com/google/uuid"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxAvatarURLLength   = 2048
+)
+
+// handles are 3-15 letters, digits or underscores:
+var handleRegexp = regexp.MustCompile(`^[A-Za-z0-9_]{3,15}$`)
+
+// reservedHandles can't be registered: they'd collide with our own routes, or let someone pose as
+// staff. Compared in lowercase:
+var reservedHandles = map[string]struct{}{
+	"about":         {},
+	"admin":         {},
+	"administrator": {},
+	"api":           {},
+	"app":           {},
+	"chirpy":        {},
+	"help":          {},
+	"login":         {},
+	"logout":        {},
+	"me":            {},
+	"mod":           {},
+	"moderator":     {},
+	"null":          {},
+	"official":      {},
+	"root":          {},
+	"security":      {},
+	"settings":      {},
+	"signup":        {},
+	"staff":         {},
+	"support":       {},
+	"system":        {},
+	"undefined":     {},
+}
+
+// A Profile is the public face of a user. Unlike User it never includes the email address:
+type Profile struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+}
+
+// validateHandle checks a requested handle and returns it without any leading "@":
+func validateHandle(handle string) (string, error) {
+	handle = strings.TrimPrefix(handle, "@")
+	if !handleRegexp.MatchString(handle) {
+		return "", errors.New("Handle must be 3-15 letters, numbers or underscores")
+	}
+	// an all-digit handle would be easy to confuse with an ID:
+	if strings.Trim(handle, "0123456789") == "" {
+		return "", errors.New("Handle must contain a letter or underscore")
+	}
+	if _, ok := reservedHandles[strings.ToLower(handle)]; ok {
+		return "", errors.New("Handle is reserved")
+	}
+	return handle, nil
+}
+
+// placeholderHandle makes a random handle like "user_3fa9c01b2e" for accounts created without one:
+func placeholderHandle() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "user_" + hex.EncodeToString(b), nil
+}
+
+// validateAvatarURL accepts absolute http(s) URLs, or a path to media uploaded to Chirpy itself:
+func validateAvatarURL(avatarURL string) error {
+	if avatarURL == "" || strings.HasPrefix(avatarURL, "/api/media/") {
+		return nil
+	}
+	if len(avatarURL) > maxAvatarURLLength {
+		return errors.New("Avatar URL is too long")
+	}
+	u, err := url.Parse(avatarURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("Avatar URL must be an http(s) URL")
+	}
+	return nil
+}
+
+// displayNameOrHandle is what we call a user in emails: their display name if they've set one:
+func displayNameOrHandle(user database.User) string {
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+	return "@" + user.Handle
+}
+
+// handles GET /api/users/{handle}; the handle may be written with or without the "@":
+func (cfg *apiConfig) handlerUsersGetProfile(w http.ResponseWriter, r *http.Request) {
+	handle := strings.TrimPrefix(r.PathValue("handle"), "@")
+	user, err := cfg.db.GetUserByHandle(r.Context(), handle)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Profile{
+		ID:          user.ID,
+		CreatedAt:   user.CreatedAt,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarUrl,
+	})
+}
+
+// handles GET /api/users/me, so a script holding an API key can find out whose it is:
+func (cfg *apiConfig) handlerUsersGetMe(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		User
+	}
+
+	userID, err := cfg.authenticate(r, scope.ProfileRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		User: userFromDB(user),
+	})
+}
+
+// handles PATCH /api/users/me. Only the fields present in the body are changed; sending "" clears
+// the display name, bio or avatar. Changing the email address marks it unverified and sends a new 
+// verification link:
+func (cfg *apiConfig) handlerUsersUpdateMe(w http.ResponseWriter, r *http.Request) {
+	// pointers let us tell "not sent" (nil) apart from "sent as empty":
+	type parameters struct {
+		Email       *string `json:"email"`
+		Handle      *string `json:"handle"`
+		DisplayName *string `json:"display_name"`
+		Bio         *string `json:"bio"`
+		AvatarURL   *string `json:"avatar_url"`
+	}
+	type response struct {
+		User
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// whoever controls the email address can reset the password, so changing it takes a real login,
+	// the same as changing the password: not an API key, an app's token, or an admin impersonating
+	// the user:
+	needed := scope.ProfileWrite
+	if params.Email != nil {
+		needed = scope.Account
+	}
+	claims, err := cfg.authenticateClaims(r, needed)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	userID := claims.UserID
+
+	update := database.UpdateUserProfileParams{ID: userID}
+	if params.Email != nil {
+		email, err := validateEmail(*params.Email)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		update.Email = sql.NullString{String: email, Valid: true}
+	}
+	if params.Handle != nil {
+		handle, err := validateHandle(*params.Handle)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		update.Handle = sql.NullString{String: handle, Valid: true}
+	}
+	if params.DisplayName != nil {
+		displayName := strings.TrimSpace(*params.DisplayName)
+		if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+			respondWithError(w, http.StatusBadRequest, "Display name is too long", nil)
+			return
+		}
+		update.DisplayName = sql.NullString{String: displayName, Valid: true}
+	}
+	if params.Bio != nil {
+		bio := strings.TrimSpace(*params.Bio)
+		if utf8.RuneCountInString(bio) > maxBioLength {
+			respondWithError(w, http.StatusBadRequest, "Bio is too long", nil)
+			return
+		}
+		update.Bio = sql.NullString{String: bio, Valid: true}
+	}
+	if params.AvatarURL != nil {
+		if err := validateAvatarURL(*params.AvatarURL); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		update.AvatarUrl = sql.NullString{String: *params.AvatarURL, Valid: true}
+	}
+
+	// the email address and handle are what identify an account, so changes to them are audited
+	// with the old values (see profileAuditDiff):
+	var before database.User
+	if params.Email != nil || params.Handle != nil {
+		before, err = cfg.db.GetUserByID(r.Context(), userID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+			return
+		}
+	}
+
+	user, err := cfg.db.UpdateUserProfile(r.Context(), update)
+	if isUniqueViolation(err, "users_handle_lower_idx") {
+		respondWithError(w, http.StatusConflict, "Handle is already taken", err)
+		return
+	}
+	if isUniqueViolation(err, "users_email_key") {
+		respondWithError(w, http.StatusConflict, "Email is already registered", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update profile", err)
+		return
+	}
+	if params.Email != nil || params.Handle != nil {
+		if diff := profileAuditDiff(before, user); len(diff) > 0 {
+			actor, onBehalfOf := auditActor(claims)
+			cfg.recordAudit(r, auditEvent{
+				Actor:      actor,
+				OnBehalfOf: onBehalfOf,
+				Action:     auditProfileUpdate,
+				Target:     auditTarget("user", userID),
+				Diff:       diff,
+			})
+		}
+	}
+	// only a new address needs verifying; resending for the same one would get round the limits on
+	// POST /api/users/verify/resend:
+	if params.Email != nil && user.Email != before.Email {
+		cfg.sendVerificationEmailAsync(user)
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		User: userFromDB(user),
+	})
+}
+
+// profileAuditDiff is what changed between before and after that the audit log records. Handles
+// are public anyway, but email addresses are only kept as hashes (see auditEmailHash):
+func profileAuditDiff(before, after database.User) map[string]audit.Change {
+	return audit.Diff(
+		map[string]any{"email_sha256": auditEmailHash(before.Email), "handle": before.Handle},
+		map[string]any{"email_sha256": auditEmailHash(after.Email), "handle": after.Handle},
+	)
+}
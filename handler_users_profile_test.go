@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestProfileAuditDiff(t *testing.T) {
+	before := database.User{Email: "old@example.com", Handle: "old"}
+	after := database.User{Email: "new@example.com", Handle: "new"}
+
+	diff := profileAuditDiff(before, after)
+	if _, ok := diff["email_sha256"]; !ok {
+		t.Errorf("diff %v doesn't record the email change", diff)
+	}
+	if _, ok := diff["handle"]; !ok {
+		t.Errorf("diff %v doesn't record the handle change", diff)
+	}
+	data, err := json.Marshal(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "@") {
+		t.Errorf("diff %s contains an email address", data)
+	}
+
+	if diff := profileAuditDiff(before, before); len(diff) != 0 {
+		t.Errorf("diff of an unchanged profile = %v, want none", diff)
+	}
+}
+
+func TestProfileAuditEventStored(t *testing.T) {
+	cfg := testDBConfig(t)
+	ctx := context.Background()
+
+	target := auditTarget("user", uuid.New())
+	err := cfg.writeAuditEventTx(ctx, nil, auditEvent{
+		Action: auditProfileUpdate,
+		Target: target,
+		Diff: profileAuditDiff(
+			database.User{Email: "old@example.com", Handle: "old"},
+			database.User{Email: "new@example.com", Handle: "old"},
+		),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := cfg.db.ListAuditEvents(ctx, database.ListAuditEventsParams{
+		Target:    sql.NullString{String: target, Valid: true},
+		PageLimit: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d audit events for %s, want 1", len(events), target)
+	}
+	for _, field := range [][]byte{events[0].Details, events[0].Diff} {
+		if strings.Contains(string(field), "@") {
+			t.Errorf("stored audit event contains an email address: %s", field)
+		}
+	}
+}
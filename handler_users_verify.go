@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/mailer"
+	"github.com/craigbucher/learn-http-servers/internal/scope"
+)
+
+const (
+	// verification links sit in an inbox until the user gets round to them, so they last a day:
+	emailVerificationExpiry = 24 * time.Hour
+	// resends are throttled to one a minute and a handful a day, so the endpoint can't be used to
+	// flood someone's inbox:
+	verificationResendCooldown = time.Minute
+	verificationDailyLimit     = 5
+)
+
+// validateEmail accepts a bare address like "walt@example.com" (no display name, no surrounding
+// spaces) and returns it unchanged:
+func validateEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "", errors.New("Invalid email address")
+	}
+	return email, nil
+}
+
+// sendVerificationEmail emails user a link that proves they own their current address:
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	token, err := auth.MakeToken()
+	if err != nil {
+		return err
+	}
+	err = cfg.db.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		TokenHash:        auth.HashToken(token),
+		UserID:           user.ID,
+		Email:            user.Email,
+		ExpiresInSeconds: emailVerificationExpiry.Seconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	msg, err := mailer.Render("email_verification", user.Email, map[string]string{
+		"Name": displayNameOrHandle(user),
+		"Link": cfg.publicURL + "/verify-email?token=" + token,
+	})
+	if err != nil {
+		return err
+	}
+	return cfg.mailer.Send(ctx, msg)
+}
+
+// sendVerificationEmailAsync is for signup and email changes, where a mail server hiccup shouldn't
+// fail the request (the user can always ask for a resend):
+func (cfg *apiConfig) sendVerificationEmailAsync(user database.User) {
+	go func() {
+		if err := cfg.sendVerificationEmail(context.Background(), user); err != nil {
+			log.Printf("Error sending verification email to user %s: %s", user.ID, err)
+		}
+	}()
+}
+
+// handles POST /api/users/verify with the token from the emailed link. It needs no access token,
+// so the link works on whichever device the user reads their mail on:
+func (cfg *apiConfig) handlerUsersVerify(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+	type response struct {
+		User
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	token, err := qtx.ConsumeEmailVerificationToken(r.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token", err)
+		return
+	}
+	// no row means the user has changed their address since this link was sent:
+	user, err := qtx.MarkEmailVerified(r.Context(), database.MarkEmailVerifiedParams{
+		ID:    token.UserID,
+		Email: token.Email,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		User: userFromDB(user),
+	})
+}
+
+// handles POST /api/users/verify/resend for the logged-in user:
+func (cfg *apiConfig) handlerUsersVerifyResend(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, scope.Account)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	if user.EmailVerifiedAt.Valid {
+		respondWithError(w, http.StatusConflict, "Email is already verified", nil)
+		return
+	}
+
+	recent, err := cfg.db.CountEmailVerificationTokensSince(r.Context(), database.CountEmailVerificationTokensSinceParams{
+		UserID:        userID,
+		WindowSeconds: verificationResendCooldown.Seconds(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email", err)
+		return
+	}
+	today, err := cfg.db.CountEmailVerificationTokensSince(r.Context(), database.CountEmailVerificationTokensSinceParams{
+		UserID:        userID,
+		WindowSeconds: (24 * time.Hour).Seconds(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email", err)
+		return
+	}
+	if recent > 0 || today >= verificationDailyLimit {
+		retryAfter := verificationResendCooldown
+		if today >= verificationDailyLimit {
+			retryAfter = time.Hour
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+		respondWithError(w, http.StatusTooManyRequests, "Too many verification emails, try again later", nil)
+		return
+	}
+
+	if err := cfg.sendVerificationEmail(r.Context(), user); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
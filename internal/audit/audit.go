@@ -0,0 +1,132 @@
+// Package audit holds the pieces of Chirpy's audit log that don't need a database: the hash chain
+// that makes tampering evident, and the diffs that record what an action changed.
+//
+// Each entry's hash covers the previous entry's hash as well as its own fields, so editing,
+// deleting or reordering any entry breaks every hash after it. The hashes are HMACs keyed with a
+// secret only the server holds, so someone who can write to the database can't just recompute
+// them after an edit. Cutting entries off the end leaves a chain that still adds up, which is why
+// the last hash should also be written down somewhere outside the database now and then.
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// An Entry is the part of an audit event the hash covers. Actor and OnBehalfOf are uuid.Nil when
+// they don't apply; Details and Diff may be empty:
+type Entry struct {
+	CreatedAt  time.Time
+	Actor      uuid.UUID
+	OnBehalfOf uuid.UUID
+	Action     string
+	Target     string
+	IP         string
+	RequestID  string
+	Details    json.RawMessage
+	Diff       json.RawMessage
+}
+
+// Hash chains e onto the entry whose hash is prev ("" for the first entry), keyed with key. The
+// fields are encoded as a JSON array, so no value can run into the next; JSON is re-encoded first,
+// since the database doesn't give it back byte for byte:
+func Hash(key []byte, prev string, e Entry) (string, error) {
+	details, err := canonicalJSON(e.Details)
+	if err != nil {
+		return "", fmt.Errorf("details: %w", err)
+	}
+	diff, err := canonicalJSON(e.Diff)
+	if err != nil {
+		return "", fmt.Errorf("diff: %w", err)
+	}
+	encoded, err := json.Marshal([]any{
+		prev,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		e.Actor,
+		e.OnBehalfOf,
+		e.Action,
+		e.Target,
+		e.IP,
+		e.RequestID,
+		details,
+		diff,
+	})
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(encoded)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// canonicalJSON re-encodes raw with sorted keys and no whitespace. Numbers go through float64, so
+// 100 and 1e2 (which Postgres may swap for each other) come out the same:
+func canonicalJSON(raw json.RawMessage) (json.RawMessage, error) {
+	if len(raw) == 0 {
+		return json.RawMessage("null"), nil
+	}
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// A Change is one field's value before and after an action:
+type Change struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// Diff compares two snapshots of the same thing, given as maps of field name to value, and returns
+// the fields that differ. A field missing from one side counts as nil there:
+func Diff(before, after map[string]any) map[string]Change {
+	changes := map[string]Change{}
+	for field, from := range before {
+		if to := after[field]; !reflect.DeepEqual(from, to) {
+			changes[field] = Change{From: from, To: to}
+		}
+	}
+	for field, to := range after {
+		if _, ok := before[field]; !ok && to != nil {
+			changes[field] = Change{From: nil, To: to}
+		}
+	}
+	return changes
+}
+
+// A Link is what Verify needs to know about each entry: the hashes the database holds for it,
+// and the entry itself:
+type Link struct {
+	ID       int64
+	PrevHash string
+	Hash     string
+	Entry    Entry
+}
+
+// Verify walks links in order, starting from the hash of the entry before the first one ("" if
+// it's the very first), and returns the ID of the first entry whose hashes don't add up with key,
+// or 0 if they all do. The last hash is returned so a long log can be checked a page at a time:
+func Verify(key []byte, prev string, links []Link) (badID int64, last string, err error) {
+	for _, l := range links {
+		if l.PrevHash != prev {
+			return l.ID, prev, nil
+		}
+		want, err := Hash(key, prev, l.Entry)
+		if err != nil {
+			return l.ID, prev, err
+		}
+		if l.Hash != want {
+			return l.ID, prev, nil
+		}
+		prev = l.Hash
+	}
+	return 0, prev, nil
+}
@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var testKey = []byte("audit-test-key")
+
+func chain(t *testing.T, entries ...Entry) []Link {
+	t.Helper()
+	links := []Link{}
+	prev := ""
+	for i, e := range entries {
+		h, err := Hash(testKey, prev, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		links = append(links, Link{ID: int64(i + 1), PrevHash: prev, Hash: h, Entry: e})
+		prev = h
+	}
+	return links
+}
+
+func TestHashIgnoresJSONFormatting(t *testing.T) {
+	e := Entry{
+		CreatedAt: time.Date(2026, 10, 18, 12, 0, 0, 123456000, time.UTC),
+		Actor:     uuid.New(),
+		Action:    "user.login",
+		Details:   json.RawMessage(`{"method":"password","status":200}`),
+	}
+	h1, _ := Hash(testKey, "", e)
+
+	// what Postgres hands back for the same JSONB, and the same time in another zone:
+	e.Details = json.RawMessage(`{"status": 2e2, "method": "password"}`)
+	e.CreatedAt = e.CreatedAt.In(time.FixedZone("EST", -5*3600))
+	h2, _ := Hash(testKey, "", e)
+	if h1 != h2 {
+		t.Error("Hash() changed with JSON formatting or time zone")
+	}
+
+	e.Details = json.RawMessage(`{"status": 201, "method": "password"}`)
+	if h3, _ := Hash(testKey, "", e); h3 == h1 {
+		t.Error("Hash() didn't change with the details")
+	}
+	if h4, _ := Hash(testKey, "other", e); h4 == h1 {
+		t.Error("Hash() didn't change with the previous hash")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	entries := []Entry{
+		{Action: "user.login", Target: "user:1"},
+		{Action: "user.password_reset", Target: "user:1"},
+		{Action: "admin.reset", Target: "database"},
+	}
+
+	tests := []struct {
+		name   string
+		tamper func([]Link)
+		wantID int64
+	}{
+		{"intact", func([]Link) {}, 0},
+		{"field edited", func(l []Link) { l[1].Entry.Target = "user:2" }, 2},
+		{"hash recomputed after edit", func(l []Link) {
+			l[1].Entry.Target = "user:2"
+			l[1].Hash, _ = Hash(testKey, l[1].PrevHash, l[1].Entry)
+		}, 3},
+		{"entry removed", func(l []Link) { l[1] = l[2] }, 3},
+		{"hashes recomputed without the key", func(l []Link) {
+			l[1].Entry.Target = "user:2"
+			l[1].Hash, _ = Hash([]byte("guessed-key"), l[1].PrevHash, l[1].Entry)
+			l[2].PrevHash = l[1].Hash
+			l[2].Hash, _ = Hash([]byte("guessed-key"), l[2].PrevHash, l[2].Entry)
+		}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			links := chain(t, entries...)
+			tt.tamper(links)
+			badID, _, err := Verify(testKey, "", links)
+			if err != nil || badID != tt.wantID {
+				t.Errorf("Verify() = %d, %v; want %d", badID, err, tt.wantID)
+			}
+		})
+	}
+
+	// checking a page at a time gives the same answer as all at once:
+	links := chain(t, entries...)
+	_, last, _ := Verify(testKey, "", links[:2])
+	if badID, _, _ := Verify(testKey, last, links[2:]); badID != 0 {
+		t.Errorf("Verify() of the second page = %d, want 0", badID)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	got := Diff(
+		map[string]any{"email": "a@example.com", "handle": "walt", "roles": []string{"admin"}},
+		map[string]any{"email": "b@example.com", "handle": "walt", "roles": []string{}, "bio": "hi"},
+	)
+	if len(got) != 3 {
+		t.Fatalf("Diff() = %v, want changes to email, roles and bio", got)
+	}
+	if got["email"] != (Change{From: "a@example.com", To: "b@example.com"}) {
+		t.Errorf("Diff()[email] = %v", got["email"])
+	}
+	if _, ok := got["handle"]; ok {
+		t.Error("Diff() included an unchanged field")
+	}
+	if got["bio"].From != nil || got["bio"].To != "hi" {
+		t.Errorf("Diff()[bio] = %v", got["bio"])
+	}
+}
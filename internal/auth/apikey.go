@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// every API key starts with this, so leaked keys are easy to spot in logs and secret scanners:
+const apiKeyPrefix = "chirpy_"
+
+// MakeAPIKey returns a new key like "chirpy_1a2b3c4d_<64 hex chars>" and its visible prefix
+// ("chirpy_1a2b3c4d"). Only the prefix and HashToken(key) should be stored; the prefix lets users
+// tell their keys apart without us keeping anything that would work as a key:
+func MakeAPIKey() (key, prefix string, err error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	secret, err := MakeToken()
+	if err != nil {
+		return "", "", err
+	}
+	prefix = apiKeyPrefix + hex.EncodeToString(id)
+	return prefix + "_" + secret, prefix, nil
+}
+
+// GetAPIKey pulls the key out of an "Authorization: ApiKey <key>" header:
+func GetAPIKey(headers http.Header) (string, error) {
+	authHeader := headers.Get("Authorization")
+	if authHeader == "" {
+		return "", ErrNoAuthHeader
+	}
+	scheme, key, ok := strings.Cut(authHeader, " ")
+	key = strings.TrimSpace(key)
+	if !ok || !strings.EqualFold(scheme, "ApiKey") || !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", errors.New("malformed authorization header")
+	}
+	return key, nil
+}
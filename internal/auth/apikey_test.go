@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMakeAPIKey(t *testing.T) {
+	key, prefix, err := MakeAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, prefix+"_") || len(prefix) != len("chirpy_")+8 {
+		t.Errorf("MakeAPIKey() = %q, %q; want the key to start with the prefix", key, prefix)
+	}
+	other, _, _ := MakeAPIKey()
+	if other == key {
+		t.Error("MakeAPIKey() returned the same key twice")
+	}
+
+	got, err := GetAPIKey(http.Header{"Authorization": []string{"ApiKey " + key}})
+	if err != nil || got != key {
+		t.Errorf("GetAPIKey() = %q, %v; want %q", got, err, key)
+	}
+	for _, header := range []string{"Bearer " + key, "ApiKey not-a-key", "ApiKey"} {
+		if _, err := GetAPIKey(http.Header{"Authorization": []string{header}}); err == nil {
+			t.Errorf("GetAPIKey(%q) succeeded, want error", header)
+		}
+	}
+}
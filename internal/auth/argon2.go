@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+var errMismatchedPassword = errors.New("password does not match hash")
+
+// ErrHashBusy means so many Argon2id hashes are already running that another one would use more
+// memory than HashConfig.Argon2MaxMemory allows. Handlers answer 503 so the client tries again:
+var ErrHashBusy = errors.New("too many password hashes in progress")
+
+// how long a hash waits for a slot before giving up with ErrHashBusy:
+var argon2SlotWait = 2 * time.Second
+
+// acquireArgon2Slot waits for room to run one more Argon2id hash, so a burst of logins can't run the
+// server out of memory. Call the returned func when the hash is done:
+func acquireArgon2Slot() (func(), error) {
+	// keep hold of this channel in case SetHashConfig swaps in a new one meanwhile:
+	slots := argon2Slots
+	timer := time.NewTimer(argon2SlotWait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-timer.C:
+		return nil, ErrHashBusy
+	}
+}
+
+// Argon2Params are the knobs of Argon2id. Memory is in KiB; more memory makes each guess expensive
+// on GPUs, which is the point of choosing Argon2id over bcrypt:
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the "second recommended option" of RFC 9106 scaled to 64 MiB, which
+// takes well under 100ms on a typical server:
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func (p Argon2Params) validate() error {
+	if p.Memory < 8*uint32(p.Parallelism) || p.Iterations < 1 || p.Parallelism < 1 || p.SaltLength < 8 || p.KeyLength < 16 {
+		return errors.New("invalid Argon2id parameters")
+	}
+	return nil
+}
+
+// weakerThan reports whether a hash made with p should be upgraded to want:
+func (p Argon2Params) weakerThan(want Argon2Params) bool {
+	return p.Memory < want.Memory || p.Iterations < want.Iterations || p.Parallelism < want.Parallelism ||
+		p.SaltLength < want.SaltLength || p.KeyLength < want.KeyLength
+}
+
+// hashArgon2id returns a hash in the PHC string format other libraries use too:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func hashArgon2id(password string, p Argon2Params) (string, error) {
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	release, err := acquireArgon2Slot()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func checkArgon2id(password, hash string) error {
+	p, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	release, err := acquireArgon2Slot()
+	if err != nil {
+		return err
+	}
+	defer release()
+	// re-derive with the stored settings and salt; the comparison takes the same time however many
+	// bytes match:
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return errMismatchedPassword
+	}
+	return nil
+}
+
+func decodeArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key:
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+	p := Argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	if err := p.validate(); err != nil {
+		return Argon2Params{}, nil, nil, ErrUnknownHashFormat
+	}
+	return p, salt, key, nil
+}
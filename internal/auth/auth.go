@@ -1,26 +1,173 @@
 package auth
 
 import (
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Hash the password using the bcrypt.GenerateFromPassword function:
+// ErrUnknownHashFormat means a stored hash isn't bcrypt or Argon2id, so we can't check it:
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// HashConfig chooses how new passwords are hashed. Stored hashes say which algorithm and settings
+// made them, so changing this never breaks existing logins; NeedsRehash tells the login handler
+// which ones to upgrade:
+type HashConfig struct {
+	// Algorithm is "argon2id" (the default) or "bcrypt":
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+	// Argon2MaxMemory caps how much memory (in KiB) the Argon2id hashes running at once may use
+	// between them; 0 means DefaultArgon2MaxMemory:
+	Argon2MaxMemory uint32
+}
+
+// DefaultArgon2MaxMemory lets eight hashes with the default settings run at once:
+const DefaultArgon2MaxMemory = 512 * 1024
+
+// DefaultHashConfig is used until SetHashConfig is called:
+var DefaultHashConfig = HashConfig{
+	Algorithm:       "argon2id",
+	Argon2:          DefaultArgon2Params,
+	BcryptCost:      bcrypt.DefaultCost,
+	Argon2MaxMemory: DefaultArgon2MaxMemory,
+}
+
+var hashConfig = DefaultHashConfig
+
+// argon2Slots holds a token for every Argon2id hash in progress (see acquireArgon2Slot):
+var argon2Slots = make(chan struct{}, argon2SlotCount(DefaultHashConfig))
+
+// argon2SlotCount is how many Argon2id hashes may run at once: as many as fit in the memory cap,
+// but no more than the CPUs can run side by side with each using Parallelism threads:
+func argon2SlotCount(c HashConfig) int {
+	maxMemory := c.Argon2MaxMemory
+	if maxMemory == 0 {
+		maxMemory = DefaultArgon2MaxMemory
+	}
+	params := c.Argon2
+	if c.Algorithm != "argon2id" {
+		// only old hashes are Argon2id, and they were most likely made with the defaults:
+		params = DefaultArgon2Params
+	}
+	byMemory := int(maxMemory / params.Memory)
+	byCPU := runtime.NumCPU() / int(params.Parallelism)
+	return max(1, min(byMemory, byCPU))
+}
+
+// SetHashConfig changes how HashPassword works. Call it once at startup, before handling requests:
+func SetHashConfig(c HashConfig) error {
+	switch c.Algorithm {
+	case "argon2id":
+		if err := c.Argon2.validate(); err != nil {
+			return err
+		}
+	case "bcrypt":
+		if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+			return errors.New("bcrypt cost out of range")
+		}
+	default:
+		return errors.New("unknown password hash algorithm " + c.Algorithm)
+	}
+	hashConfig = c
+	argon2Slots = make(chan struct{}, argon2SlotCount(c))
+	return nil
+}
+
+// HashPassword hashes a password with the configured algorithm. The result is self-describing:
+// bcrypt hashes start with "$2a$"/"$2b$" and Argon2id ones with "$argon2id$", followed by their
+// settings and salt:
 func HashPassword(password string) (string, error) {
-	// hashe the password using bcrypt with a sensible work factor. Return a byte slice and an error:
-	dat, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
+	if hashConfig.Algorithm == "bcrypt" {
+		// hash the password using bcrypt with the configured work factor. Return a byte slice and an error:
+		dat, err := bcrypt.GenerateFromPassword([]byte(password), hashConfig.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		// convert the hash bytes to a string and return it:
+		return string(dat), nil
 	}
-	// convert the hash bytes to a string and return it:
-	return string(dat), nil
+	return hashArgon2id(password, hashConfig.Argon2)
 }
 
-// Use the bcrypt.CompareHashAndPassword function to compare the password that the user entered 
-// in the HTTP request with the password that is stored in the database:
-// the full stored hash includes the algorithm, cost, and salt
+// CheckPasswordHash compares the password that the user entered in the HTTP request with the hash
+// that is stored in the database, using whichever algorithm the hash says it was made with. The
+// full stored hash includes the algorithm, cost, and salt:
 func CheckPasswordHash(password, hash string) error {
-	// re-hashe the password using the parameters embedded in hash and compare it to the raw password bytes:
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	switch {
+	case strings.HasPrefix(hash, argon2idPrefix):
+		return checkArgon2id(password, hash)
+	case isBcrypt(hash):
+		// re-hash the password using the parameters embedded in hash and compare it to the raw password bytes:
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	default:
+		return ErrUnknownHashFormat
+	}
+}
+
+// NeedsRehash reports whether hash was made with a different algorithm or weaker settings than the
+// current config. The only time we see the plain password again is at login, so that's when an
+// outdated hash gets replaced:
+func NeedsRehash(hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, argon2idPrefix):
+		if hashConfig.Algorithm != "argon2id" {
+			return true
+		}
+		params, _, _, err := decodeArgon2id(hash)
+		return err != nil || params.weakerThan(hashConfig.Argon2)
+	case isBcrypt(hash):
+		if hashConfig.Algorithm != "bcrypt" {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost < hashConfig.BcryptCost
+	default:
+		return false
+	}
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+var (
+	dummyHashMu sync.Mutex
+	dummyHashed string
+)
+
+// dummyHash is a real hash of a throwaway password, made the first time it's needed (after
+// SetHashConfig, so it costs the same as checking a real user's password). Making it can fail with
+// ErrHashBusy like any other hash, so a failure isn't kept and the next call tries again:
+func dummyHash() (string, error) {
+	dummyHashMu.Lock()
+	defer dummyHashMu.Unlock()
+	if dummyHashed == "" {
+		hash, err := HashPassword("not-a-real-password")
+		if err != nil {
+			return "", err
+		}
+		dummyHashed = hash
+	}
+	return dummyHashed, nil
+}
+
+// CheckDummyPassword does the same work as checking a real password, for when the email doesn't
+// belong to any account. Without it, "no such user" answers much faster than "wrong password",
+// which tells an attacker which emails are registered. The only error it returns is ErrHashBusy,
+// since the password is never expected to match:
+func CheckDummyPassword(password string) error {
+	hash, err := dummyHash()
+	if err != nil {
+		return err
+	}
+	if err := CheckPasswordHash(password, hash); errors.Is(err, ErrHashBusy) {
+		return err
+	}
+	return nil
 }
 
 /* In bcrypt, the “work factor” (cost) controls how slow hashing is. Higher cost = more CPU time = stronger against brute force.
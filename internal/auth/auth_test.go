@@ -1,7 +1,12 @@
 package auth
 
 import (
+	"errors"
+	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestCheckPasswordHash(t *testing.T) {
@@ -58,3 +63,128 @@ func TestCheckPasswordHash(t *testing.T) {
 		})
 	}
 }
+
+// withHashConfig switches the package's hash settings for one test:
+func withHashConfig(t *testing.T, c HashConfig) {
+	t.Helper()
+	old, oldSlots := hashConfig, argon2Slots
+	if err := SetHashConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hashConfig, argon2Slots = old, oldSlots })
+}
+
+// cheap settings so the tests stay fast:
+var testArgon2Params = Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHashFormats(t *testing.T) {
+	withHashConfig(t, HashConfig{Algorithm: "argon2id", Argon2: testArgon2Params})
+	argonHash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(argonHash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("HashPassword() = %q, want a PHC-format Argon2id hash", argonHash)
+	}
+	// the same password gets a different salt every time:
+	if again, _ := HashPassword("hunter2"); again == argonHash {
+		t.Error("HashPassword() returned the same hash twice")
+	}
+
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+
+	// whatever the current config, both kinds of stored hash keep working:
+	for _, hash := range []string{argonHash, string(bcryptHash)} {
+		if err := CheckPasswordHash("hunter2", hash); err != nil {
+			t.Errorf("CheckPasswordHash(%q) error = %v", hash[:10], err)
+		}
+		if err := CheckPasswordHash("hunter3", hash); err == nil {
+			t.Errorf("CheckPasswordHash(%q) accepted the wrong password", hash[:10])
+		}
+	}
+
+	for _, bad := range []string{"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA", "$argon2id$v=18$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5", "$scrypt$whatever"} {
+		if err := CheckPasswordHash("hunter2", bad); err == nil {
+			t.Errorf("CheckPasswordHash(%q) should fail", bad)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	withHashConfig(t, HashConfig{Algorithm: "argon2id", Argon2: testArgon2Params})
+	current, _ := HashPassword("hunter2")
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+
+	stronger := testArgon2Params
+	stronger.Memory *= 2
+	longerSalt := testArgon2Params
+	longerSalt.SaltLength *= 2
+
+	tests := []struct {
+		name   string
+		config HashConfig
+		hash   string
+		want   bool
+	}{
+		{"same settings", HashConfig{Algorithm: "argon2id", Argon2: testArgon2Params}, current, false},
+		{"more memory wanted", HashConfig{Algorithm: "argon2id", Argon2: stronger}, current, true},
+		{"longer salt wanted", HashConfig{Algorithm: "argon2id", Argon2: longerSalt}, current, true},
+		{"bcrypt to argon2id", HashConfig{Algorithm: "argon2id", Argon2: testArgon2Params}, string(bcryptHash), true},
+		{"bcrypt cost raised", HashConfig{Algorithm: "bcrypt", BcryptCost: bcrypt.MinCost + 1}, string(bcryptHash), true},
+		{"bcrypt cost unchanged", HashConfig{Algorithm: "bcrypt", BcryptCost: bcrypt.MinCost}, string(bcryptHash), false},
+		{"argon2id to bcrypt", HashConfig{Algorithm: "bcrypt", BcryptCost: bcrypt.MinCost}, current, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withHashConfig(t, tt.config)
+			if got := NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArgon2Busy(t *testing.T) {
+	withHashConfig(t, HashConfig{Algorithm: "argon2id", Argon2: testArgon2Params, Argon2MaxMemory: testArgon2Params.Memory})
+	oldWait := argon2SlotWait
+	argon2SlotWait = 10 * time.Millisecond
+	t.Cleanup(func() { argon2SlotWait = oldWait })
+
+	hash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the cap only leaves room for one hash, so while it's taken everything else has to give up:
+	release, err := acquireArgon2Slot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := HashPassword("hunter2"); !errors.Is(err, ErrHashBusy) {
+		t.Errorf("HashPassword() error = %v, want ErrHashBusy", err)
+	}
+	if err := CheckPasswordHash("hunter2", hash); !errors.Is(err, ErrHashBusy) {
+		t.Errorf("CheckPasswordHash() error = %v, want ErrHashBusy", err)
+	}
+	if err := CheckDummyPassword("hunter2"); !errors.Is(err, ErrHashBusy) {
+		t.Errorf("CheckDummyPassword() error = %v, want ErrHashBusy", err)
+	}
+
+	release()
+	if err := CheckPasswordHash("hunter2", hash); err != nil {
+		t.Errorf("CheckPasswordHash() after release error = %v", err)
+	}
+}
+
+func TestSetHashConfigRejectsBadSettings(t *testing.T) {
+	for _, c := range []HashConfig{
+		{Algorithm: "md5"},
+		{Algorithm: "bcrypt", BcryptCost: 100},
+		{Algorithm: "argon2id", Argon2: Argon2Params{Memory: 1024, Iterations: 0, Parallelism: 1, SaltLength: 16, KeyLength: 32}},
+	} {
+		if err := SetHashConfig(c); err == nil {
+			t.Errorf("SetHashConfig(%+v) should fail", c)
+			hashConfig = DefaultHashConfig
+		}
+	}
+}
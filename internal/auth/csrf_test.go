@@ -0,0 +1,28 @@
+package auth
+
+import "testing"
+
+func TestCheckCSRFToken(t *testing.T) {
+	token := MakeCSRFToken("session-1", "secret")
+
+	tests := []struct {
+		name         string
+		token        string
+		sessionToken string
+		secret       string
+		want         bool
+	}{
+		{"matching", token, "session-1", "secret", true},
+		{"other session", token, "session-2", "secret", false},
+		{"other secret", token, "session-1", "other", false},
+		{"empty", "", "session-1", "secret", false},
+		{"session token itself", "session-1", "session-1", "secret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CheckCSRFToken(tt.token, tt.sessionToken, tt.secret); got != tt.want {
+				t.Errorf("CheckCSRFToken() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// the "iss" claim on every access token we issue; tokens from anyone else are rejected:
+const tokenIssuer = "chirpy"
+
+// MFA challenge tokens get their own issuer, so one can never be used as an access token (or the
+// other way round):
+const mfaChallengeIssuer = "chirpy-mfa"
+
+// ErrNoAuthHeader means the request didn't send an Authorization header at all:
+var ErrNoAuthHeader = errors.New("no authorization header included in request")
+
+// Claims is what a valid access token tells us about the caller:
+type Claims struct {
+	UserID uuid.UUID
+	// the user's roles when the token was issued. A role that's revoked later stays in the token
+	// until it expires, which is why access tokens are short-lived:
+	Roles []string
+	// the login session the token belongs to (or the cookie session the request came with), or
+	// uuid.Nil for credentials without one:
+	SessionID uuid.UUID
+	// on a token an admin uses to act as this user: the admin (the "act" claim of RFC 8693), and
+	// the token's own ID ("jti"), which names the impersonation so it can be ended early:
+	Actor   uuid.UUID
+	TokenID uuid.UUID
+}
+
+// tokenClaims is the JWT payload: the registered claims plus our own:
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+	// "sid" is OpenID Connect's name for the session a token belongs to:
+	SessionID string `json:"sid,omitempty"`
+	Actor     *actor `json:"act,omitempty"`
+}
+
+// actor is the "act" claim: who is really using a token issued for someone else:
+type actor struct {
+	Subject string `json:"sub"`
+}
+
+// MakeJWT creates a signed access token that identifies userID, and carries their roles, until
+// expiresIn has passed:
+func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration, roles ...string) (string, error) {
+	return makeToken(tokenIssuer, Claims{UserID: userID, Roles: roles}, hmacKey(tokenSecret), expiresIn)
+}
+
+// MakeSessionJWT is MakeJWT for a login that has a session: the token names it, so the user's
+// session list can tell which one is theirs:
+func MakeSessionJWT(claims Claims, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeToken(tokenIssuer, claims, hmacKey(tokenSecret), expiresIn)
+}
+
+// ValidateJWT checks the token's signature, expiry and issuer and returns the user ID it was
+// issued for:
+func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	claims, err := ParseJWT(tokenString, tokenSecret)
+	return claims.UserID, err
+}
+
+// ParseJWT validates the token like ValidateJWT, but returns all of its claims:
+func ParseJWT(tokenString, tokenSecret string) (Claims, error) {
+	return validateToken(tokenIssuer, tokenString, singleKey(hmacKey(tokenSecret)))
+}
+
+// MakeMFAChallengeToken is what login hands out instead of an access token when the user has
+// two-factor auth on. It proves the password was right, and is traded for real tokens together with
+// a TOTP or recovery code:
+func MakeMFAChallengeToken(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeToken(mfaChallengeIssuer, Claims{UserID: userID}, hmacKey(tokenSecret), expiresIn)
+}
+
+func ValidateMFAChallengeToken(tokenString, tokenSecret string) (uuid.UUID, error) {
+	claims, err := validateToken(mfaChallengeIssuer, tokenString, singleKey(hmacKey(tokenSecret)))
+	return claims.UserID, err
+}
+
+func makeToken(issuer string, claims Claims, key SigningKey, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+	sessionID, tokenID := "", ""
+	if claims.SessionID != uuid.Nil {
+		sessionID = claims.SessionID.String()
+	}
+	if claims.TokenID != uuid.Nil {
+		tokenID = claims.TokenID.String()
+	}
+	var act *actor
+	if claims.Actor != uuid.Nil {
+		act = &actor{Subject: claims.Actor.String()}
+	}
+	// RegisteredClaims holds the standard JWT fields: who issued it, when, when it expires, and who
+	// it's about (the subject, our user's ID):
+	token := jwt.NewWithClaims(key.method, tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Subject:   claims.UserID.String(),
+			ID:        tokenID,
+		},
+		Roles:     claims.Roles,
+		SessionID: sessionID,
+		Actor:     act,
+	})
+	// the "kid" header tells whoever checks the token which of our keys signed it. Tokens signed
+	// with the plain JWT_SECRET have always gone without one:
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	// sign the header and claims with the key's secret or private half:
+	return token.SignedString(key.private)
+}
+
+// validateToken checks a token signed with whichever key lookup returns for its "kid" ("" when it
+// has none):
+func validateToken(issuer, tokenString string, lookup func(kid string) (SigningKey, error)) (Claims, error) {
+	claims := tokenClaims{}
+	// the key func finds the key the token names and hands the parser its secret or public half.
+	// The token has to use that key's algorithm, which stops an attacker from switching it to a
+	// different one (like "none", or HS256 with our public key as the secret):
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			key, err := lookup(kid)
+			if err != nil {
+				return nil, err
+			}
+			if token.Method.Alg() != key.Algorithm() {
+				return nil, fmt.Errorf("key %q doesn't use %s", kid, token.Method.Alg())
+			}
+			return key.public, nil
+		},
+		jwt.WithValidMethods(supportedAlgorithms),
+		jwt.WithIssuer(issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return Claims{}, errors.New("invalid user ID in token")
+	}
+	var sessionID uuid.UUID
+	if claims.SessionID != "" {
+		sessionID, err = uuid.Parse(claims.SessionID)
+		if err != nil {
+			return Claims{}, errors.New("invalid session ID in token")
+		}
+	}
+	parsed := Claims{UserID: userID, Roles: claims.Roles, SessionID: sessionID}
+	if claims.Actor != nil {
+		parsed.Actor, err = uuid.Parse(claims.Actor.Subject)
+		if err != nil {
+			return Claims{}, errors.New("invalid actor in token")
+		}
+		// an impersonation token has to be traceable to the impersonation it belongs to:
+		parsed.TokenID, err = uuid.Parse(claims.ID)
+		if err != nil {
+			return Claims{}, errors.New("invalid token ID in token")
+		}
+	}
+	return parsed, nil
+}
+
+// GetBearerToken pulls the token out of an "Authorization: Bearer <token>" header:
+func GetBearerToken(headers http.Header) (string, error) {
+	authHeader := headers.Get("Authorization")
+	if authHeader == "" {
+		return "", ErrNoAuthHeader
+	}
+	// the scheme name is case-insensitive, the token itself isn't:
+	scheme, token, ok := strings.Cut(authHeader, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", errors.New("malformed authorization header")
+	}
+	return strings.TrimSpace(token), nil
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// access tokens and MFA challenge tokens are signed with the same secret, so each validator must
+// reject the other kind:
+func TestMFAChallengeTokenIsNotAnAccessToken(t *testing.T) {
+	userID := uuid.New()
+	challenge, _ := MakeMFAChallengeToken(userID, "secret", time.Minute)
+	access, _ := MakeJWT(userID, "secret", time.Minute)
+
+	if got, err := ValidateMFAChallengeToken(challenge, "secret"); err != nil || got != userID {
+		t.Errorf("ValidateMFAChallengeToken() = %v, %v; want %v", got, err, userID)
+	}
+	if _, err := ValidateJWT(challenge, "secret"); err == nil {
+		t.Error("ValidateJWT() accepted an MFA challenge token")
+	}
+	if _, err := ValidateMFAChallengeToken(access, "secret"); err == nil {
+		t.Error("ValidateMFAChallengeToken() accepted an access token")
+	}
+}
+
+func TestParseJWTRoles(t *testing.T) {
+	userID := uuid.New()
+	admin, _ := MakeJWT(userID, "secret", time.Minute, "admin", "moderator")
+	plain, _ := MakeJWT(userID, "secret", time.Minute)
+
+	claims, err := ParseJWT(admin, "secret")
+	if err != nil || claims.UserID != userID || !slices.Equal(claims.Roles, []string{"admin", "moderator"}) {
+		t.Errorf("ParseJWT() = %+v, %v; want user %v with admin and moderator roles", claims, err, userID)
+	}
+	claims, err = ParseJWT(plain, "secret")
+	if err != nil || len(claims.Roles) != 0 {
+		t.Errorf("ParseJWT() = %+v, %v; want no roles", claims, err)
+	}
+}
+
+func TestParseJWTSessionID(t *testing.T) {
+	userID, sessionID := uuid.New(), uuid.New()
+	withSession, _ := MakeSessionJWT(Claims{UserID: userID, SessionID: sessionID}, "secret", time.Minute)
+	without, _ := MakeJWT(userID, "secret", time.Minute)
+
+	claims, err := ParseJWT(withSession, "secret")
+	if err != nil || claims.UserID != userID || claims.SessionID != sessionID {
+		t.Errorf("ParseJWT() = %+v, %v; want user %v in session %v", claims, err, userID, sessionID)
+	}
+	claims, err = ParseJWT(without, "secret")
+	if err != nil || claims.SessionID != uuid.Nil {
+		t.Errorf("ParseJWT() = %+v, %v; want no session", claims, err)
+	}
+}
+
+func TestParseJWTActor(t *testing.T) {
+	userID, adminID, tokenID := uuid.New(), uuid.New(), uuid.New()
+	token, _ := MakeSessionJWT(Claims{UserID: userID, Actor: adminID, TokenID: tokenID}, "secret", time.Minute)
+
+	claims, err := ParseJWT(token, "secret")
+	if err != nil || claims.UserID != userID || claims.Actor != adminID || claims.TokenID != tokenID {
+		t.Errorf("ParseJWT() = %+v, %v; want user %v acted for by %v in %v", claims, err, userID, adminID, tokenID)
+	}
+
+	// an actor without a token ID can't be tied to an impersonation, so it's refused:
+	noID, _ := MakeSessionJWT(Claims{UserID: userID, Actor: adminID}, "secret", time.Minute)
+	if _, err := ParseJWT(noID, "secret"); err == nil {
+		t.Error("ParseJWT() accepted an actor without a token ID")
+	}
+}
+
+func TestValidateJWT(t *testing.T) {
+	userID := uuid.New()
+	validToken, _ := MakeJWT(userID, "secret", time.Hour)
+	expiredToken, _ := MakeJWT(userID, "secret", -time.Hour)
+
+	tests := []struct {
+		name        string
+		tokenString string
+		tokenSecret string
+		wantUserID  uuid.UUID
+		wantErr     bool
+	}{
+		{
+			name:        "Valid token",
+			tokenString: validToken,
+			tokenSecret: "secret",
+			wantUserID:  userID,
+			wantErr:     false,
+		},
+		{
+			name:        "Invalid token",
+			tokenString: "invalid.token.string",
+			tokenSecret: "secret",
+			wantUserID:  uuid.Nil,
+			wantErr:     true,
+		},
+		{
+			name:        "Wrong secret",
+			tokenString: validToken,
+			tokenSecret: "wrong_secret",
+			wantUserID:  uuid.Nil,
+			wantErr:     true,
+		},
+		{
+			name:        "Expired token",
+			tokenString: expiredToken,
+			tokenSecret: "secret",
+			wantUserID:  uuid.Nil,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUserID, err := ValidateJWT(tt.tokenString, tt.tokenSecret)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateJWT() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if gotUserID != tt.wantUserID {
+				t.Errorf("ValidateJWT() gotUserID = %v, want %v", gotUserID, tt.wantUserID)
+			}
+		})
+	}
+}
+
+func TestGetBearerToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		headers   http.Header
+		wantToken string
+		wantErr   bool
+	}{
+		{
+			name:      "Valid Bearer token",
+			headers:   http.Header{"Authorization": []string{"Bearer valid_token"}},
+			wantToken: "valid_token",
+			wantErr:   false,
+		},
+		{
+			name:      "Lowercase scheme",
+			headers:   http.Header{"Authorization": []string{"bearer valid_token"}},
+			wantToken: "valid_token",
+			wantErr:   false,
+		},
+		{
+			name:      "Missing Authorization header",
+			headers:   http.Header{},
+			wantToken: "",
+			wantErr:   true,
+		},
+		{
+			name:      "Malformed Authorization header",
+			headers:   http.Header{"Authorization": []string{"InvalidBearer token"}},
+			wantToken: "",
+			wantErr:   true,
+		},
+		{
+			name:      "Missing token",
+			headers:   http.Header{"Authorization": []string{"Bearer "}},
+			wantToken: "",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotToken, err := GetBearerToken(tt.headers)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetBearerToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if gotToken != tt.wantToken {
+				t.Errorf("GetBearerToken() gotToken = %v, want %v", gotToken, tt.wantToken)
+			}
+		})
+	}
+}
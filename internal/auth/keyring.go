@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// the algorithms access tokens can be signed with. HS256 is for the shared JWT_SECRET; the other
+// two have a public half other services can check tokens with:
+var supportedAlgorithms = []string{
+	jwt.SigningMethodHS256.Alg(),
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodEdDSA.Alg(),
+}
+
+// smaller RSA keys can be factored by a determined attacker:
+const minRSAKeyBits = 2048
+
+// A SigningKey is one key tokens can be signed with, named by its ID (the "kid" header of the
+// tokens it signs). ActiveFrom is when it starts signing; until then it's only published, so other
+// services have fetched it by the time the first token signed with it turns up:
+type SigningKey struct {
+	ID         string
+	ActiveFrom time.Time
+	method     jwt.SigningMethod
+	private    any
+	public     any
+}
+
+// Algorithm is the JWT "alg" of the tokens the key signs:
+func (k SigningKey) Algorithm() string {
+	return k.method.Alg()
+}
+
+// NewHMACKey makes an HS256 key from a shared secret. It can't be published, so only Chirpy itself
+// can check tokens signed with it:
+func NewHMACKey(id, secret string, activeFrom time.Time) SigningKey {
+	key := hmacKey(secret)
+	key.ID = id
+	key.ActiveFrom = activeFrom
+	return key
+}
+
+func hmacKey(secret string) SigningKey {
+	return SigningKey{method: jwt.SigningMethodHS256, private: []byte(secret), public: []byte(secret)}
+}
+
+// singleKey is the key lookup for tokens that can only have been signed with key:
+func singleKey(key SigningKey) func(string) (SigningKey, error) {
+	return func(kid string) (SigningKey, error) {
+		if kid != key.ID {
+			return SigningKey{}, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return key, nil
+	}
+}
+
+// ParsePrivateKeyPEM reads an RSA (signs with RS256) or Ed25519 (EdDSA) private key in PEM form,
+// as made by:
+//
+//	openssl genpkey -algorithm ed25519 -out key.pem
+//	openssl genpkey -algorithm RSA -pkeyopt rsa_keygen_bits:2048 -out key.pem
+func ParsePrivateKeyPEM(id string, pemBytes []byte, activeFrom time.Time) (SigningKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return SigningKey{}, errors.New("no PEM data found")
+	}
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	key := SigningKey{ID: id, ActiveFrom: activeFrom, private: parsed}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return SigningKey{}, fmt.Errorf("RSA key is %d bits, want at least %d", k.N.BitLen(), minRSAKeyBits)
+		}
+		key.method, key.public = jwt.SigningMethodRS256, &k.PublicKey
+	case ed25519.PrivateKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, k.Public()
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported key type %T (want RSA or Ed25519)", parsed)
+	}
+	return key, nil
+}
+
+// A KeyRing holds every key access tokens might be signed with, so the signing key can be
+// rotated without logging everyone out. The key that signs is the one activated most recently;
+// the one it replaced is still accepted for overlap after that, long enough for the tokens it
+// signed to expire:
+type KeyRing struct {
+	// sorted by ActiveFrom:
+	keys    []SigningKey
+	overlap time.Duration
+	// the clock, replaced in tests:
+	now func() time.Time
+}
+
+// NewKeyRing checks the keys have distinct IDs and that one of them is already active:
+func NewKeyRing(overlap time.Duration, keys ...SigningKey) (*KeyRing, error) {
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if seen[k.ID] {
+			return nil, fmt.Errorf("duplicate signing key ID %q", k.ID)
+		}
+		seen[k.ID] = true
+	}
+	kr := &KeyRing{keys: slices.Clone(keys), overlap: overlap, now: time.Now}
+	// a stable sort means that of two keys activated at the same moment, the later one listed wins:
+	slices.SortStableFunc(kr.keys, func(a, b SigningKey) int { return a.ActiveFrom.Compare(b.ActiveFrom) })
+	if _, err := kr.signingKey(); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// signingKey is the most recently activated key:
+func (kr *KeyRing) signingKey() (SigningKey, error) {
+	now := kr.now()
+	for i := len(kr.keys) - 1; i >= 0; i-- {
+		if !kr.keys[i].ActiveFrom.After(now) {
+			return kr.keys[i], nil
+		}
+	}
+	return SigningKey{}, errors.New("no signing key is active yet")
+}
+
+// verifiable reports whether tokens signed with keys[i] are still accepted: it has to be active,
+// and if it's been replaced, not for longer than the overlap:
+func (kr *KeyRing) verifiable(i int) bool {
+	now := kr.now()
+	if kr.keys[i].ActiveFrom.After(now) {
+		return false
+	}
+	if i+1 < len(kr.keys) && !kr.keys[i+1].ActiveFrom.After(now) {
+		return now.Before(kr.keys[i+1].ActiveFrom.Add(kr.overlap))
+	}
+	return true
+}
+
+func (kr *KeyRing) verificationKey(kid string) (SigningKey, error) {
+	for i, k := range kr.keys {
+		if k.ID == kid {
+			if !kr.verifiable(i) {
+				return SigningKey{}, fmt.Errorf("signing key %q isn't in use", kid)
+			}
+			return k, nil
+		}
+	}
+	return SigningKey{}, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// MakeJWT creates an access token like the package-level MakeSessionJWT, signed with the ring's
+// current key:
+func (kr *KeyRing) MakeJWT(claims Claims, expiresIn time.Duration) (string, error) {
+	key, err := kr.signingKey()
+	if err != nil {
+		return "", err
+	}
+	return makeToken(tokenIssuer, claims, key, expiresIn)
+}
+
+// ParseJWT validates an access token signed with any key the ring still accepts:
+func (kr *KeyRing) ParseJWT(tokenString string) (Claims, error) {
+	return validateToken(tokenIssuer, tokenString, kr.verificationKey)
+}
+
+// A JWK is a public key in JSON Web Key form (RFC 7517), with only the fields our key types use:
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA:
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519:
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// A JWKS is the document served at /.well-known/jwks.json:
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public halves of the keys other services should accept: the ones in use, and the
+// ones about to be. HMAC keys are secret, so they're never included:
+func (kr *KeyRing) JWKS() JWKS {
+	now := kr.now()
+	set := JWKS{Keys: []JWK{}}
+	for i, k := range kr.keys {
+		if !k.ActiveFrom.After(now) && !kr.verifiable(i) {
+			continue
+		}
+		jwk := JWK{KeyID: k.ID, Use: "sig", Algorithm: k.Algorithm()}
+		switch pub := k.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
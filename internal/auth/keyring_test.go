@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func pemKey(t *testing.T, key any) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	weakKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+
+	tests := []struct {
+		name    string
+		pem     []byte
+		wantAlg string
+		wantErr bool
+	}{
+		{"Ed25519", pemKey(t, edKey), "EdDSA", false},
+		{"RSA", pemKey(t, rsaKey), "RS256", false},
+		{"RSA PKCS#1", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), "RS256", false},
+		{"weak RSA", pemKey(t, weakKey), "", true},
+		{"not PEM", []byte("hunter2"), "", true},
+		{"public key", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte{1}}), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParsePrivateKeyPEM("k", tt.pem, time.Time{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePrivateKeyPEM() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && key.Algorithm() != tt.wantAlg {
+				t.Errorf("Algorithm() = %q, want %q", key.Algorithm(), tt.wantAlg)
+			}
+		})
+	}
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	start := time.Now()
+	rotateAt := start.Add(24 * time.Hour)
+	newKey, err := ParsePrivateKeyPEM("2026-10", pemKey(t, edKey), rotateAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring, err := NewKeyRing(time.Hour, NewHMACKey("", "secret", time.Time{}), newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := Claims{UserID: uuid.New(), Roles: []string{"admin"}}
+
+	// before the rotation the shared secret signs, just like MakeJWT, and the new key is already
+	// published:
+	ring.now = func() time.Time { return start }
+	oldToken, _ := ring.MakeJWT(claims, time.Hour)
+	if got, err := ParseJWT(oldToken, "secret"); err != nil || got.UserID != claims.UserID {
+		t.Errorf("ParseJWT() of a token from the old key = %+v, %v", got, err)
+	}
+	if jwks := ring.JWKS(); len(jwks.Keys) != 1 || jwks.Keys[0].KeyID != "2026-10" || jwks.Keys[0].KeyType != "OKP" {
+		t.Errorf("JWKS() before rotation = %+v, want just the new key", jwks)
+	}
+
+	// just after, the new key signs and the old one's tokens are still good:
+	ring.now = func() time.Time { return rotateAt.Add(30 * time.Minute) }
+	newToken, _ := ring.MakeJWT(claims, time.Hour)
+	parsed, _, _ := jwt.NewParser().ParseUnverified(newToken, &tokenClaims{})
+	if parsed.Header["kid"] != "2026-10" || parsed.Method.Alg() != "EdDSA" {
+		t.Errorf("new token header = %v, want EdDSA with kid 2026-10", parsed.Header)
+	}
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		if got, err := ring.ParseJWT(token); err != nil || got.UserID != claims.UserID || len(got.Roles) != 1 {
+			t.Errorf("ParseJWT() of %s token during overlap = %+v, %v", name, got, err)
+		}
+	}
+
+	// once the overlap is over, only the new key works:
+	ring.now = func() time.Time { return rotateAt.Add(2 * time.Hour) }
+	if _, err := ring.ParseJWT(oldToken); err == nil {
+		t.Error("ParseJWT() accepted a token from a retired key")
+	}
+	if _, err := ring.ParseJWT(newToken); err != nil {
+		t.Errorf("ParseJWT() of new token = %v", err)
+	}
+}
+
+func TestKeyRingRejects(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	key, _ := ParsePrivateKeyPEM("ed", pemKey(t, edKey), time.Time{})
+	ring, err := NewKeyRing(time.Hour, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := tokenClaims{RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:    tokenIssuer,
+		Subject:   uuid.NewString(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}}
+
+	// an HMAC token claiming to be from the Ed25519 key, "signed" with its public half:
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	confused.Header["kid"] = "ed"
+	confusedToken, _ := confused.SignedString([]byte(edKey.Public().(ed25519.PublicKey)))
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	unknown.Header["kid"] = "other"
+	unknownToken, _ := unknown.SignedString(edKey)
+
+	noKid, _ := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims).SignedString(edKey)
+
+	for name, token := range map[string]string{"algorithm confusion": confusedToken, "unknown kid": unknownToken, "no kid": noKid} {
+		if _, err := ring.ParseJWT(token); err == nil {
+			t.Errorf("ParseJWT() accepted a token with %s", name)
+		}
+	}
+
+	if _, err := NewKeyRing(time.Hour, key, key); err == nil {
+		t.Error("NewKeyRing() accepted duplicate key IDs")
+	}
+	future := NewHMACKey("later", "secret", time.Now().Add(time.Hour))
+	if _, err := NewKeyRing(time.Hour, future); err == nil {
+		t.Error("NewKeyRing() accepted a ring with no active key")
+	}
+}
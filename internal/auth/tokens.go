@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// MakeToken returns 256 bits of randomness as a hex string, for refresh tokens, password reset
+// links and the like. Unlike a JWT it means nothing on its own; it only works while the database
+// says it does, which is what makes it revocable:
+func MakeToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// DeriveSecret makes a separate key for each purpose from one secret, so something signed for one
+// purpose (a CSRF token, say) can never pass for another:
+func DeriveSecret(secret, purpose string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("chirpy:" + purpose))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HashToken is how random tokens are stored. They're already unguessable, so a fast hash like
+// SHA-256 is enough (bcrypt is for low-entropy secrets like passwords):
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
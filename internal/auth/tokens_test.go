@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestMakeToken(t *testing.T) {
+	token1, err := MakeToken()
+	if err != nil {
+		t.Fatalf("MakeToken() error = %v", err)
+	}
+	token2, _ := MakeToken()
+	if len(token1) != 64 {
+		t.Errorf("MakeToken() length = %d, want 64", len(token1))
+	}
+	if token1 == token2 {
+		t.Error("MakeToken() returned the same token twice")
+	}
+	if HashToken(token1) == token1 || HashToken(token1) != HashToken(token1) {
+		t.Error("HashToken() should be a deterministic transformation of the token")
+	}
+}
+
+func TestDeriveSecret(t *testing.T) {
+	csrf := DeriveSecret("server-secret", "csrf")
+	if csrf != DeriveSecret("server-secret", "csrf") {
+		t.Error("DeriveSecret() should be deterministic")
+	}
+	if csrf == DeriveSecret("server-secret", "mfa") {
+		t.Error("DeriveSecret() gave two purposes the same key")
+	}
+	if csrf == DeriveSecret("other-secret", "csrf") {
+		t.Error("DeriveSecret() gave two secrets the same key")
+	}
+}
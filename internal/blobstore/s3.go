@@ -0,0 +1,432 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// S3 rejects multipart parts smaller than 5MB (except the last one):
+	minPartSize     = 5 << 20
+	defaultPartSize = 8 << 20
+	// unsignedPayload is used for presigned URLs, where we can't know the body in advance:
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// S3Config points an S3 store at a bucket on AWS or any S3-compatible server (MinIO, R2, ...):
+type S3Config struct {
+	// Endpoint is the service's base URL, e.g. "https://s3.us-east-1.amazonaws.com" or
+	// "http://localhost:9000":
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to every key, so several apps can share one bucket:
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle puts the bucket in the path (host/bucket/key) instead of the hostname
+	// (bucket.host/key). Most self-hosted S3 stand-ins need this:
+	PathStyle bool
+	// PartSize is both the threshold above which Put switches to a multipart upload and the size of
+	// each part. Zero means 8MB:
+	PartSize int64
+}
+
+// S3 is a BlobStore backed by the S3 REST API, signed with AWS Signature Version 4:
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	// now is swapped out in tests so signatures are reproducible:
+	now func() time.Time
+}
+
+func NewS3(cfg S3Config) (*S3, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" || cfg.Region == "" {
+		return nil, errors.New("S3 bucket and region are required")
+	}
+	if cfg.Prefix != "" && !strings.HasSuffix(cfg.Prefix, "/") {
+		cfg.Prefix += "/"
+	}
+	if cfg.PartSize == 0 {
+		cfg.PartSize = defaultPartSize
+	}
+	if cfg.PartSize < minPartSize {
+		return nil, fmt.Errorf("S3 part size must be at least %d bytes", minPartSize)
+	}
+	return &S3{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 5 * time.Minute},
+		now:      time.Now,
+	}, nil
+}
+
+// Put uploads small blobs with a single PUT. Anything bigger than one part is sent as a multipart
+// upload, so we only ever hold one part in memory:
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	first, err := readPart(r, s.cfg.PartSize)
+	if err != nil {
+		return err
+	}
+	if int64(len(first)) < s.cfg.PartSize {
+		header := http.Header{}
+		if contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+		resp, err := s.do(ctx, http.MethodPut, key, nil, header, first)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	return s.putMultipart(ctx, key, first, r, contentType)
+}
+
+// readPart reads up to size bytes, returning fewer only at the end of r:
+func readPart(r io.Reader, size int64) ([]byte, error) {
+	buf := make([]byte, size)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return buf[:n], nil
+	}
+	return buf[:n], err
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (s *S3) putMultipart(ctx context.Context, key string, first []byte, r io.Reader, contentType string) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	parts, err := s.uploadParts(ctx, key, initiated.UploadID, first, r)
+	if err != nil {
+		// don't leave half an upload in the bucket (S3 keeps, and bills for, orphaned parts):
+		if resp, abortErr := s.do(context.Background(), http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil, nil); abortErr == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err = s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {initiated.UploadID}}, nil, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// CompleteMultipartUpload can fail *after* sending 200 OK, in which case the body is an <Error>:
+	result, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(result, []byte("<Error>")) {
+		return parseS3Error(resp.StatusCode, result)
+	}
+	return nil
+}
+
+func (s *S3) uploadParts(ctx context.Context, key, uploadID string, part []byte, r io.Reader) ([]completedPart, error) {
+	parts := []completedPart{}
+	for number := 1; len(part) > 0; number++ {
+		query := url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {uploadID},
+		}
+		resp, err := s.do(ctx, http.MethodPut, key, query, nil, part)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+
+		part, err = readPart(r, s.cfg.PartSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete succeeds if the blob is already gone (S3 itself answers 204 either way):
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// PresignGet returns a URL anyone can download the blob from until it expires:
+func (s *S3) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return s.presign(http.MethodGet, key, "", expires)
+}
+
+// PresignPut returns a URL a client can upload the blob to until it expires. If contentType is
+// set, the upload must send exactly that Content-Type header:
+func (s *S3) PresignPut(ctx context.Context, key string, contentType string, expires time.Duration) (string, error) {
+	return s.presign(http.MethodPut, key, contentType, expires)
+}
+
+func (s *S3) presign(method, key, contentType string, expires time.Duration) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+	// presigned URLs are valid for between one second and seven days:
+	if expires < time.Second || expires > 7*24*time.Hour {
+		return "", errors.New("presigned URL expiry must be between 1s and 7 days")
+	}
+	now := s.now().UTC()
+	u := s.objectURL(key)
+	header := http.Header{"Host": {u.Host}}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	signedHeaders := signedHeaderNames(header)
+
+	// everything that would normally go in the Authorization header goes in the query string instead:
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.cfg.AccessKeyID + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires.Seconds()))},
+		"X-Amz-SignedHeaders": {strings.Join(signedHeaders, ";")},
+	}
+	signature := s.signature(now, method, u, query, header, signedHeaders, unsignedPayload)
+	u.RawQuery = canonicalQuery(query) + "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+// do sends a signed request for the object at key and turns S3 error responses into Go errors:
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := s.objectURL(key)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	// sign the request:
+	now := s.now().UTC()
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signHeader := req.Header.Clone()
+	signHeader.Set("Host", u.Host)
+	signedHeaders := signedHeaderNames(signHeader)
+	signature := s.signature(now, method, u, query, signHeader, signedHeaders, payloadHash)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, s.scope(now), strings.Join(signedHeaders, ";"), signature,
+	))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return nil, parseS3Error(resp.StatusCode, errBody)
+	}
+	return resp, nil
+}
+
+// objectURL builds the URL for key, honouring the prefix and path-style setting. RawPath is set
+// to S3's own encoding of the key so the path we sign is exactly the path we send:
+func (s *S3) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	objectKey := s.cfg.Prefix + key
+	basePath := strings.TrimSuffix(u.Path, "/")
+	if s.cfg.PathStyle {
+		basePath += "/" + s.cfg.Bucket
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	u.Path = basePath + "/" + objectKey
+	u.RawPath = uriEncode(basePath+"/"+objectKey, false)
+	u.RawQuery = ""
+	return &u
+}
+
+func (s *S3) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+// signature implements the SigV4 algorithm: build a canonical form of the request, hash it into a
+// "string to sign", and HMAC that with a key derived from the secret, date, region and service.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (s *S3) signature(t time.Time, method string, u *url.URL, query url.Values, header http.Header, signedHeaders []string, payloadHash string) string {
+	canonicalHeaders := strings.Builder{}
+	for _, name := range signedHeaders {
+		// values are trimmed and runs of spaces collapsed:
+		values := []string{}
+		for _, v := range header.Values(name) {
+			values = append(values, strings.Join(strings.Fields(v), " "))
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		canonicalQuery(query),
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		t.Format("20060102T150405Z"),
+		s.scope(t),
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// signedHeaderNames lists the headers we sign: Host, Content-Type and every x-amz-* header,
+// lowercased and sorted as SigV4 requires:
+func signedHeaderNames(header http.Header) []string {
+	names := []string{}
+	for name := range header {
+		lower := strings.ToLower(name)
+		if lower == "host" || lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// canonicalQuery sorts parameters by name and then value and encodes them the way SigV4 expects
+// (which differs from url.Values.Encode: spaces are %20, not +):
+func canonicalQuery(query url.Values) string {
+	pairs := []string{}
+	for name, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything except unreserved characters (and '/' in paths):
+func uriEncode(s string, encodeSlash bool) string {
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// An S3Error is an error response from the S3 API:
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// parseS3Error decodes an <Error> document. Missing objects come back as ErrNotFound so callers can
+// treat every BlobStore the same way:
+func parseS3Error(statusCode int, body []byte) error {
+	s3Err := &S3Error{StatusCode: statusCode}
+	xml.Unmarshal(body, s3Err)
+	if statusCode == http.StatusNotFound || s3Err.Code == "NoSuchKey" {
+		return ErrNotFound
+	}
+	if s3Err.Code == "" {
+		s3Err.Code = http.StatusText(statusCode)
+	}
+	return s3Err
+}
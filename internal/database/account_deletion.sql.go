@@ -0,0 +1,132 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: account_deletion.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+const anonymizeUser = `-- name: AnonymizeUser :exec
+UPDATE users
+SET email = 'deleted-' || id::text || '@deleted.invalid',
+    handle = 'deleted_' || replace(id::text, '-', ''),
+    display_name = 'Deleted user',
+    bio = '',
+    avatar_url = '',
+    hashed_password = 'unset',
+    totp_secret = NULL,
+    totp_enabled_at = NULL,
+    totp_last_step = NULL,
+    deletion_scheduled_at = NULL,
+    anonymized_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+`
+
+// Scrubs everything that identifies the person but keeps the row, so their chirps stay up under a
+// "Deleted user" name. The email and handle are derived from the ID so they stay unique, and
+// 'unset' isn't a valid password hash, so nobody can log in to the account again. Its two-factor
+// secret goes too; its recovery codes and external logins are deleted alongside (see
+// processAccountDeletions):
+func (q *Queries) AnonymizeUser(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, anonymizeUser, id)
+	return err
+}
+
+const cancelUserDeletion = `-- name: CancelUserDeletion :exec
+UPDATE users
+SET deletion_scheduled_at = NULL, updated_at = NOW()
+WHERE id = $1 AND deletion_scheduled_at IS NOT NULL
+`
+
+func (q *Queries) CancelUserDeletion(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, cancelUserDeletion, id)
+	return err
+}
+
+const getUsersDueForDeletion = `-- name: GetUsersDueForDeletion :many
+SELECT id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url, deleted_at, deletion_scheduled_at, email_verified_at, totp_secret, totp_enabled_at, totp_last_step, anonymized_at FROM users
+WHERE deletion_scheduled_at <= NOW() AND deleted_at IS NULL
+`
+
+func (q *Queries) GetUsersDueForDeletion(ctx context.Context) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, getUsersDueForDeletion)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.HashedPassword,
+			&i.Handle,
+			&i.DisplayName,
+			&i.Bio,
+			&i.AvatarUrl,
+			&i.DeletedAt,
+			&i.DeletionScheduledAt,
+			&i.EmailVerifiedAt,
+			&i.TotpSecret,
+			&i.TotpEnabledAt,
+			&i.TotpLastStep,
+			&i.AnonymizedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const scheduleUserDeletion = `-- name: ScheduleUserDeletion :one
+UPDATE users
+SET deletion_scheduled_at = NOW() + make_interval(secs => $1::float8),
+    updated_at = NOW()
+WHERE id = $2 AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url, deleted_at, deletion_scheduled_at, email_verified_at, totp_secret, totp_enabled_at, totp_last_step, anonymized_at
+`
+
+type ScheduleUserDeletionParams struct {
+	GracePeriodSeconds float64
+	ID                 uuid.UUID
+}
+
+func (q *Queries) ScheduleUserDeletion(ctx context.Context, arg ScheduleUserDeletionParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, scheduleUserDeletion, arg.GracePeriodSeconds, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.DeletedAt,
+		&i.DeletionScheduledAt,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.AnonymizedAt,
+	)
+	return i, err
+}
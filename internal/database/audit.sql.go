@@ -0,0 +1,229 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: audit.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const countAuditEvents = `-- name: CountAuditEvents :one
+SELECT COUNT(*) FROM audit_events
+WHERE ($1::uuid IS NULL OR actor_id = $1 OR on_behalf_of = $1)
+    AND ($2::text IS NULL OR action = $2)
+    AND ($3::text IS NULL OR target = $3)
+    AND ($4::timestamp IS NULL OR created_at >= $4)
+    AND ($5::timestamp IS NULL OR created_at < $5)
+`
+
+type CountAuditEventsParams struct {
+	ActorID uuid.NullUUID
+	Action  sql.NullString
+	Target  sql.NullString
+	Since   sql.NullTime
+	Until   sql.NullTime
+}
+
+func (q *Queries) CountAuditEvents(ctx context.Context, arg CountAuditEventsParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countAuditEvents,
+		arg.ActorID,
+		arg.Action,
+		arg.Target,
+		arg.Since,
+		arg.Until,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createAuditEvent = `-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (
+    created_at, actor_id, on_behalf_of, action, target, ip, details, request_id, diff, prev_hash, hash
+)
+VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10::text, $11::text
+)
+`
+
+type CreateAuditEventParams struct {
+	CreatedAt  time.Time
+	ActorID    uuid.NullUUID
+	OnBehalfOf uuid.NullUUID
+	Action     string
+	Target     string
+	Ip         string
+	Details    json.RawMessage
+	RequestID  string
+	Diff       json.RawMessage
+	PrevHash   string
+	Hash       string
+}
+
+func (q *Queries) CreateAuditEvent(ctx context.Context, arg CreateAuditEventParams) error {
+	_, err := q.db.ExecContext(ctx, createAuditEvent,
+		arg.CreatedAt,
+		arg.ActorID,
+		arg.OnBehalfOf,
+		arg.Action,
+		arg.Target,
+		arg.Ip,
+		arg.Details,
+		arg.RequestID,
+		arg.Diff,
+		arg.PrevHash,
+		arg.Hash,
+	)
+	return err
+}
+
+const getLastAuditHash = `-- name: GetLastAuditHash :one
+SELECT hash::text FROM audit_events
+WHERE hash IS NOT NULL
+ORDER BY id DESC
+LIMIT 1
+`
+
+func (q *Queries) GetLastAuditHash(ctx context.Context) (string, error) {
+	row := q.db.QueryRowContext(ctx, getLastAuditHash)
+	var hash string
+	err := row.Scan(&hash)
+	return hash, err
+}
+
+const listAuditChain = `-- name: ListAuditChain :many
+
+SELECT id, created_at, actor_id, on_behalf_of, action, target, ip, details, request_id, diff, prev_hash, hash FROM audit_events
+WHERE hash IS NOT NULL AND id > $1::bigint
+ORDER BY id
+LIMIT $2::int
+`
+
+type ListAuditChainParams struct {
+	AfterID   int64
+	PageLimit int32
+}
+
+// the chained entries after after_id, oldest first, for checking the chain a batch at a time:
+func (q *Queries) ListAuditChain(ctx context.Context, arg ListAuditChainParams) ([]AuditEvent, error) {
+	rows, err := q.db.QueryContext(ctx, listAuditChain, arg.AfterID, arg.PageLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditEvent
+	for rows.Next() {
+		var i AuditEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.ActorID,
+			&i.OnBehalfOf,
+			&i.Action,
+			&i.Target,
+			&i.Ip,
+			&i.Details,
+			&i.RequestID,
+			&i.Diff,
+			&i.PrevHash,
+			&i.Hash,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditEvents = `-- name: ListAuditEvents :many
+
+SELECT id, created_at, actor_id, on_behalf_of, action, target, ip, details, request_id, diff, prev_hash, hash FROM audit_events
+WHERE ($1::uuid IS NULL OR actor_id = $1 OR on_behalf_of = $1)
+    AND ($2::text IS NULL OR action = $2)
+    AND ($3::text IS NULL OR target = $3)
+    AND ($4::timestamp IS NULL OR created_at >= $4)
+    AND ($5::timestamp IS NULL OR created_at < $5)
+ORDER BY id DESC
+LIMIT $7::int OFFSET $6::int
+`
+
+type ListAuditEventsParams struct {
+	ActorID    uuid.NullUUID
+	Action     sql.NullString
+	Target     sql.NullString
+	Since      sql.NullTime
+	Until      sql.NullTime
+	PageOffset int32
+	PageLimit  int32
+}
+
+// Each filter is ignored when it's NULL:
+func (q *Queries) ListAuditEvents(ctx context.Context, arg ListAuditEventsParams) ([]AuditEvent, error) {
+	rows, err := q.db.QueryContext(ctx, listAuditEvents,
+		arg.ActorID,
+		arg.Action,
+		arg.Target,
+		arg.Since,
+		arg.Until,
+		arg.PageOffset,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditEvent
+	for rows.Next() {
+		var i AuditEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.ActorID,
+			&i.OnBehalfOf,
+			&i.Action,
+			&i.Target,
+			&i.Ip,
+			&i.Details,
+			&i.RequestID,
+			&i.Diff,
+			&i.PrevHash,
+			&i.Hash,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockAuditLog = `-- name: LockAuditLog :exec
+
+SELECT pg_advisory_xact_lock(7283401)
+`
+
+// Entries are chained in the order they're written, so writers take turns: the lock is held until
+// the transaction ends, by which time the new entry is the one the next writer sees as the last.
+// The number is arbitrary, it just has to be the same everywhere.
+func (q *Queries) LockAuditLog(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, lockAuditLog)
+	return err
+}
@@ -0,0 +1,149 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: chirp_revisions.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const createChirpRevision = `-- name: CreateChirpRevision :one
+INSERT INTO chirp_revisions (id, created_at, chirp_id, body)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2
+)
+RETURNING id, created_at, chirp_id, body
+`
+
+type CreateChirpRevisionParams struct {
+	ChirpID uuid.UUID
+	Body    string
+}
+
+func (q *Queries) CreateChirpRevision(ctx context.Context, arg CreateChirpRevisionParams) (ChirpRevision, error) {
+	row := q.db.QueryRowContext(ctx, createChirpRevision, arg.ChirpID, arg.Body)
+	var i ChirpRevision
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.ChirpID,
+		&i.Body,
+	)
+	return i, err
+}
+
+const getChirpForEdit = `-- name: GetChirpForEdit :one
+SELECT
+    id, created_at, updated_at, body, user_id, search_vector, edited, deleted_at,
+    (created_at > NOW() - make_interval(secs => $1::float8))::boolean AS editable
+FROM chirps
+WHERE id = $2 AND deleted_at IS NULL
+FOR UPDATE
+`
+
+type GetChirpForEditParams struct {
+	EditWindowSeconds float64
+	ID                uuid.UUID
+}
+
+type GetChirpForEditRow struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Body         string
+	UserID       uuid.UUID
+	SearchVector interface{}
+	Edited       bool
+	DeletedAt    sql.NullTime
+	Editable     bool
+}
+
+// Locks the chirp row until the transaction ends, so two edits can't both save the same old body
+// as a revision. editable says whether the chirp is still inside the edit window:
+func (q *Queries) GetChirpForEdit(ctx context.Context, arg GetChirpForEditParams) (GetChirpForEditRow, error) {
+	row := q.db.QueryRowContext(ctx, getChirpForEdit, arg.EditWindowSeconds, arg.ID)
+	var i GetChirpForEditRow
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.SearchVector,
+		&i.Edited,
+		&i.DeletedAt,
+		&i.Editable,
+	)
+	return i, err
+}
+
+const getChirpRevisions = `-- name: GetChirpRevisions :many
+SELECT id, created_at, chirp_id, body FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY created_at ASC
+`
+
+func (q *Queries) GetChirpRevisions(ctx context.Context, chirpID uuid.UUID) ([]ChirpRevision, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpRevisions, chirpID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ChirpRevision
+	for rows.Next() {
+		var i ChirpRevision
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.ChirpID,
+			&i.Body,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, edited = TRUE, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, body, user_id, search_vector, edited, deleted_at
+`
+
+type UpdateChirpBodyParams struct {
+	ID   uuid.UUID
+	Body string
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.ID, arg.Body)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.SearchVector,
+		&i.Edited,
+		&i.DeletedAt,
+	)
+	return i, err
+}
@@ -0,0 +1,127 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: chirps_search.sql
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const countSearchChirps = `-- name: CountSearchChirps :one
+SELECT COUNT(*) FROM chirps
+WHERE search_vector @@ (
+    websearch_to_tsquery('english', $1::text) && to_tsquery('english', $2::text)
+)
+    AND deleted_at IS NULL
+    AND EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NULL)
+`
+
+type CountSearchChirpsParams struct {
+	Query       string
+	PrefixQuery string
+}
+
+func (q *Queries) CountSearchChirps(ctx context.Context, arg CountSearchChirpsParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countSearchChirps, arg.Query, arg.PrefixQuery)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const searchChirps = `-- name: SearchChirps :many
+
+SELECT
+    id,
+    created_at,
+    updated_at,
+    body,
+    user_id,
+    edited,
+    ts_rank(
+        search_vector,
+        websearch_to_tsquery('english', $1::text) && to_tsquery('english', $2::text)
+    )::real AS rank,
+    ts_headline(
+        'english',
+        translate(body, $3::text, ''),
+        websearch_to_tsquery('english', $1::text) && to_tsquery('english', $2::text),
+        $4::text
+    )::text AS snippet
+FROM chirps
+WHERE search_vector @@ (
+    websearch_to_tsquery('english', $1::text) && to_tsquery('english', $2::text)
+)
+    AND deleted_at IS NULL
+    AND EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NULL)
+ORDER BY rank DESC, created_at DESC
+LIMIT $6::int OFFSET $5::int
+`
+
+type SearchChirpsParams struct {
+	Query           string
+	PrefixQuery     string
+	StripChars      string
+	HeadlineOptions string
+	PageOffset      int32
+	PageLimit       int32
+}
+
+type SearchChirpsRow struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Body      string
+	UserID    uuid.UUID
+	Edited    bool
+	Rank      float32
+	Snippet   string
+}
+
+// websearch_to_tsquery understands "quoted phrases", -negation and OR. It has no prefix syntax, so
+// prefix terms (e.g. chirp*) are passed separately as a to_tsquery string and AND-ed on; an empty
+// prefix query is simply ignored by the && operator.
+// strip_chars are removed from the body before highlighting, so the markers ts_headline adds are
+// the only ones in the snippet.
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps,
+		arg.Query,
+		arg.PrefixQuery,
+		arg.StripChars,
+		arg.HeadlineOptions,
+		arg.PageOffset,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsRow
+	for rows.Next() {
+		var i SearchChirpsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.Edited,
+			&i.Rank,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
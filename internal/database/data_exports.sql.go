@@ -0,0 +1,440 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: data_exports.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+const claimDataExport = `-- name: ClaimDataExport :one
+UPDATE data_exports
+SET status = 'processing', updated_at = NOW()
+WHERE id = (
+    SELECT id FROM data_exports
+    WHERE status = 'pending'
+        OR (status = 'processing' AND updated_at < NOW() - INTERVAL '1 hour')
+    ORDER BY created_at ASC
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, created_at, updated_at, user_id, status, blob_key, size_bytes, completed_at, expires_at
+`
+
+// Claims the oldest pending export for this worker. SKIP LOCKED lets several servers run the job
+// without grabbing the same row, and an export stuck in "processing" for an hour (its server
+// probably died) is picked up again:
+func (q *Queries) ClaimDataExport(ctx context.Context) (DataExport, error) {
+	row := q.db.QueryRowContext(ctx, claimDataExport)
+	var i DataExport
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Status,
+		&i.BlobKey,
+		&i.SizeBytes,
+		&i.CompletedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const completeDataExport = `-- name: CompleteDataExport :exec
+UPDATE data_exports
+SET status = 'ready',
+    blob_key = $1,
+    size_bytes = $2,
+    completed_at = NOW(),
+    expires_at = NOW() + make_interval(secs => $3::float8),
+    updated_at = NOW()
+WHERE id = $4
+`
+
+type CompleteDataExportParams struct {
+	BlobKey          sql.NullString
+	SizeBytes        sql.NullInt64
+	ExpiresInSeconds float64
+	ID               uuid.UUID
+}
+
+func (q *Queries) CompleteDataExport(ctx context.Context, arg CompleteDataExportParams) error {
+	_, err := q.db.ExecContext(ctx, completeDataExport,
+		arg.BlobKey,
+		arg.SizeBytes,
+		arg.ExpiresInSeconds,
+		arg.ID,
+	)
+	return err
+}
+
+const createDataExport = `-- name: CreateDataExport :one
+INSERT INTO data_exports (id, created_at, updated_at, user_id, status)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    'pending'
+)
+RETURNING id, created_at, updated_at, user_id, status, blob_key, size_bytes, completed_at, expires_at
+`
+
+func (q *Queries) CreateDataExport(ctx context.Context, userID uuid.UUID) (DataExport, error) {
+	row := q.db.QueryRowContext(ctx, createDataExport, userID)
+	var i DataExport
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Status,
+		&i.BlobKey,
+		&i.SizeBytes,
+		&i.CompletedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const expireDataExport = `-- name: ExpireDataExport :exec
+UPDATE data_exports
+SET status = 'expired', blob_key = NULL, updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) ExpireDataExport(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, expireDataExport, id)
+	return err
+}
+
+const failDataExport = `-- name: FailDataExport :exec
+UPDATE data_exports
+SET status = 'failed', completed_at = NOW(), updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) FailDataExport(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, failDataExport, id)
+	return err
+}
+
+const getActiveDataExport = `-- name: GetActiveDataExport :one
+SELECT id, created_at, updated_at, user_id, status, blob_key, size_bytes, completed_at, expires_at FROM data_exports
+WHERE user_id = $1 AND status IN ('pending', 'processing')
+ORDER BY created_at DESC
+LIMIT 1
+`
+
+// An export that's already queued or running is reused instead of starting another one:
+func (q *Queries) GetActiveDataExport(ctx context.Context, userID uuid.UUID) (DataExport, error) {
+	row := q.db.QueryRowContext(ctx, getActiveDataExport, userID)
+	var i DataExport
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Status,
+		&i.BlobKey,
+		&i.SizeBytes,
+		&i.CompletedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const getChirpRevisionsForExport = `-- name: GetChirpRevisionsForExport :many
+SELECT chirp_revisions.id, chirp_revisions.created_at, chirp_revisions.chirp_id, chirp_revisions.body FROM chirp_revisions
+JOIN chirps ON chirps.id = chirp_revisions.chirp_id
+WHERE chirps.user_id = $1
+ORDER BY chirp_revisions.created_at ASC
+`
+
+func (q *Queries) GetChirpRevisionsForExport(ctx context.Context, userID uuid.UUID) ([]ChirpRevision, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpRevisionsForExport, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ChirpRevision
+	for rows.Next() {
+		var i ChirpRevision
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.ChirpID,
+			&i.Body,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsForExport = `-- name: GetChirpsForExport :many
+
+SELECT id, created_at, updated_at, body, user_id, search_vector, edited, deleted_at FROM chirps
+WHERE user_id = $1
+ORDER BY created_at ASC
+`
+
+// Everything below gathers the data that goes into an export. Unlike the public queries, these
+// include the user's own deleted chirps (until they're purged), since it's still data we hold:
+func (q *Queries) GetChirpsForExport(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsForExport, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.SearchVector,
+			&i.Edited,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDataExport = `-- name: GetDataExport :one
+SELECT id, created_at, updated_at, user_id, status, blob_key, size_bytes, completed_at, expires_at FROM data_exports
+WHERE id = $1 AND user_id = $2
+`
+
+type GetDataExportParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) GetDataExport(ctx context.Context, arg GetDataExportParams) (DataExport, error) {
+	row := q.db.QueryRowContext(ctx, getDataExport, arg.ID, arg.UserID)
+	var i DataExport
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Status,
+		&i.BlobKey,
+		&i.SizeBytes,
+		&i.CompletedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const getExpiredDataExports = `-- name: GetExpiredDataExports :many
+SELECT id, created_at, updated_at, user_id, status, blob_key, size_bytes, completed_at, expires_at FROM data_exports
+WHERE status = 'ready' AND expires_at <= NOW()
+`
+
+func (q *Queries) GetExpiredDataExports(ctx context.Context) ([]DataExport, error) {
+	rows, err := q.db.QueryContext(ctx, getExpiredDataExports)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DataExport
+	for rows.Next() {
+		var i DataExport
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.Status,
+			&i.BlobKey,
+			&i.SizeBytes,
+			&i.CompletedAt,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMediaForExport = `-- name: GetMediaForExport :many
+SELECT id, created_at, updated_at, user_id, chirp_id, content_type, size_bytes, width, height, blob_key, thumbnail_key, thumbnail_content_type FROM media
+WHERE user_id = $1
+ORDER BY created_at ASC
+`
+
+func (q *Queries) GetMediaForExport(ctx context.Context, userID uuid.UUID) ([]Medium, error) {
+	rows, err := q.db.QueryContext(ctx, getMediaForExport, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Medium
+	for rows.Next() {
+		var i Medium
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.ChirpID,
+			&i.ContentType,
+			&i.SizeBytes,
+			&i.Width,
+			&i.Height,
+			&i.BlobKey,
+			&i.ThumbnailKey,
+			&i.ThumbnailContentType,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPurgeableDataExports = `-- name: GetPurgeableDataExports :many
+SELECT data_exports.id, data_exports.created_at, data_exports.updated_at, data_exports.user_id, data_exports.status, data_exports.blob_key, data_exports.size_bytes, data_exports.completed_at, data_exports.expires_at FROM data_exports
+JOIN users ON users.id = data_exports.user_id
+WHERE data_exports.status = 'ready'
+    AND users.deleted_at < NOW() - make_interval(secs => $1::float8)
+`
+
+// Archives of users the purge job is about to delete (their rows go with the cascade, but the
+// blobs wouldn't):
+func (q *Queries) GetPurgeableDataExports(ctx context.Context, retentionSeconds float64) ([]DataExport, error) {
+	rows, err := q.db.QueryContext(ctx, getPurgeableDataExports, retentionSeconds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DataExport
+	for rows.Next() {
+		var i DataExport
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.Status,
+			&i.BlobKey,
+			&i.SizeBytes,
+			&i.CompletedAt,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getReadyDataExport = `-- name: GetReadyDataExport :one
+SELECT id, created_at, updated_at, user_id, status, blob_key, size_bytes, completed_at, expires_at FROM data_exports
+WHERE id = $1 AND status = 'ready' AND expires_at > NOW()
+`
+
+// Download links are signed, so they work without an access token; this just checks the archive
+// is still there:
+func (q *Queries) GetReadyDataExport(ctx context.Context, id uuid.UUID) (DataExport, error) {
+	row := q.db.QueryRowContext(ctx, getReadyDataExport, id)
+	var i DataExport
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Status,
+		&i.BlobKey,
+		&i.SizeBytes,
+		&i.CompletedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const getStoredDataExportsForUser = `-- name: GetStoredDataExportsForUser :many
+SELECT id, created_at, updated_at, user_id, status, blob_key, size_bytes, completed_at, expires_at FROM data_exports
+WHERE user_id = $1 AND status = 'ready'
+`
+
+// Archives still in the blob store for a user whose account is being deleted, so they can go
+// before their download window would have closed:
+func (q *Queries) GetStoredDataExportsForUser(ctx context.Context, userID uuid.UUID) ([]DataExport, error) {
+	rows, err := q.db.QueryContext(ctx, getStoredDataExportsForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DataExport
+	for rows.Next() {
+		var i DataExport
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.Status,
+			&i.BlobKey,
+			&i.SizeBytes,
+			&i.CompletedAt,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,95 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: impersonation.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+const createImpersonation = `-- name: CreateImpersonation :one
+INSERT INTO impersonations (id, created_at, admin_id, user_id, reason, expires_at, ended_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    NOW() + make_interval(secs => $4::float8),
+    NULL
+)
+RETURNING id, created_at, admin_id, user_id, reason, expires_at, ended_at
+`
+
+type CreateImpersonationParams struct {
+	AdminID          uuid.UUID
+	UserID           uuid.UUID
+	Reason           string
+	ExpiresInSeconds float64
+}
+
+func (q *Queries) CreateImpersonation(ctx context.Context, arg CreateImpersonationParams) (Impersonation, error) {
+	row := q.db.QueryRowContext(ctx, createImpersonation,
+		arg.AdminID,
+		arg.UserID,
+		arg.Reason,
+		arg.ExpiresInSeconds,
+	)
+	var i Impersonation
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.AdminID,
+		&i.UserID,
+		&i.Reason,
+		&i.ExpiresAt,
+		&i.EndedAt,
+	)
+	return i, err
+}
+
+const endImpersonation = `-- name: EndImpersonation :one
+UPDATE impersonations
+SET ended_at = NOW()
+WHERE id = $1 AND ended_at IS NULL AND expires_at > NOW()
+RETURNING id, created_at, admin_id, user_id, reason, expires_at, ended_at
+`
+
+func (q *Queries) EndImpersonation(ctx context.Context, id uuid.UUID) (Impersonation, error) {
+	row := q.db.QueryRowContext(ctx, endImpersonation, id)
+	var i Impersonation
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.AdminID,
+		&i.UserID,
+		&i.Reason,
+		&i.ExpiresAt,
+		&i.EndedAt,
+	)
+	return i, err
+}
+
+const getActiveImpersonation = `-- name: GetActiveImpersonation :one
+SELECT id, created_at, admin_id, user_id, reason, expires_at, ended_at FROM impersonations
+WHERE id = $1 AND ended_at IS NULL AND expires_at > NOW()
+`
+
+func (q *Queries) GetActiveImpersonation(ctx context.Context, id uuid.UUID) (Impersonation, error) {
+	row := q.db.QueryRowContext(ctx, getActiveImpersonation, id)
+	var i Impersonation
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.AdminID,
+		&i.UserID,
+		&i.Reason,
+		&i.ExpiresAt,
+		&i.EndedAt,
+	)
+	return i, err
+}
@@ -0,0 +1,109 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: login_failures.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/lib/pq"
+)
+
+const blockLogin = `-- name: BlockLogin :exec
+UPDATE login_failures
+SET blocked_until = NOW() + make_interval(secs => $1::float8)
+WHERE key = $2
+`
+
+type BlockLoginParams struct {
+	BlockSeconds float64
+	Key          string
+}
+
+func (q *Queries) BlockLogin(ctx context.Context, arg BlockLoginParams) error {
+	_, err := q.db.ExecContext(ctx, blockLogin, arg.BlockSeconds, arg.Key)
+	return err
+}
+
+const clearLoginFailures = `-- name: ClearLoginFailures :exec
+DELETE FROM login_failures
+WHERE key = $1
+`
+
+func (q *Queries) ClearLoginFailures(ctx context.Context, key string) error {
+	_, err := q.db.ExecContext(ctx, clearLoginFailures, key)
+	return err
+}
+
+const deleteStaleLoginFailures = `-- name: DeleteStaleLoginFailures :execrows
+DELETE FROM login_failures
+WHERE last_failure_at < NOW() - make_interval(secs => $1::float8)
+    AND (blocked_until IS NULL OR blocked_until < NOW())
+`
+
+func (q *Queries) DeleteStaleLoginFailures(ctx context.Context, windowSeconds float64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteStaleLoginFailures, windowSeconds)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const forgiveLoginAttempt = `-- name: ForgiveLoginAttempt :exec
+UPDATE login_failures
+SET failures = GREATEST(failures - 1, 0)
+WHERE key = $1
+`
+
+// Takes back an attempt that turned out to be a success:
+func (q *Queries) ForgiveLoginAttempt(ctx context.Context, key string) error {
+	_, err := q.db.ExecContext(ctx, forgiveLoginAttempt, key)
+	return err
+}
+
+const getLoginBlock = `-- name: GetLoginBlock :one
+SELECT EXTRACT(EPOCH FROM MAX(blocked_until) - NOW())::float8 AS retry_after_seconds
+FROM login_failures
+WHERE key = ANY($1::text[]) AND blocked_until > NOW()
+HAVING COUNT(*) > 0
+`
+
+// Returns how much longer the most restrictive of the given keys is blocked for, or no rows if none
+// are:
+func (q *Queries) GetLoginBlock(ctx context.Context, keys []string) (float64, error) {
+	row := q.db.QueryRowContext(ctx, getLoginBlock, pq.Array(keys))
+	var retry_after_seconds float64
+	err := row.Scan(&retry_after_seconds)
+	return retry_after_seconds, err
+}
+
+const recordLoginAttempt = `-- name: RecordLoginAttempt :one
+INSERT INTO login_failures (key, failures, last_failure_at, blocked_until)
+VALUES ($1, 1, NOW(), NULL)
+ON CONFLICT (key) DO UPDATE
+SET failures = CASE
+        WHEN login_failures.last_failure_at < NOW() - make_interval(secs => $2::float8) THEN 1
+        ELSE login_failures.failures + 1
+    END,
+    last_failure_at = NOW()
+WHERE login_failures.blocked_until IS NULL OR login_failures.blocked_until <= NOW()
+RETURNING failures
+`
+
+type RecordLoginAttemptParams struct {
+	Key           string
+	WindowSeconds float64
+}
+
+// Counts one more attempt, before the password is checked, so requests racing each other can't
+// all get in under the limit. A key that hasn't failed for window_seconds starts again from one, so
+// the odd typo spread over weeks never adds up to a lockout. Nothing is counted, and no rows come
+// back, while the key is blocked:
+func (q *Queries) RecordLoginAttempt(ctx context.Context, arg RecordLoginAttemptParams) (int32, error) {
+	row := q.db.QueryRowContext(ctx, recordLoginAttempt, arg.Key, arg.WindowSeconds)
+	var failures int32
+	err := row.Scan(&failures)
+	return failures, err
+}
@@ -0,0 +1,261 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: media.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const attachMediaToChirp = `-- name: AttachMediaToChirp :many
+UPDATE media
+SET chirp_id = $1, updated_at = NOW()
+WHERE id = ANY($2::uuid[])
+    AND user_id = $3
+    AND chirp_id IS NULL
+RETURNING id, created_at, updated_at, user_id, chirp_id, content_type, size_bytes, width, height, blob_key, thumbnail_key, thumbnail_content_type
+`
+
+type AttachMediaToChirpParams struct {
+	ChirpID uuid.NullUUID
+	Ids     []uuid.UUID
+	UserID  uuid.UUID
+}
+
+// Only the uploader's own, not-yet-attached media is updated, so the caller can compare the number
+// of returned rows with the number of IDs it asked for:
+func (q *Queries) AttachMediaToChirp(ctx context.Context, arg AttachMediaToChirpParams) ([]Medium, error) {
+	rows, err := q.db.QueryContext(ctx, attachMediaToChirp, arg.ChirpID, pq.Array(arg.Ids), arg.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Medium
+	for rows.Next() {
+		var i Medium
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.ChirpID,
+			&i.ContentType,
+			&i.SizeBytes,
+			&i.Width,
+			&i.Height,
+			&i.BlobKey,
+			&i.ThumbnailKey,
+			&i.ThumbnailContentType,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const createMedia = `-- name: CreateMedia :one
+INSERT INTO media (
+    id, created_at, updated_at, user_id, content_type, size_bytes, width, height,
+    blob_key, thumbnail_key, thumbnail_content_type
+)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9
+)
+RETURNING id, created_at, updated_at, user_id, chirp_id, content_type, size_bytes, width, height, blob_key, thumbnail_key, thumbnail_content_type
+`
+
+type CreateMediaParams struct {
+	ID                   uuid.UUID
+	UserID               uuid.UUID
+	ContentType          string
+	SizeBytes            int64
+	Width                int32
+	Height               int32
+	BlobKey              string
+	ThumbnailKey         string
+	ThumbnailContentType string
+}
+
+func (q *Queries) CreateMedia(ctx context.Context, arg CreateMediaParams) (Medium, error) {
+	row := q.db.QueryRowContext(ctx, createMedia,
+		arg.ID,
+		arg.UserID,
+		arg.ContentType,
+		arg.SizeBytes,
+		arg.Width,
+		arg.Height,
+		arg.BlobKey,
+		arg.ThumbnailKey,
+		arg.ThumbnailContentType,
+	)
+	var i Medium
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ChirpID,
+		&i.ContentType,
+		&i.SizeBytes,
+		&i.Width,
+		&i.Height,
+		&i.BlobKey,
+		&i.ThumbnailKey,
+		&i.ThumbnailContentType,
+	)
+	return i, err
+}
+
+const deleteMedia = `-- name: DeleteMedia :exec
+DELETE FROM media
+WHERE id = $1
+`
+
+func (q *Queries) DeleteMedia(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteMedia, id)
+	return err
+}
+
+const getMedia = `-- name: GetMedia :one
+SELECT id, created_at, updated_at, user_id, chirp_id, content_type, size_bytes, width, height, blob_key, thumbnail_key, thumbnail_content_type FROM media
+WHERE media.id = $1
+    AND NOT EXISTS (SELECT 1 FROM chirps WHERE chirps.id = media.chirp_id AND chirps.deleted_at IS NOT NULL)
+    AND EXISTS (SELECT 1 FROM users WHERE users.id = media.user_id AND users.deleted_at IS NULL)
+`
+
+// Media on a deleted chirp, or uploaded by a deleted user, is hidden along with them:
+func (q *Queries) GetMedia(ctx context.Context, id uuid.UUID) (Medium, error) {
+	row := q.db.QueryRowContext(ctx, getMedia, id)
+	var i Medium
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ChirpID,
+		&i.ContentType,
+		&i.SizeBytes,
+		&i.Width,
+		&i.Height,
+		&i.BlobKey,
+		&i.ThumbnailKey,
+		&i.ThumbnailContentType,
+	)
+	return i, err
+}
+
+const getMediaForChirps = `-- name: GetMediaForChirps :many
+SELECT id, created_at, updated_at, user_id, chirp_id, content_type, size_bytes, width, height, blob_key, thumbnail_key, thumbnail_content_type FROM media
+WHERE chirp_id = ANY($1::uuid[])
+ORDER BY created_at ASC
+`
+
+func (q *Queries) GetMediaForChirps(ctx context.Context, chirpIds []uuid.UUID) ([]Medium, error) {
+	rows, err := q.db.QueryContext(ctx, getMediaForChirps, pq.Array(chirpIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Medium
+	for rows.Next() {
+		var i Medium
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.ChirpID,
+			&i.ContentType,
+			&i.SizeBytes,
+			&i.Width,
+			&i.Height,
+			&i.BlobKey,
+			&i.ThumbnailKey,
+			&i.ThumbnailContentType,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPurgeableMedia = `-- name: GetPurgeableMedia :many
+SELECT id, created_at, updated_at, user_id, chirp_id, content_type, size_bytes, width, height, blob_key, thumbnail_key, thumbnail_content_type FROM media
+WHERE EXISTS (
+        SELECT 1 FROM chirps
+        WHERE chirps.id = media.chirp_id
+            AND chirps.deleted_at < NOW() - make_interval(secs => $1::float8)
+    )
+    OR EXISTS (
+        SELECT 1 FROM users
+        WHERE users.id = media.user_id
+            AND users.deleted_at < NOW() - make_interval(secs => $1::float8)
+    )
+`
+
+// Media that belongs to chirps or users tombstoned before the retention cutoff, i.e. everything the
+// purge job is about to cascade-delete:
+func (q *Queries) GetPurgeableMedia(ctx context.Context, retentionSeconds float64) ([]Medium, error) {
+	rows, err := q.db.QueryContext(ctx, getPurgeableMedia, retentionSeconds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Medium
+	for rows.Next() {
+		var i Medium
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.ChirpID,
+			&i.ContentType,
+			&i.SizeBytes,
+			&i.Width,
+			&i.Height,
+			&i.BlobKey,
+			&i.ThumbnailKey,
+			&i.ThumbnailContentType,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -5,23 +5,224 @@
 package database
 
 import (
+	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type ApiKey struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UserID     uuid.UUID
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Scopes     []string
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+type AuditEvent struct {
+	ID         int64
+	CreatedAt  time.Time
+	ActorID    uuid.NullUUID
+	OnBehalfOf uuid.NullUUID
+	Action     string
+	Target     string
+	Ip         string
+	Details    json.RawMessage
+	RequestID  string
+	Diff       json.RawMessage
+	PrevHash   sql.NullString
+	Hash       sql.NullString
+}
+
 type Chirp struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Body         string
+	UserID       uuid.UUID
+	SearchVector interface{}
+	Edited       bool
+	DeletedAt    sql.NullTime
+}
+
+type ChirpRevision struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
-	UpdatedAt time.Time
+	ChirpID   uuid.UUID
 	Body      string
+}
+
+type DataExport struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	UserID      uuid.UUID
+	Status      string
+	BlobKey     sql.NullString
+	SizeBytes   sql.NullInt64
+	CompletedAt sql.NullTime
+	ExpiresAt   sql.NullTime
+}
+
+type EmailVerificationToken struct {
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	Email     string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type Impersonation struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	AdminID   uuid.UUID
 	UserID    uuid.UUID
+	Reason    string
+	ExpiresAt time.Time
+	EndedAt   sql.NullTime
+}
+
+type LoginFailure struct {
+	Key           string
+	Failures      int32
+	LastFailureAt time.Time
+	BlockedUntil  sql.NullTime
+}
+
+type Medium struct {
+	ID                   uuid.UUID
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+	UserID               uuid.UUID
+	ChirpID              uuid.NullUUID
+	ContentType          string
+	SizeBytes            int64
+	Width                int32
+	Height               int32
+	BlobKey              string
+	ThumbnailKey         string
+	ThumbnailContentType string
+}
+
+type OauthAuthorizationCode struct {
+	CodeHash      string
+	CreatedAt     time.Time
+	GrantID       uuid.UUID
+	ClientID      uuid.UUID
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scopes        []string
+	CodeChallenge string
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+type OauthClient struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	OwnerID      uuid.UUID
+	Name         string
+	RedirectUris []string
+	SecretHash   sql.NullString
+}
+
+type OauthToken struct {
+	TokenHash string
+	CreatedAt time.Time
+	Kind      string
+	GrantID   uuid.UUID
+	ClientID  uuid.UUID
+	UserID    uuid.UUID
+	Scopes    []string
+	ExpiresAt time.Time
+	RevokedAt sql.NullTime
+}
+
+type OidcLoginState struct {
+	StateHash     string
+	CreatedAt     time.Time
+	Nonce         string
+	CodeVerifier  string
+	ExpiresAt     time.Time
+	CookieSession bool
+}
+
+type PasswordResetToken struct {
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type RecoveryCode struct {
+	CodeHash  string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	UsedAt    sql.NullTime
+}
+
+type RefreshToken struct {
+	TokenHash string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	RevokedAt sql.NullTime
+	SessionID uuid.NullUUID
+}
+
+type Session struct {
+	ID         uuid.UUID
+	TokenHash  sql.NullString
+	UserID     uuid.UUID
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  sql.NullTime
+	UserAgent  string
+	Ip         string
 }
 
 type User struct {
-	ID             uuid.UUID
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
+	ID                  uuid.UUID
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	Email               string
+	HashedPassword      string
+	Handle              string
+	DisplayName         string
+	Bio                 string
+	AvatarUrl           string
+	DeletedAt           sql.NullTime
+	DeletionScheduledAt sql.NullTime
+	EmailVerifiedAt     sql.NullTime
+	TotpSecret          sql.NullString
+	TotpEnabledAt       sql.NullTime
+	TotpLastStep        sql.NullInt64
+	AnonymizedAt        sql.NullTime
+}
+
+type UserIdentity struct {
+	Issuer      string
+	Subject     string
+	UserID      uuid.UUID
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+}
+
+type UserRole struct {
+	UserID    uuid.UUID
+	Role      string
+	GrantedAt time.Time
+	GrantedBy uuid.NullUUID
 }
@@ -0,0 +1,405 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: oauth.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const consumeOAuthAuthorizationCode = `-- name: ConsumeOAuthAuthorizationCode :one
+UPDATE oauth_authorization_codes
+SET used_at = NOW()
+WHERE code_hash = $1
+    AND used_at IS NULL
+    AND expires_at > NOW()
+    AND EXISTS (
+        SELECT 1 FROM users
+        WHERE users.id = oauth_authorization_codes.user_id AND users.deleted_at IS NULL
+    )
+RETURNING code_hash, created_at, grant_id, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at, used_at
+`
+
+// Marks the code used and returns it, or no rows if it's unknown, expired or already used. Codes
+// for accounts that have since been deleted are refused too:
+func (q *Queries) ConsumeOAuthAuthorizationCode(ctx context.Context, codeHash string) (OauthAuthorizationCode, error) {
+	row := q.db.QueryRowContext(ctx, consumeOAuthAuthorizationCode, codeHash)
+	var i OauthAuthorizationCode
+	err := row.Scan(
+		&i.CodeHash,
+		&i.CreatedAt,
+		&i.GrantID,
+		&i.ClientID,
+		&i.UserID,
+		&i.RedirectUri,
+		pq.Array(&i.Scopes),
+		&i.CodeChallenge,
+		&i.ExpiresAt,
+		&i.UsedAt,
+	)
+	return i, err
+}
+
+const consumeOAuthRefreshToken = `-- name: ConsumeOAuthRefreshToken :one
+UPDATE oauth_tokens
+SET revoked_at = NOW()
+WHERE token_hash = $1
+    AND kind = 'refresh'
+    AND revoked_at IS NULL
+    AND expires_at > NOW()
+RETURNING token_hash, created_at, kind, grant_id, client_id, user_id, scopes, expires_at, revoked_at
+`
+
+// Refresh tokens are single use: redeeming one revokes it and hands back its row. No rows means it
+// was unknown, expired or already used:
+func (q *Queries) ConsumeOAuthRefreshToken(ctx context.Context, tokenHash string) (OauthToken, error) {
+	row := q.db.QueryRowContext(ctx, consumeOAuthRefreshToken, tokenHash)
+	var i OauthToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.Kind,
+		&i.GrantID,
+		&i.ClientID,
+		&i.UserID,
+		pq.Array(&i.Scopes),
+		&i.ExpiresAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const createOAuthAuthorizationCode = `-- name: CreateOAuthAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (
+    code_hash, created_at, grant_id, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at
+)
+VALUES (
+    $1,
+    NOW(),
+    gen_random_uuid(),
+    $2,
+    $3,
+    $4,
+    $5::text[],
+    $6,
+    NOW() + make_interval(secs => $7::float8)
+)
+`
+
+type CreateOAuthAuthorizationCodeParams struct {
+	CodeHash         string
+	ClientID         uuid.UUID
+	UserID           uuid.UUID
+	RedirectUri      string
+	Scopes           []string
+	CodeChallenge    string
+	ExpiresInSeconds float64
+}
+
+func (q *Queries) CreateOAuthAuthorizationCode(ctx context.Context, arg CreateOAuthAuthorizationCodeParams) error {
+	_, err := q.db.ExecContext(ctx, createOAuthAuthorizationCode,
+		arg.CodeHash,
+		arg.ClientID,
+		arg.UserID,
+		arg.RedirectUri,
+		pq.Array(arg.Scopes),
+		arg.CodeChallenge,
+		arg.ExpiresInSeconds,
+	)
+	return err
+}
+
+const createOAuthClient = `-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, created_at, updated_at, owner_id, name, redirect_uris, secret_hash)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3::text[],
+    $4
+)
+RETURNING id, created_at, updated_at, owner_id, name, redirect_uris, secret_hash
+`
+
+type CreateOAuthClientParams struct {
+	OwnerID      uuid.UUID
+	Name         string
+	RedirectUris []string
+	SecretHash   sql.NullString
+}
+
+func (q *Queries) CreateOAuthClient(ctx context.Context, arg CreateOAuthClientParams) (OauthClient, error) {
+	row := q.db.QueryRowContext(ctx, createOAuthClient,
+		arg.OwnerID,
+		arg.Name,
+		pq.Array(arg.RedirectUris),
+		arg.SecretHash,
+	)
+	var i OauthClient
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.OwnerID,
+		&i.Name,
+		pq.Array(&i.RedirectUris),
+		&i.SecretHash,
+	)
+	return i, err
+}
+
+const createOAuthToken = `-- name: CreateOAuthToken :exec
+INSERT INTO oauth_tokens (token_hash, created_at, kind, grant_id, client_id, user_id, scopes, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6::text[],
+    NOW() + make_interval(secs => $7::float8)
+)
+`
+
+type CreateOAuthTokenParams struct {
+	TokenHash        string
+	Kind             string
+	GrantID          uuid.UUID
+	ClientID         uuid.UUID
+	UserID           uuid.UUID
+	Scopes           []string
+	ExpiresInSeconds float64
+}
+
+func (q *Queries) CreateOAuthToken(ctx context.Context, arg CreateOAuthTokenParams) error {
+	_, err := q.db.ExecContext(ctx, createOAuthToken,
+		arg.TokenHash,
+		arg.Kind,
+		arg.GrantID,
+		arg.ClientID,
+		arg.UserID,
+		pq.Array(arg.Scopes),
+		arg.ExpiresInSeconds,
+	)
+	return err
+}
+
+const deleteOAuthClient = `-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients
+WHERE id = $1 AND owner_id = $2
+`
+
+type DeleteOAuthClientParams struct {
+	ID      uuid.UUID
+	OwnerID uuid.UUID
+}
+
+// Deleting a client cascades to its codes and tokens, so every app session ends with it:
+func (q *Queries) DeleteOAuthClient(ctx context.Context, arg DeleteOAuthClientParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteOAuthClient, arg.ID, arg.OwnerID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteStaleOAuthCodes = `-- name: DeleteStaleOAuthCodes :exec
+DELETE FROM oauth_authorization_codes
+WHERE expires_at < NOW() - make_interval(secs => $1::float8)
+`
+
+// Used codes and dead tokens are kept for a while so replays can still be recognised, then
+// deleted:
+func (q *Queries) DeleteStaleOAuthCodes(ctx context.Context, retentionSeconds float64) error {
+	_, err := q.db.ExecContext(ctx, deleteStaleOAuthCodes, retentionSeconds)
+	return err
+}
+
+const deleteStaleOAuthTokens = `-- name: DeleteStaleOAuthTokens :exec
+DELETE FROM oauth_tokens
+WHERE expires_at < NOW() - make_interval(secs => $1::float8)
+`
+
+func (q *Queries) DeleteStaleOAuthTokens(ctx context.Context, retentionSeconds float64) error {
+	_, err := q.db.ExecContext(ctx, deleteStaleOAuthTokens, retentionSeconds)
+	return err
+}
+
+const getActiveOAuthToken = `-- name: GetActiveOAuthToken :one
+SELECT oauth_tokens.token_hash, oauth_tokens.created_at, oauth_tokens.kind, oauth_tokens.grant_id, oauth_tokens.client_id, oauth_tokens.user_id, oauth_tokens.scopes, oauth_tokens.expires_at, oauth_tokens.revoked_at, users.handle FROM oauth_tokens
+JOIN users ON users.id = oauth_tokens.user_id
+WHERE oauth_tokens.token_hash = $1
+    AND oauth_tokens.revoked_at IS NULL
+    AND oauth_tokens.expires_at > NOW()
+    AND users.deleted_at IS NULL
+`
+
+type GetActiveOAuthTokenRow struct {
+	TokenHash string
+	CreatedAt time.Time
+	Kind      string
+	GrantID   uuid.UUID
+	ClientID  uuid.UUID
+	UserID    uuid.UUID
+	Scopes    []string
+	ExpiresAt time.Time
+	RevokedAt sql.NullTime
+	Handle    string
+}
+
+// Only tokens that are unrevoked, unexpired and belong to a live account are returned. The handle
+// is for the introspection response:
+func (q *Queries) GetActiveOAuthToken(ctx context.Context, tokenHash string) (GetActiveOAuthTokenRow, error) {
+	row := q.db.QueryRowContext(ctx, getActiveOAuthToken, tokenHash)
+	var i GetActiveOAuthTokenRow
+	err := row.Scan(
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.Kind,
+		&i.GrantID,
+		&i.ClientID,
+		&i.UserID,
+		pq.Array(&i.Scopes),
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.Handle,
+	)
+	return i, err
+}
+
+const getOAuthAuthorizationCodeGrant = `-- name: GetOAuthAuthorizationCodeGrant :one
+SELECT grant_id FROM oauth_authorization_codes
+WHERE code_hash = $1
+`
+
+// Finds the grant a code belongs to even if it's been used, so a replayed code can revoke
+// everything that was issued from it:
+func (q *Queries) GetOAuthAuthorizationCodeGrant(ctx context.Context, codeHash string) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, getOAuthAuthorizationCodeGrant, codeHash)
+	var grant_id uuid.UUID
+	err := row.Scan(&grant_id)
+	return grant_id, err
+}
+
+const getOAuthClient = `-- name: GetOAuthClient :one
+SELECT id, created_at, updated_at, owner_id, name, redirect_uris, secret_hash FROM oauth_clients
+WHERE id = $1
+`
+
+func (q *Queries) GetOAuthClient(ctx context.Context, id uuid.UUID) (OauthClient, error) {
+	row := q.db.QueryRowContext(ctx, getOAuthClient, id)
+	var i OauthClient
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.OwnerID,
+		&i.Name,
+		pq.Array(&i.RedirectUris),
+		&i.SecretHash,
+	)
+	return i, err
+}
+
+const getOAuthToken = `-- name: GetOAuthToken :one
+SELECT token_hash, created_at, kind, grant_id, client_id, user_id, scopes, expires_at, revoked_at FROM oauth_tokens
+WHERE token_hash = $1
+`
+
+// Looks a token up whatever its state, to spot a refresh token being replayed:
+func (q *Queries) GetOAuthToken(ctx context.Context, tokenHash string) (OauthToken, error) {
+	row := q.db.QueryRowContext(ctx, getOAuthToken, tokenHash)
+	var i OauthToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.Kind,
+		&i.GrantID,
+		&i.ClientID,
+		&i.UserID,
+		pq.Array(&i.Scopes),
+		&i.ExpiresAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const listOAuthClients = `-- name: ListOAuthClients :many
+SELECT id, created_at, updated_at, owner_id, name, redirect_uris, secret_hash FROM oauth_clients
+WHERE owner_id = $1
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListOAuthClients(ctx context.Context, ownerID uuid.UUID) ([]OauthClient, error) {
+	rows, err := q.db.QueryContext(ctx, listOAuthClients, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []OauthClient
+	for rows.Next() {
+		var i OauthClient
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.OwnerID,
+			&i.Name,
+			pq.Array(&i.RedirectUris),
+			&i.SecretHash,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokeAllOAuthTokensForUser = `-- name: RevokeAllOAuthTokensForUser :exec
+UPDATE oauth_tokens
+SET revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAllOAuthTokensForUser(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeAllOAuthTokensForUser, userID)
+	return err
+}
+
+const revokeOAuthGrant = `-- name: RevokeOAuthGrant :exec
+UPDATE oauth_tokens
+SET revoked_at = NOW()
+WHERE grant_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeOAuthGrant(ctx context.Context, grantID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeOAuthGrant, grantID)
+	return err
+}
+
+const revokeOAuthToken = `-- name: RevokeOAuthToken :exec
+UPDATE oauth_tokens
+SET revoked_at = NOW()
+WHERE token_hash = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeOAuthToken(ctx context.Context, tokenHash string) error {
+	_, err := q.db.ExecContext(ctx, revokeOAuthToken, tokenHash)
+	return err
+}
@@ -0,0 +1,249 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: oidc.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+const consumeOIDCLoginState = `-- name: ConsumeOIDCLoginState :one
+DELETE FROM oidc_login_states
+WHERE state_hash = $1 AND expires_at > NOW()
+RETURNING state_hash, created_at, nonce, code_verifier, expires_at, cookie_session
+`
+
+// Deleting the row as we read it means a state can only be used once:
+func (q *Queries) ConsumeOIDCLoginState(ctx context.Context, stateHash string) (OidcLoginState, error) {
+	row := q.db.QueryRowContext(ctx, consumeOIDCLoginState, stateHash)
+	var i OidcLoginState
+	err := row.Scan(
+		&i.StateHash,
+		&i.CreatedAt,
+		&i.Nonce,
+		&i.CodeVerifier,
+		&i.ExpiresAt,
+		&i.CookieSession,
+	)
+	return i, err
+}
+
+const createOIDCLoginState = `-- name: CreateOIDCLoginState :exec
+INSERT INTO oidc_login_states (state_hash, created_at, nonce, code_verifier, expires_at, cookie_session)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    NOW() + make_interval(secs => $5::float8),
+    $4
+)
+`
+
+type CreateOIDCLoginStateParams struct {
+	StateHash        string
+	Nonce            string
+	CodeVerifier     string
+	CookieSession    bool
+	ExpiresInSeconds float64
+}
+
+func (q *Queries) CreateOIDCLoginState(ctx context.Context, arg CreateOIDCLoginStateParams) error {
+	_, err := q.db.ExecContext(ctx, createOIDCLoginState,
+		arg.StateHash,
+		arg.Nonce,
+		arg.CodeVerifier,
+		arg.CookieSession,
+		arg.ExpiresInSeconds,
+	)
+	return err
+}
+
+const createUserIdentity = `-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (issuer, subject, user_id, email, created_at, last_login_at)
+VALUES ($1, $2, $3, $4, NOW(), NOW())
+`
+
+type CreateUserIdentityParams struct {
+	Issuer  string
+	Subject string
+	UserID  uuid.UUID
+	Email   string
+}
+
+func (q *Queries) CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) error {
+	_, err := q.db.ExecContext(ctx, createUserIdentity,
+		arg.Issuer,
+		arg.Subject,
+		arg.UserID,
+		arg.Email,
+	)
+	return err
+}
+
+const createVerifiedUser = `-- name: CreateVerifiedUser :one
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle, display_name, email_verified_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    NOW()
+)
+RETURNING id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url, deleted_at, deletion_scheduled_at, email_verified_at, totp_secret, totp_enabled_at, totp_last_step, anonymized_at
+`
+
+type CreateVerifiedUserParams struct {
+	Email          string
+	HashedPassword string
+	Handle         string
+	DisplayName    string
+}
+
+// Creates an account for someone signing in with a provider for the first time. The provider has
+// already checked the email address, so it starts out verified:
+func (q *Queries) CreateVerifiedUser(ctx context.Context, arg CreateVerifiedUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, createVerifiedUser,
+		arg.Email,
+		arg.HashedPassword,
+		arg.Handle,
+		arg.DisplayName,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.DeletedAt,
+		&i.DeletionScheduledAt,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.AnonymizedAt,
+	)
+	return i, err
+}
+
+const deleteExpiredOIDCLoginStates = `-- name: DeleteExpiredOIDCLoginStates :exec
+DELETE FROM oidc_login_states
+WHERE expires_at <= NOW()
+`
+
+func (q *Queries) DeleteExpiredOIDCLoginStates(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredOIDCLoginStates)
+	return err
+}
+
+const deleteUserIdentities = `-- name: DeleteUserIdentities :exec
+DELETE FROM user_identities
+WHERE user_id = $1
+`
+
+func (q *Queries) DeleteUserIdentities(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteUserIdentities, userID)
+	return err
+}
+
+const getUserByEmailForIdentity = `-- name: GetUserByEmailForIdentity :one
+SELECT id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url, deleted_at, deletion_scheduled_at, email_verified_at, totp_secret, totp_enabled_at, totp_last_step, anonymized_at FROM users
+WHERE lower(email) = lower($1::text)
+    AND deleted_at IS NULL
+    AND anonymized_at IS NULL
+ORDER BY email = $1::text DESC, created_at
+LIMIT 1
+`
+
+// Providers don't always send an address the way the user typed it when they signed up, so it's
+// matched ignoring case. If two accounts differ only in case, the exact match wins:
+func (q *Queries) GetUserByEmailForIdentity(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByEmailForIdentity, email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.DeletedAt,
+		&i.DeletionScheduledAt,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.AnonymizedAt,
+	)
+	return i, err
+}
+
+const getUserByIdentity = `-- name: GetUserByIdentity :one
+SELECT users.id, users.created_at, users.updated_at, users.email, users.hashed_password, users.handle, users.display_name, users.bio, users.avatar_url, users.deleted_at, users.deletion_scheduled_at, users.email_verified_at, users.totp_secret, users.totp_enabled_at, users.totp_last_step, users.anonymized_at FROM users
+JOIN user_identities ON user_identities.user_id = users.id
+WHERE user_identities.issuer = $1
+    AND user_identities.subject = $2
+    AND users.deleted_at IS NULL
+    AND users.anonymized_at IS NULL
+`
+
+type GetUserByIdentityParams struct {
+	Issuer  string
+	Subject string
+}
+
+func (q *Queries) GetUserByIdentity(ctx context.Context, arg GetUserByIdentityParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByIdentity, arg.Issuer, arg.Subject)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.DeletedAt,
+		&i.DeletionScheduledAt,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.AnonymizedAt,
+	)
+	return i, err
+}
+
+const touchUserIdentity = `-- name: TouchUserIdentity :exec
+UPDATE user_identities
+SET email = $3, last_login_at = NOW()
+WHERE issuer = $1 AND subject = $2
+`
+
+type TouchUserIdentityParams struct {
+	Issuer  string
+	Subject string
+	Email   string
+}
+
+func (q *Queries) TouchUserIdentity(ctx context.Context, arg TouchUserIdentityParams) error {
+	_, err := q.db.ExecContext(ctx, touchUserIdentity, arg.Issuer, arg.Subject, arg.Email)
+	return err
+}
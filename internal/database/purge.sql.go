@@ -0,0 +1,39 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: purge.sql
+
+package database
+
+import (
+	"context"
+)
+
+const purgeDeletedChirps = `-- name: PurgeDeletedChirps :execrows
+
+DELETE FROM chirps
+WHERE deleted_at < NOW() - make_interval(secs => $1::float8)
+`
+
+// Hard-delete rows that were tombstoned more than retention_seconds ago. Foreign keys cascade, so
+// purging a user also removes their chirps, media rows and revisions:
+func (q *Queries) PurgeDeletedChirps(ctx context.Context, retentionSeconds float64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedChirps, retentionSeconds)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const purgeDeletedUsers = `-- name: PurgeDeletedUsers :execrows
+DELETE FROM users
+WHERE deleted_at < NOW() - make_interval(secs => $1::float8)
+`
+
+func (q *Queries) PurgeDeletedUsers(ctx context.Context, retentionSeconds float64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedUsers, retentionSeconds)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
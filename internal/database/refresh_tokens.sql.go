@@ -0,0 +1,131 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: refresh_tokens.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+const createRefreshToken = `-- name: CreateRefreshToken :one
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, user_id, expires_at, revoked_at, session_id)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    NOW() + make_interval(secs => $4::float8),
+    NULL,
+    $3
+)
+RETURNING token_hash, created_at, updated_at, user_id, expires_at, revoked_at, session_id
+`
+
+type CreateRefreshTokenParams struct {
+	TokenHash        string
+	UserID           uuid.UUID
+	SessionID        uuid.NullUUID
+	ExpiresInSeconds float64
+}
+
+func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, createRefreshToken,
+		arg.TokenHash,
+		arg.UserID,
+		arg.SessionID,
+		arg.ExpiresInSeconds,
+	)
+	var i RefreshToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.SessionID,
+	)
+	return i, err
+}
+
+const getActiveRefreshToken = `-- name: GetActiveRefreshToken :one
+SELECT refresh_tokens.token_hash, refresh_tokens.created_at, refresh_tokens.updated_at, refresh_tokens.user_id, refresh_tokens.expires_at, refresh_tokens.revoked_at, refresh_tokens.session_id FROM refresh_tokens
+JOIN users ON users.id = refresh_tokens.user_id
+WHERE refresh_tokens.token_hash = $1
+    AND refresh_tokens.revoked_at IS NULL
+    AND refresh_tokens.expires_at > NOW()
+    AND users.deleted_at IS NULL
+`
+
+// Only tokens that are unrevoked, unexpired and belong to a live account are accepted:
+func (q *Queries) GetActiveRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getActiveRefreshToken, tokenHash)
+	var i RefreshToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.SessionID,
+	)
+	return i, err
+}
+
+const revokeAllRefreshTokensForUser = `-- name: RevokeAllRefreshTokensForUser :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAllRefreshTokensForUser(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeAllRefreshTokensForUser, userID)
+	return err
+}
+
+const revokeOtherRefreshTokensForUser = `-- name: RevokeOtherRefreshTokensForUser :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+    AND session_id IS DISTINCT FROM $2::uuid
+    AND revoked_at IS NULL
+`
+
+type RevokeOtherRefreshTokensForUserParams struct {
+	UserID        uuid.UUID
+	KeepSessionID uuid.UUID
+}
+
+// Revokes everything except the given session's tokens. Tokens from before sessions existed have
+// no session, so they always go:
+func (q *Queries) RevokeOtherRefreshTokensForUser(ctx context.Context, arg RevokeOtherRefreshTokensForUserParams) error {
+	_, err := q.db.ExecContext(ctx, revokeOtherRefreshTokensForUser, arg.UserID, arg.KeepSessionID)
+	return err
+}
+
+const revokeRefreshToken = `-- name: RevokeRefreshToken :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE token_hash = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshToken(ctx context.Context, tokenHash string) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshToken, tokenHash)
+	return err
+}
+
+const revokeRefreshTokensForSession = `-- name: RevokeRefreshTokensForSession :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE session_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokensForSession(ctx context.Context, sessionID uuid.NullUUID) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokensForSession, sessionID)
+	return err
+}
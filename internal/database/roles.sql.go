@@ -0,0 +1,94 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: roles.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+const countUsersWithRole = `-- name: CountUsersWithRole :one
+SELECT COUNT(*) FROM user_roles
+JOIN users ON users.id = user_roles.user_id
+WHERE user_roles.role = $1
+    AND users.deleted_at IS NULL
+    AND users.hashed_password <> 'unset'
+`
+
+// Only counts accounts someone can still log in to: not deleted, and not anonymized (which leaves
+// the row but sets a password hash nothing matches):
+func (q *Queries) CountUsersWithRole(ctx context.Context, role string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUsersWithRole, role)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const getUserRoles = `-- name: GetUserRoles :many
+SELECT role FROM user_roles
+WHERE user_id = $1
+ORDER BY role
+`
+
+func (q *Queries) GetUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, getUserRoles, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		items = append(items, role)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const grantRole = `-- name: GrantRole :exec
+INSERT INTO user_roles (user_id, role, granted_by)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, role) DO NOTHING
+`
+
+type GrantRoleParams struct {
+	UserID    uuid.UUID
+	Role      string
+	GrantedBy uuid.NullUUID
+}
+
+// Granting a role someone already has is a no-op:
+func (q *Queries) GrantRole(ctx context.Context, arg GrantRoleParams) error {
+	_, err := q.db.ExecContext(ctx, grantRole, arg.UserID, arg.Role, arg.GrantedBy)
+	return err
+}
+
+const revokeRole = `-- name: RevokeRole :execrows
+DELETE FROM user_roles
+WHERE user_id = $1 AND role = $2
+`
+
+type RevokeRoleParams struct {
+	UserID uuid.UUID
+	Role   string
+}
+
+func (q *Queries) RevokeRole(ctx context.Context, arg RevokeRoleParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeRole, arg.UserID, arg.Role)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,254 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: sessions.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+const accessTokenStillValid = `-- name: AccessTokenStillValid :one
+SELECT (
+    EXISTS (
+        SELECT 1 FROM users
+        WHERE users.id = $1
+            AND users.deleted_at IS NULL
+            AND users.deletion_scheduled_at IS NULL
+    )
+    AND (
+        $2::uuid IS NULL OR EXISTS (
+            SELECT 1 FROM sessions
+            WHERE sessions.id = $2
+                AND sessions.user_id = $1
+                AND sessions.revoked_at IS NULL
+                AND sessions.expires_at > NOW()
+        )
+    )
+)::boolean AS valid
+`
+
+type AccessTokenStillValidParams struct {
+	UserID    uuid.UUID
+	SessionID uuid.NullUUID
+}
+
+// Access tokens can't be revoked themselves, so each use checks the account is still live and not
+// waiting to be deleted, and, for a token from a login, that its session hasn't been ended:
+func (q *Queries) AccessTokenStillValid(ctx context.Context, arg AccessTokenStillValidParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, accessTokenStillValid, arg.UserID, arg.SessionID)
+	var valid bool
+	err := row.Scan(&valid)
+	return valid, err
+}
+
+const createSession = `-- name: CreateSession :one
+INSERT INTO sessions (id, token_hash, user_id, created_at, last_seen_at, expires_at, revoked_at, user_agent, ip)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    NOW(),
+    NOW(),
+    NOW() + make_interval(secs => $5::float8),
+    NULL,
+    $3,
+    $4
+)
+RETURNING id, token_hash, user_id, created_at, last_seen_at, expires_at, revoked_at, user_agent, ip
+`
+
+type CreateSessionParams struct {
+	TokenHash        sql.NullString
+	UserID           uuid.UUID
+	UserAgent        string
+	Ip               string
+	ExpiresInSeconds float64
+}
+
+// token_hash is NULL for logins that get tokens rather than a cookie:
+func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error) {
+	row := q.db.QueryRowContext(ctx, createSession,
+		arg.TokenHash,
+		arg.UserID,
+		arg.UserAgent,
+		arg.Ip,
+		arg.ExpiresInSeconds,
+	)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.TokenHash,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.LastSeenAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.UserAgent,
+		&i.Ip,
+	)
+	return i, err
+}
+
+const deleteDeadSessions = `-- name: DeleteDeadSessions :exec
+DELETE FROM sessions
+WHERE expires_at < NOW() OR revoked_at IS NOT NULL
+`
+
+func (q *Queries) DeleteDeadSessions(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteDeadSessions)
+	return err
+}
+
+const getActiveSession = `-- name: GetActiveSession :one
+SELECT sessions.id, sessions.token_hash, sessions.user_id, sessions.created_at, sessions.last_seen_at, sessions.expires_at, sessions.revoked_at, sessions.user_agent, sessions.ip FROM sessions
+JOIN users ON users.id = sessions.user_id
+WHERE sessions.token_hash = $1::text
+    AND sessions.revoked_at IS NULL
+    AND sessions.expires_at > NOW()
+    AND users.deleted_at IS NULL
+`
+
+// Like refresh tokens, only sessions that are unrevoked, unexpired and belong to a live account
+// are accepted:
+func (q *Queries) GetActiveSession(ctx context.Context, tokenHash string) (Session, error) {
+	row := q.db.QueryRowContext(ctx, getActiveSession, tokenHash)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.TokenHash,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.LastSeenAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.UserAgent,
+		&i.Ip,
+	)
+	return i, err
+}
+
+const listSessions = `-- name: ListSessions :many
+SELECT id, token_hash, user_id, created_at, last_seen_at, expires_at, revoked_at, user_agent, ip FROM sessions
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY last_seen_at DESC
+`
+
+func (q *Queries) ListSessions(ctx context.Context, userID uuid.UUID) ([]Session, error) {
+	rows, err := q.db.QueryContext(ctx, listSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Session
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.ID,
+			&i.TokenHash,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.LastSeenAt,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.UserAgent,
+			&i.Ip,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokeAllSessionsForUser = `-- name: RevokeAllSessionsForUser :exec
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAllSessionsForUser(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeAllSessionsForUser, userID)
+	return err
+}
+
+const revokeOtherSessionsForUser = `-- name: RevokeOtherSessionsForUser :exec
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+`
+
+type RevokeOtherSessionsForUserParams struct {
+	UserID        uuid.UUID
+	KeepSessionID uuid.UUID
+}
+
+func (q *Queries) RevokeOtherSessionsForUser(ctx context.Context, arg RevokeOtherSessionsForUserParams) error {
+	_, err := q.db.ExecContext(ctx, revokeOtherSessionsForUser, arg.UserID, arg.KeepSessionID)
+	return err
+}
+
+const revokeSession = `-- name: RevokeSession :execrows
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+`
+
+type RevokeSessionParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) RevokeSession(ctx context.Context, arg RevokeSessionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeSession, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeSessionByHash = `-- name: RevokeSessionByHash :exec
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE token_hash = $1::text AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeSessionByHash(ctx context.Context, tokenHash string) error {
+	_, err := q.db.ExecContext(ctx, revokeSessionByHash, tokenHash)
+	return err
+}
+
+const revokeSessionForRefreshToken = `-- name: RevokeSessionForRefreshToken :exec
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE id = (SELECT session_id FROM refresh_tokens WHERE refresh_tokens.token_hash = $1) AND revoked_at IS NULL
+`
+
+// Logging out a refresh token ends the session it belongs to:
+func (q *Queries) RevokeSessionForRefreshToken(ctx context.Context, tokenHash string) error {
+	_, err := q.db.ExecContext(ctx, revokeSessionForRefreshToken, tokenHash)
+	return err
+}
+
+const touchSession = `-- name: TouchSession :exec
+UPDATE sessions
+SET last_seen_at = NOW()
+WHERE id = $1 AND last_seen_at < NOW() - INTERVAL '1 minute'
+`
+
+// Only writes when the stored time is more than a minute old, so browsing doesn't turn every
+// request into an UPDATE:
+func (q *Queries) TouchSession(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, touchSession, id)
+	return err
+}
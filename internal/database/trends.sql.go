@@ -0,0 +1,52 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: trends.sql
+
+package database
+
+import (
+	"context"
+)
+
+const getRecentChirpBodies = `-- name: GetRecentChirpBodies :many
+
+SELECT
+    body,
+    EXTRACT(EPOCH FROM (NOW() - created_at))::float8 AS age_seconds
+FROM chirps
+WHERE created_at >= NOW() - make_interval(secs => $1::float8)
+    AND deleted_at IS NULL
+    AND EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NULL)
+ORDER BY created_at DESC
+`
+
+type GetRecentChirpBodiesRow struct {
+	Body       string
+	AgeSeconds float64
+}
+
+// Ages are computed by Postgres against its own clock, so the trends aggregator never has to
+// reconcile the app server's time zone with the TIMESTAMP columns:
+func (q *Queries) GetRecentChirpBodies(ctx context.Context, maxAgeSeconds float64) ([]GetRecentChirpBodiesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getRecentChirpBodies, maxAgeSeconds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRecentChirpBodiesRow
+	for rows.Next() {
+		var i GetRecentChirpBodiesRow
+		if err := rows.Scan(&i.Body, &i.AgeSeconds); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,179 @@
+// Package export builds the ZIP archive a user gets when they ask for a copy of their data. The
+// archive holds machine-readable JSON files, the original media files, and an index.html that
+// presents the same data for humans.
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"html/template"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// An Archive is everything we hold about one user:
+type Archive struct {
+	GeneratedAt time.Time
+	Profile     Profile
+	Chirps      []Chirp
+	Media       []Media
+}
+
+type Profile struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Email       string    `json:"email"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+}
+
+type Chirp struct {
+	ID        uuid.UUID   `json:"id"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	Body      string      `json:"body"`
+	DeletedAt *time.Time  `json:"deleted_at,omitempty"`
+	Revisions []Revision  `json:"revisions,omitempty"`
+	MediaIDs  []uuid.UUID `json:"media_ids,omitempty"`
+}
+
+// A Revision is an earlier body of an edited chirp:
+type Revision struct {
+	CreatedAt time.Time `json:"created_at"`
+	Body      string    `json:"body"`
+}
+
+type Media struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ChirpID     *uuid.UUID `json:"chirp_id,omitempty"`
+	ContentType string     `json:"content_type"`
+	Size        int64      `json:"size"`
+	Width       int32      `json:"width"`
+	Height      int32      `json:"height"`
+	// File is the media file's path inside the archive; Write fills it in:
+	File string `json:"file"`
+}
+
+// An OpenFunc returns the original bytes of an uploaded image:
+type OpenFunc func(m Media) (io.ReadCloser, error)
+
+// Write streams the archive as a ZIP to w, reading each media file through open as it goes so
+// large exports never sit in memory all at once:
+func Write(w io.Writer, archive Archive, open OpenFunc) error {
+	zw := zip.NewWriter(w)
+
+	for i := range archive.Media {
+		m := &archive.Media[i]
+		m.File = "media/" + m.ID.String() + extension(m.ContentType)
+		if err := copyMedia(zw, *m, open); err != nil {
+			return err
+		}
+	}
+
+	if err := writeJSON(zw, "profile.json", archive.Profile); err != nil {
+		return err
+	}
+	if err := writeJSON(zw, "chirps.json", archive.Chirps); err != nil {
+		return err
+	}
+	if err := writeJSON(zw, "media.json", archive.Media); err != nil {
+		return err
+	}
+
+	f, err := zw.Create("index.html")
+	if err != nil {
+		return err
+	}
+	if err := indexTemplate.Execute(f, archive); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func copyMedia(zw *zip.Writer, m Media, open OpenFunc) error {
+	r, err := open(m)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// images are already compressed, so just store them:
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     m.File,
+		Method:   zip.Store,
+		Modified: m.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func writeJSON(zw *zip.Writer, name string, v any) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func extension(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	}
+	return ""
+}
+
+// html/template escapes everything we put into the page, so a chirp containing markup shows up as
+// text rather than running when the user opens their export:
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.UTC().Format("2 Jan 2006 15:04 MST") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Your Chirpy data</title>
+</head>
+<body>
+<h1>Your Chirpy data</h1>
+<p>Exported {{date .GeneratedAt}}. The same data is in profile.json, chirps.json and media.json.</p>
+
+<h2>Profile</h2>
+<dl>
+<dt>Handle</dt><dd>@{{.Profile.Handle}}</dd>
+<dt>Display name</dt><dd>{{.Profile.DisplayName}}</dd>
+<dt>Email</dt><dd>{{.Profile.Email}}</dd>
+<dt>Bio</dt><dd>{{.Profile.Bio}}</dd>
+<dt>Joined</dt><dd>{{date .Profile.CreatedAt}}</dd>
+</dl>
+
+<h2>Chirps ({{len .Chirps}})</h2>
+{{range .Chirps}}<article>
+<p>{{.Body}}</p>
+<p><small>{{date .CreatedAt}}{{if .DeletedAt}} (deleted {{date .DeletedAt}}){{end}}</small></p>
+{{if .Revisions}}<details><summary>Earlier versions</summary>
+<ul>{{range .Revisions}}<li>{{.Body}} <small>({{date .CreatedAt}})</small></li>{{end}}</ul>
+</details>{{end}}
+</article>
+{{else}}<p>No chirps.</p>
+{{end}}
+<h2>Media ({{len .Media}})</h2>
+{{range .Media}}<p><a href="{{.File}}"><img src="{{.File}}" alt="" width="160"></a></p>
+{{else}}<p>No media.</p>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,88 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestWrite(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	mediaID := uuid.New()
+	chirpID := uuid.New()
+	deletedAt := now.Add(time.Hour)
+	archive := Archive{
+		GeneratedAt: now,
+		Profile:     Profile{ID: uuid.New(), Email: "walt@example.com", Handle: "walt", CreatedAt: now},
+		Chirps: []Chirp{
+			{ID: chirpID, CreatedAt: now, Body: "<script>alert(1)</script>", MediaIDs: []uuid.UUID{mediaID},
+				Revisions: []Revision{{CreatedAt: now, Body: "first draft"}}},
+			{ID: uuid.New(), CreatedAt: now, Body: "gone", DeletedAt: &deletedAt},
+		},
+		Media: []Media{{ID: mediaID, CreatedAt: now, ChirpID: &chirpID, ContentType: "image/png", Size: 4}},
+	}
+	open := func(m Media) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("\x89PNG")), nil
+	}
+
+	buf := bytes.Buffer{}
+	if err := Write(&buf, archive, open); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("archive isn't a valid ZIP: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	mediaFile := "media/" + mediaID.String() + ".png"
+	for _, name := range []string{"profile.json", "chirps.json", "media.json", "index.html", mediaFile} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("archive is missing %s", name)
+		}
+	}
+	if files[mediaFile] != "\x89PNG" {
+		t.Errorf("%s = %q, want the original bytes", mediaFile, files[mediaFile])
+	}
+
+	var profile Profile
+	if err := json.Unmarshal([]byte(files["profile.json"]), &profile); err != nil || profile.Handle != "walt" {
+		t.Errorf("profile.json = %s, err = %v", files["profile.json"], err)
+	}
+	var chirps []Chirp
+	if err := json.Unmarshal([]byte(files["chirps.json"]), &chirps); err != nil || len(chirps) != 2 {
+		t.Fatalf("chirps.json = %s, err = %v", files["chirps.json"], err)
+	}
+	if len(chirps[0].Revisions) != 1 || chirps[1].DeletedAt == nil {
+		t.Errorf("chirps.json lost revisions or deleted_at: %s", files["chirps.json"])
+	}
+	var media []Media
+	if err := json.Unmarshal([]byte(files["media.json"]), &media); err != nil || len(media) != 1 || media[0].File != mediaFile {
+		t.Errorf("media.json = %s, err = %v", files["media.json"], err)
+	}
+
+	index := files["index.html"]
+	if strings.Contains(index, "<script>") {
+		t.Error("index.html contains an unescaped chirp body")
+	}
+	for _, want := range []string{"@walt", "&lt;script&gt;", "first draft", "(deleted ", mediaFile} {
+		if !strings.Contains(index, want) {
+			t.Errorf("index.html doesn't contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileDrop is for development: instead of sending anything it saves each message as a .eml file,
+// which most mail clients can open to preview exactly what would have been sent:
+type FileDrop struct {
+	dir  string
+	from string
+}
+
+// NewFileDrop creates dir if it doesn't exist yet:
+func NewFileDrop(dir, from string) (*FileDrop, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &FileDrop{dir: dir, from: from}, nil
+}
+
+func (f *FileDrop) Send(ctx context.Context, msg Message) error {
+	if _, err := parseAddress(msg.To); err != nil {
+		return err
+	}
+	now := time.Now()
+	data, err := encode(f.from, msg, now)
+	if err != nil {
+		return err
+	}
+	// timestamped names sort in the order the mail was sent; the random suffix keeps two messages
+	// in the same instant apart:
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := now.UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix) + ".eml"
+	return os.WriteFile(filepath.Join(f.dir, name), data, 0o640)
+}
+
+// parseAddress checks an address is well formed and returns just the addr-spec part, e.g.
+// "walt@example.com" from "Walt <walt@example.com>":
+func parseAddress(s string) (string, error) {
+	addr, err := mail.ParseAddress(s)
+	if err != nil {
+		return "", err
+	}
+	return addr.Address, nil
+}
@@ -0,0 +1,117 @@
+// Package mailer sends the app's transactional email (password resets, verification links and the
+// like). Handlers depend only on the Mailer interface; which transport sits behind it is decided at
+// startup.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+var ErrInvalidHeader = errors.New("email header contains a line break")
+
+// A Message is one email. Text is always sent; HTML is optional and, when set, the two are sent as
+// alternatives so mail clients pick whichever they can show:
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// A Mailer delivers messages:
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// encode renders msg as an RFC 5322 message ready to hand to an SMTP server or save as a .eml
+// file:
+func encode(from string, msg Message, now time.Time) ([]byte, error) {
+	// a newline in a header would let whoever controls the value add headers (or recipients) of
+	// their own:
+	for _, v := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+
+	buf := bytes.Buffer{}
+	header := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+	header("From", from)
+	header("To", msg.To)
+	// Q-encoding keeps non-ASCII subjects (emoji and all) intact:
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", messageID(from))
+	header("MIME-Version", "1.0")
+
+	if msg.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, msg.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	body := bytes.Buffer{}
+	mw := multipart.NewWriter(&body)
+	header("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+	// the preferred alternative goes last, so plain text comes first:
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+// quoted-printable keeps lines under SMTP's length limit and survives servers that aren't 8-bit
+// clean. Line endings become CRLF whatever they were in the template:
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	s = strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func messageID(from string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = strings.TrimSuffix(from[i+1:], ">")
+	}
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
@@ -0,0 +1,274 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readParts parses an encoded message and returns its decoded body parts keyed by media type:
+func readParts(t *testing.T, data []byte) (*mail.Message, map[string]string) {
+	t.Helper()
+	m, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("not a valid message: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]string{}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		body, _ := io.ReadAll(m.Body)
+		parts[mediaType] = string(body)
+		return m, parts
+	}
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		// NextPart undoes the quoted-printable encoding for us:
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(p)
+		parts[partType] = string(body)
+	}
+	return m, parts
+}
+
+func TestEncode(t *testing.T) {
+	long := strings.Repeat("chirp ", 30)
+	data, err := encode("Chirpy <no-reply@chirpy.test>", Message{
+		To:      "walt@example.com",
+		Subject: "Héllo 🐦",
+		Text:    "Plain " + long,
+		HTML:    "<p>Rich</p>",
+	}, time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+	for _, line := range strings.Split(string(data), "\r\n") {
+		if len(line) > 998 {
+			t.Fatalf("line longer than SMTP allows: %d bytes", len(line))
+		}
+	}
+
+	m, parts := readParts(t, data)
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil || subject != "Héllo 🐦" {
+		t.Errorf("Subject = %q, err = %v", subject, err)
+	}
+	if !strings.HasSuffix(m.Header.Get("Message-ID"), "@chirpy.test>") {
+		t.Errorf("Message-ID = %q", m.Header.Get("Message-ID"))
+	}
+	if parts["text/plain"] != "Plain "+long {
+		t.Errorf("text part = %q", parts["text/plain"])
+	}
+	if parts["text/html"] != "<p>Rich</p>" {
+		t.Errorf("html part = %q", parts["text/html"])
+	}
+}
+
+func TestEncodeRejectsHeaderInjection(t *testing.T) {
+	tests := []Message{
+		{To: "walt@example.com\r\nBcc: everyone@example.com", Subject: "hi"},
+		{To: "walt@example.com", Subject: "hi\nBcc: everyone@example.com"},
+	}
+	for _, msg := range tests {
+		if _, err := encode("no-reply@chirpy.test", msg, time.Now()); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("encode(%q) error = %v, want ErrInvalidHeader", msg, err)
+		}
+	}
+}
+
+func TestFileDrop(t *testing.T) {
+	dir := t.TempDir()
+	drop, err := NewFileDrop(dir, "no-reply@chirpy.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := drop.Send(context.Background(), Message{To: "walt@example.com", Subject: "hi", Text: "hello"}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 2 {
+		t.Fatalf("found %d .eml files, want 2", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	_, parts := readParts(t, data)
+	if parts["text/plain"] != "hello" {
+		t.Errorf("body = %q, want %q", parts["text/plain"], "hello")
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := &Memory{}
+	if err := m.Send(context.Background(), Message{To: "not an address"}); err == nil {
+		t.Error("Send() accepted an invalid address")
+	}
+	m.Send(context.Background(), Message{To: "walt@example.com", Subject: "hi"})
+	if got := m.Messages(); len(got) != 1 || got[0].Subject != "hi" {
+		t.Errorf("Messages() = %v", got)
+	}
+}
+
+func TestRender(t *testing.T) {
+	msg, err := Render("password_reset", "walt@example.com", map[string]string{
+		"Name":      "<b>Walt</b>",
+		"Link":      "https://chirpy.test/reset-password?token=abc",
+		"ExpiresIn": "hour",
+	})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if msg.Subject != "Reset your Chirpy password" {
+		t.Errorf("Subject = %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "Hi <b>Walt</b>,") || !strings.Contains(msg.Text, "token=abc") {
+		t.Errorf("Text = %q", msg.Text)
+	}
+	if strings.Contains(msg.HTML, "<b>Walt</b>") || !strings.Contains(msg.HTML, "&lt;b&gt;Walt&lt;/b&gt;") {
+		t.Errorf("HTML doesn't escape data: %q", msg.HTML)
+	}
+
+	if _, err := Render("no_such_email", "walt@example.com", nil); err == nil {
+		t.Error("Render() of an unknown template should fail")
+	}
+}
+
+// TestSMTP runs Send against a minimal SMTP server that offers no STARTTLS and requires no auth,
+// and checks the conversation and the delivered message:
+func TestSMTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	type result struct {
+		from, to string
+		data     []byte
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+		res := result{}
+		reply("220 fake ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250-fake")
+				reply("250 8BITMIME")
+			case strings.HasPrefix(line, "MAIL FROM:"):
+				res.from = line
+				reply("250 OK")
+			case strings.HasPrefix(line, "RCPT TO:"):
+				res.to = line
+				reply("250 OK")
+			case line == "DATA":
+				reply("354 go ahead")
+				buf := bytes.Buffer{}
+				for {
+					l, _ := r.ReadString('\n')
+					if l == ".\r\n" {
+						break
+					}
+					buf.WriteString(l)
+				}
+				res.data = buf.Bytes()
+				reply("250 queued")
+			case line == "QUIT":
+				reply("221 bye")
+				done <- res
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	s, err := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: port, From: "Chirpy <no-reply@chirpy.test>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Send(context.Background(), Message{To: "Walt <walt@example.com>", Subject: "hi", Text: "hello", HTML: "<p>hello</p>"})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	select {
+	case res := <-done:
+		// the envelope uses bare addresses, without display names:
+		if !strings.HasPrefix(res.from, "MAIL FROM:<no-reply@chirpy.test>") {
+			t.Errorf("server got %q", res.from)
+		}
+		if res.to != "RCPT TO:<walt@example.com>" {
+			t.Errorf("server got %q", res.to)
+		}
+		_, parts := readParts(t, res.data)
+		if parts["text/plain"] != "hello" || parts["text/html"] != "<p>hello</p>" {
+			t.Errorf("delivered parts = %v", parts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server never saw QUIT")
+	}
+}
+
+func TestSMTPRejectsInvalidRecipient(t *testing.T) {
+	s, err := NewSMTP(SMTPConfig{Host: "mail.example.com", From: "no-reply@chirpy.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing should ever be dialed for an invalid recipient:
+	if err := s.Send(context.Background(), Message{To: "not an address"}); err == nil {
+		t.Error("Send() accepted an invalid recipient")
+	}
+}
+
+// every email the app sends needs a subject and both bodies:
+func TestAllTemplatesRender(t *testing.T) {
+	for _, name := range []string{"password_reset", "email_verification", "account_locked"} {
+		msg, err := Render(name, "walt@example.com", map[string]string{
+			"Name":      "Walt",
+			"Link":      "https://chirpy.test/link",
+			"ExpiresIn": "hour",
+			"Duration":  "15m0s",
+		})
+		if err != nil {
+			t.Errorf("Render(%q) error = %v", name, err)
+			continue
+		}
+		if msg.Subject == "" || !strings.Contains(msg.Text, "https://chirpy.test/link") || !strings.Contains(msg.HTML, "https://chirpy.test/link") {
+			t.Errorf("Render(%q) = %+v", name, msg)
+		}
+	}
+}
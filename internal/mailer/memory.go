@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory keeps every message it's given, so tests can check what would have been emailed:
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *Memory) Send(ctx context.Context, msg Message) error {
+	if _, err := parseAddress(msg.To); err != nil {
+		return err
+	}
+	if _, err := encode("test@example.com", msg, time.Now()); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of everything sent so far, oldest first:
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
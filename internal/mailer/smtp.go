@@ -0,0 +1,108 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTPConfig describes a mail server to relay through:
+type SMTPConfig struct {
+	Host string
+	Port int
+	// Username and Password are optional; leave them empty for a relay that doesn't need auth:
+	Username string
+	Password string
+	// From is the sender address, e.g. "Chirpy <no-reply@example.com>":
+	From string
+}
+
+// SMTP sends mail through an SMTP server, upgrading the connection with STARTTLS whenever the server
+// offers it:
+type SMTP struct {
+	cfg  SMTPConfig
+	from string
+	// now is swappable so tests can fix the Date header:
+	now func() time.Time
+}
+
+func NewSMTP(cfg SMTPConfig) (*SMTP, error) {
+	if cfg.Host == "" || cfg.From == "" {
+		return nil, errors.New("SMTP host and from address are required")
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 587
+	}
+	// the envelope sender is the bare address, without the display name:
+	addr, err := parseAddress(cfg.From)
+	if err != nil {
+		return nil, err
+	}
+	return &SMTP{cfg: cfg, from: addr, now: time.Now}, nil
+}
+
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	to, err := parseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+	data, err := encode(s.cfg.From, msg, s.now())
+	if err != nil {
+		return err
+	}
+
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port)))
+	if err != nil {
+		return err
+	}
+	// a slow or stuck server shouldn't hang the caller forever:
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Minute)
+	}
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if s.cfg.Username != "" {
+		// PlainAuth refuses to send the password over an unencrypted connection (except to
+		// localhost), so a server that doesn't offer STARTTLS fails here rather than leaking it:
+		auth := smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
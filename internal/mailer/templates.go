@@ -0,0 +1,53 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Every email has a <name>.txt template, whose first {{define "subject"}} block is the subject line,
+// and usually a <name>.html one with the same content marked up:
+//
+//go:embed templates
+var templateFS embed.FS
+
+var (
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/*.txt"))
+	// html/template escapes the data it's given, so a display name like "<b>hi</b>" shows up as text
+	// rather than markup in the recipient's inbox:
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/*.html"))
+)
+
+// Render builds the message called name for the recipient to, filling the templates in with data:
+func Render(name, to string, data any) (Message, error) {
+	text := textTemplates.Lookup(name + ".txt")
+	if text == nil {
+		return Message{}, fmt.Errorf("no email template named %q", name)
+	}
+	subject := bytes.Buffer{}
+	if err := textTemplates.ExecuteTemplate(&subject, name+".subject", data); err != nil {
+		return Message{}, err
+	}
+	body := bytes.Buffer{}
+	if err := text.Execute(&body, data); err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(body.String()) + "\n",
+	}
+	if html := htmlTemplates.Lookup(name + ".html"); html != nil {
+		body.Reset()
+		if err := html.Execute(&body, data); err != nil {
+			return Message{}, err
+		}
+		msg.HTML = body.String()
+	}
+	return msg, nil
+}
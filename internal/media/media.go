@@ -0,0 +1,121 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif" // registers the GIF decoder with image.Decode
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type")
+	ErrTooManyPixels   = errors.New("image dimensions are too large")
+)
+
+const (
+	// ThumbnailSize is the longest edge of a generated thumbnail, in pixels:
+	ThumbnailSize = 320
+	// maxPixels stops "decompression bombs": tiny files that claim enormous dimensions and would
+	// eat all our memory when decoded:
+	maxPixels = 50_000_000
+)
+
+// An Image is an upload that passed validation, with its metadata removed and a thumbnail
+// generated:
+type Image struct {
+	ContentType          string
+	Data                 []byte
+	Width                int
+	Height               int
+	Thumbnail            []byte
+	ThumbnailContentType string
+}
+
+// Process validates an uploaded image by sniffing its magic bytes (never trusting the filename or
+// the client's Content-Type), strips metadata such as EXIF GPS coordinates, and renders a
+// thumbnail:
+func Process(data []byte) (Image, error) {
+	// http.DetectContentType looks only at the first 512 bytes and follows the WHATWG sniffing
+	// rules, so a PHP script named cat.jpg comes back as text/plain:
+	contentType := http.DetectContentType(data)
+
+	var stripped []byte
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		stripped, err = stripJPEG(data)
+	case "image/png":
+		stripped, err = stripPNG(data)
+	case "image/gif":
+		// GIF has no EXIF container, so there's nothing to remove:
+		stripped = data
+	default:
+		return Image{}, ErrUnsupportedType
+	}
+	if err != nil {
+		return Image{}, err
+	}
+
+	// read just the header first so we can refuse huge images before allocating pixels for them:
+	config, _, err := image.DecodeConfig(bytes.NewReader(stripped))
+	if err != nil {
+		return Image{}, err
+	}
+	if config.Width*config.Height > maxPixels {
+		return Image{}, ErrTooManyPixels
+	}
+	img, _, err := image.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		return Image{}, err
+	}
+
+	thumbnail, thumbnailContentType, err := makeThumbnail(img, contentType)
+	if err != nil {
+		return Image{}, err
+	}
+
+	return Image{
+		ContentType:          contentType,
+		Data:                 stripped,
+		Width:                config.Width,
+		Height:               config.Height,
+		Thumbnail:            thumbnail,
+		ThumbnailContentType: thumbnailContentType,
+	}, nil
+}
+
+// makeThumbnail scales img down to fit in a ThumbnailSize square, keeping its aspect ratio. Photos
+// become JPEGs; PNGs and GIFs become PNGs so transparency survives:
+func makeThumbnail(img image.Image, contentType string) ([]byte, string, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > ThumbnailSize || height > ThumbnailSize {
+		if width >= height {
+			height = max(1, height*ThumbnailSize/width)
+			width = ThumbnailSize
+		} else {
+			width = max(1, width*ThumbnailSize/height)
+			height = ThumbnailSize
+		}
+	}
+	thumb := image.NewRGBA(image.Rect(0, 0, width, height))
+	// CatmullRom is slower than nearest-neighbour but gives smooth results when shrinking:
+	draw.CatmullRom.Scale(thumb, thumb.Bounds(), img, bounds, draw.Over, nil)
+
+	buf := bytes.Buffer{}
+	if contentType == "image/jpeg" {
+		if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+	if err := png.Encode(&buf, thumb); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
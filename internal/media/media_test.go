@@ -0,0 +1,92 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	return img
+}
+
+func TestProcessStripsJPEGExif(t *testing.T) {
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, testImage(800, 400), nil); err != nil {
+		t.Fatal(err)
+	}
+	// splice an APP1 segment holding a fake EXIF payload in right after the SOI marker:
+	payload := []byte("Exif\x00\x00GPS 51.5N 0.12W")
+	app1 := append([]byte{0xFF, 0xE1, 0x00, byte(len(payload) + 2)}, payload...)
+	data := append(append([]byte{0xFF, 0xD8}, app1...), buf.Bytes()[2:]...)
+
+	got, err := Process(data)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if bytes.Contains(got.Data, []byte("Exif")) {
+		t.Error("Process() kept the EXIF segment")
+	}
+	if got.ContentType != "image/jpeg" || got.Width != 800 || got.Height != 400 {
+		t.Errorf("Process() = %s %dx%d, want image/jpeg 800x400", got.ContentType, got.Width, got.Height)
+	}
+	thumb, err := jpeg.DecodeConfig(bytes.NewReader(got.Thumbnail))
+	if err != nil {
+		t.Fatalf("thumbnail doesn't decode: %v", err)
+	}
+	if thumb.Width != ThumbnailSize || thumb.Height != ThumbnailSize/2 {
+		t.Errorf("thumbnail is %dx%d, want %dx%d", thumb.Width, thumb.Height, ThumbnailSize, ThumbnailSize/2)
+	}
+}
+
+func TestProcessStripsPNGText(t *testing.T) {
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, testImage(10, 10)); err != nil {
+		t.Fatal(err)
+	}
+	// insert a tEXt chunk just before the 12-byte IEND chunk (the CRC isn't checked for chunks we drop):
+	encoded := buf.Bytes()
+	text := []byte("Author\x00Someone")
+	chunk := append([]byte{0, 0, 0, byte(len(text))}, "tEXt"...)
+	chunk = append(append(chunk, text...), 0, 0, 0, 0)
+	data := append(append(append([]byte{}, encoded[:len(encoded)-12]...), chunk...), encoded[len(encoded)-12:]...)
+
+	got, err := Process(data)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if bytes.Contains(got.Data, []byte("Someone")) {
+		t.Error("Process() kept the tEXt chunk")
+	}
+	if got.ThumbnailContentType != "image/png" {
+		t.Errorf("thumbnail type = %s, want image/png", got.ThumbnailContentType)
+	}
+}
+
+func TestProcessRejectsNonImages(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "Script with an image name", data: []byte("<?php system($_GET['c']); ?>")},
+		{name: "HTML", data: []byte("<html><body>hi</body></html>")},
+		{name: "Empty", data: []byte{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Process(tt.data); !errors.Is(err, ErrUnsupportedType) {
+				t.Errorf("Process() error = %v, want ErrUnsupportedType", err)
+			}
+		})
+	}
+}
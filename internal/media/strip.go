@@ -0,0 +1,92 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errCorruptImage = errors.New("corrupt image data")
+
+// stripJPEG removes the segments that carry camera and editing metadata (EXIF/XMP in APP1,
+// Photoshop/IPTC in APP13, and comments) while copying the compressed image data byte-for-byte,
+// so there's no quality loss. APP0 (JFIF), APP2 (ICC colour profile) and APP14 (Adobe colour
+// transform) are kept because they change how the pixels are displayed:
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errCorruptImage
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	i := 2
+	for {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, errCorruptImage
+		}
+		marker := data[i+1]
+		// 0xFF bytes before a marker are padding:
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		// start of scan: everything from here on is entropy-coded image data, copy it as-is:
+		if marker == 0xDA {
+			out.Write(data[i:])
+			return out.Bytes(), nil
+		}
+		// the two bytes after a marker are the segment length, which includes themselves:
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errCorruptImage
+		}
+		// APP1 (EXIF/XMP), APP13 (Photoshop/IPTC) and COM (comment) are dropped:
+		if marker != 0xE1 && marker != 0xED && marker != 0xFE {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+}
+
+// pngSignature is the fixed 8-byte header every PNG file starts with:
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// PNG chunks that only carry metadata: EXIF, textual key/value pairs (which often hold software
+// names, authors or locations) and the last-modified time:
+var pngMetadataChunks = map[string]struct{}{
+	"eXIf": {},
+	"tEXt": {},
+	"zTXt": {},
+	"iTXt": {},
+	"tIME": {},
+}
+
+// stripPNG drops metadata chunks and keeps every other chunk untouched:
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errCorruptImage
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+	i := len(pngSignature)
+	for i < len(data) {
+		// each chunk is: 4-byte length, 4-byte type, data, 4-byte CRC:
+		if i+8 > len(data) {
+			return nil, errCorruptImage
+		}
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		chunkType := string(data[i+4 : i+8])
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errCorruptImage
+		}
+		if _, ok := pngMetadataChunks[chunkType]; !ok {
+			out.Write(data[i:end])
+		}
+		i = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,190 @@
+// Package oauth holds the protocol details of Chirpy's OAuth 2.0 authorization server (RFC 6749):
+// checking redirect URIs and PKCE verifiers, parsing scope strings, and the errors the spec
+// defines. Storage and HTTP handling live with the rest of the API.
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/scope"
+)
+
+// OAuth access and refresh tokens are opaque random strings with these prefixes, so they can't be
+// mistaken for each other, for a JWT or for an API key:
+const (
+	accessTokenPrefix  = "chirpy_oat_"
+	refreshTokenPrefix = "chirpy_ort_"
+)
+
+// NewAccessToken returns a new random access token:
+func NewAccessToken() (string, error) {
+	return newToken(accessTokenPrefix)
+}
+
+// NewRefreshToken returns a new random refresh token:
+func NewRefreshToken() (string, error) {
+	return newToken(refreshTokenPrefix)
+}
+
+// IsAccessToken reports whether a bearer token looks like one of ours, as opposed to a JWT from
+// logging in:
+func IsAccessToken(token string) bool {
+	return strings.HasPrefix(token, accessTokenPrefix)
+}
+
+func newToken(prefix string) (string, error) {
+	token, err := auth.MakeToken()
+	if err != nil {
+		return "", err
+	}
+	return prefix + token, nil
+}
+
+// An Error is one of the error responses from RFC 6749 section 5.2 (and 4.1.2.1 for the
+// authorization endpoint):
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// the error codes we use:
+const (
+	ErrInvalidRequest       = "invalid_request"
+	ErrInvalidClient        = "invalid_client"
+	ErrInvalidGrant         = "invalid_grant"
+	ErrInvalidScope         = "invalid_scope"
+	ErrUnsupportedGrantType = "unsupported_grant_type"
+	ErrUnsupportedResponse  = "unsupported_response_type"
+	ErrAccessDenied         = "access_denied"
+	ErrServerError          = "server_error"
+)
+
+// NewError makes an Error with a description for the developer reading it:
+func NewError(code, format string, args ...any) *Error {
+	return &Error{Code: code, Description: fmt.Sprintf(format, args...)}
+}
+
+// ValidateRedirectURI checks a URI a client wants to register. Codes are sent to it, so it has to
+// be absolute, have no fragment, and use https unless it's a loopback address for a native app
+// (RFC 8252 section 7.3):
+func ValidateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return errors.New("redirect URI must be an absolute URL")
+	}
+	if u.Fragment != "" || strings.Contains(uri, "#") {
+		return errors.New("redirect URI must not have a fragment")
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if u.Hostname() == "localhost" {
+			return nil
+		}
+		if ip := net.ParseIP(u.Hostname()); ip != nil && ip.IsLoopback() {
+			return nil
+		}
+		return errors.New("redirect URI must use https (http is only allowed for loopback addresses)")
+	default:
+		return errors.New("redirect URI must use https")
+	}
+}
+
+// ParseScope splits a space-separated scope parameter and checks every scope can be granted:
+func ParseScope(s string) ([]scope.Scope, error) {
+	scopes, err := scope.Parse(strings.Fields(s))
+	if err != nil {
+		return nil, NewError(ErrInvalidScope, "%s", err)
+	}
+	if len(scopes) == 0 {
+		return nil, NewError(ErrInvalidScope, "at least one scope is required")
+	}
+	return scopes, nil
+}
+
+// FormatScope is ParseScope in reverse:
+func FormatScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// ValidateCodeChallenge checks the PKCE parameters sent to the authorization endpoint. Only S256
+// is accepted; "plain" would let anyone who sees the authorization request redeem the code:
+func ValidateCodeChallenge(challenge, method string) error {
+	if challenge == "" {
+		return NewError(ErrInvalidRequest, "code_challenge is required")
+	}
+	if method != "S256" {
+		return NewError(ErrInvalidRequest, "code_challenge_method must be S256")
+	}
+	// a base64url-encoded SHA-256 digest is always 43 characters:
+	if b, err := base64.RawURLEncoding.DecodeString(challenge); err != nil || len(b) != sha256.Size {
+		return NewError(ErrInvalidRequest, "code_challenge must be a base64url-encoded SHA-256 digest")
+	}
+	return nil
+}
+
+// VerifyCodeVerifier checks the verifier sent to the token endpoint against the challenge the
+// authorization request was made with (RFC 7636 section 4.6):
+func VerifyCodeVerifier(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, c := range verifier {
+		if !isUnreserved(c) {
+			return false
+		}
+	}
+	return subtle.ConstantTimeCompare([]byte(CodeChallenge(verifier)), []byte(challenge)) == 1
+}
+
+// NewCodeVerifier makes a PKCE verifier for when we're the client ourselves (logging in with
+// another provider): 32 random bytes, base64url-encoded to 43 characters:
+func NewCodeVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge is the S256 challenge for a verifier:
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// the characters RFC 7636 allows in a code verifier:
+func isUnreserved(c rune) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// RedirectURL adds params to a registered redirect URI, keeping any query it already has:
+func RedirectURL(redirectURI string, params url.Values) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	q := u.Query()
+	for k, vs := range params {
+		for _, v := range vs {
+			q.Add(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
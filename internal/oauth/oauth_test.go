@@ -0,0 +1,106 @@
+package oauth
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestValidateRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri     string
+		wantErr bool
+	}{
+		{"https://app.example.com/callback", false},
+		{"https://app.example.com/callback?source=chirpy", false},
+		{"http://localhost:3000/callback", false},
+		{"http://127.0.0.1:8765/", false},
+		{"http://[::1]:8765/", false},
+		{"http://app.example.com/callback", true},
+		{"https://app.example.com/callback#frag", true},
+		{"/callback", true},
+		{"javascript:alert(1)", true},
+		{"ftp://example.com/", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			err := ValidateRedirectURI(tt.uri)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRedirectURI(%q) error = %v, wantErr %v", tt.uri, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// the example from RFC 7636 appendix B:
+const (
+	rfcVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	rfcChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestPKCE(t *testing.T) {
+	if err := ValidateCodeChallenge(rfcChallenge, "S256"); err != nil {
+		t.Errorf("ValidateCodeChallenge() = %v", err)
+	}
+	for _, tt := range []struct{ challenge, method string }{
+		{rfcChallenge, "plain"},
+		{rfcChallenge, ""},
+		{"", "S256"},
+		{"too-short", "S256"},
+	} {
+		var oauthErr *Error
+		if err := ValidateCodeChallenge(tt.challenge, tt.method); !errors.As(err, &oauthErr) || oauthErr.Code != ErrInvalidRequest {
+			t.Errorf("ValidateCodeChallenge(%q, %q) = %v, want invalid_request", tt.challenge, tt.method, err)
+		}
+	}
+
+	if got := CodeChallenge(rfcVerifier); got != rfcChallenge {
+		t.Errorf("CodeChallenge() = %q, want %q", got, rfcChallenge)
+	}
+	verifier, err := NewCodeVerifier()
+	if err != nil || !VerifyCodeVerifier(verifier, CodeChallenge(verifier)) {
+		t.Errorf("NewCodeVerifier() = %q, %v; doesn't verify against its own challenge", verifier, err)
+	}
+	if !VerifyCodeVerifier(rfcVerifier, rfcChallenge) {
+		t.Error("VerifyCodeVerifier() rejected the RFC 7636 example")
+	}
+	for _, verifier := range []string{
+		rfcVerifier[:42],
+		rfcVerifier + "x",
+		"dBjftJeZ4CVP+mB92K27uhbUJU1p1r/wW1gFWFOEjXk",
+	} {
+		if VerifyCodeVerifier(verifier, rfcChallenge) {
+			t.Errorf("VerifyCodeVerifier(%q) accepted a wrong verifier", verifier)
+		}
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	scopes, err := ParseScope("profile:read  chirps:write profile:read")
+	if err != nil || len(scopes) != 2 || scopes[0] != "chirps:write" || scopes[1] != "profile:read" {
+		t.Errorf("ParseScope() = %v, %v", scopes, err)
+	}
+	for _, s := range []string{"", "account", "chirps:write admin"} {
+		var oauthErr *Error
+		if _, err := ParseScope(s); !errors.As(err, &oauthErr) || oauthErr.Code != ErrInvalidScope {
+			t.Errorf("ParseScope(%q) = %v, want invalid_scope", s, err)
+		}
+	}
+}
+
+func TestRedirectURL(t *testing.T) {
+	got := RedirectURL("https://app.example.com/cb?source=chirpy", url.Values{"code": {"abc"}, "state": {"x y"}})
+	want := "https://app.example.com/cb?code=abc&source=chirpy&state=x+y"
+	if got != want {
+		t.Errorf("RedirectURL() = %q, want %q", got, want)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	access, _ := NewAccessToken()
+	refresh, _ := NewRefreshToken()
+	if !IsAccessToken(access) || IsAccessToken(refresh) || IsAccessToken("eyJhbGciOi.x.y") {
+		t.Errorf("IsAccessToken() doesn't tell %q and %q apart", access, refresh)
+	}
+}
@@ -0,0 +1,179 @@
+// Package oidc lets Chirpy sign users in with an external OpenID Connect provider (the "relying
+// party" side of OpenID Connect Core 1.0): it discovers the provider's endpoints, builds the
+// authorization URL, trades the code for tokens and checks the ID token's signature against the
+// provider's published keys.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/oauth"
+)
+
+// Config describes our registration with the provider:
+type Config struct {
+	// the provider's issuer URL, e.g. "https://accounts.google.com":
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// where the provider sends the user back to; it must be registered with the provider:
+	RedirectURL string
+	// defaults to openid, email and profile:
+	Scopes []string
+	// defaults to a client with a 10 second timeout:
+	HTTPClient *http.Client
+}
+
+// Provider is the part of the discovery document we use:
+type Provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// A Client talks to one provider. Discovery happens on first use rather than at startup, so a
+// provider that's briefly down doesn't stop Chirpy from starting:
+type Client struct {
+	cfg  Config
+	http *http.Client
+
+	mu       sync.Mutex
+	provider *Provider
+	keys     *keySet
+}
+
+// NewClient checks cfg and returns a client for it:
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("oidc: issuer, client ID and redirect URL are required")
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{cfg: cfg, http: httpClient}, nil
+}
+
+// Issuer is the provider's issuer URL, which together with an ID token's subject identifies a user:
+func (c *Client) Issuer() string {
+	return c.cfg.Issuer
+}
+
+// discover fetches the provider's discovery document once, and remembers it:
+func (c *Client) discover(ctx context.Context) (*Provider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.provider != nil {
+		return c.provider, nil
+	}
+
+	p := Provider{}
+	if err := c.getJSON(ctx, c.cfg.Issuer+"/.well-known/openid-configuration", &p); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	// the document has to be for the issuer we asked about, or a compromised or misconfigured
+	// server could send us to someone else's endpoints (OpenID Connect Discovery section 4.3):
+	if strings.TrimSuffix(p.Issuer, "/") != c.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q doesn't match %q", p.Issuer, c.cfg.Issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: document is missing an endpoint")
+	}
+	c.provider = &p
+	c.keys = newKeySet(p.JWKSURI, c.getJSON)
+	return c.provider, nil
+}
+
+// AuthCodeURL is where to send the user to log in. state ties the callback to this browser, nonce
+// ties the ID token to this login, and the PKCE verifier ties the code to us:
+func (c *Client) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	p, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauth.RedirectURL(p.AuthorizationEndpoint, url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.cfg.ClientID},
+		"redirect_uri":          {c.cfg.RedirectURL},
+		"scope":                 {strings.Join(c.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {oauth.CodeChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}), nil
+}
+
+// Exchange trades the code from the callback for tokens and returns the raw ID token, which still
+// has to go through VerifyIDToken:
+func (c *Client) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	p, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.cfg.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oidc token request: %w", err)
+	}
+	defer resp.Body.Close()
+	body := struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("oidc token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc token request: %s (%s: %s)", resp.Status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("oidc token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// getJSON fetches url and decodes it into v:
+func (c *Client) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,188 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/oauth"
+	"github.com/craigbucher/learn-http-servers/internal/oidc/oidctest"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testRedirectURL = "http://localhost:8080/api/login/oidc/callback"
+
+func newTestClient(t *testing.T) (*Client, *oidctest.Server) {
+	t.Helper()
+	server, err := oidctest.NewServer("chirpy", "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	client, err := NewClient(Config{
+		Issuer:       server.URL,
+		ClientID:     "chirpy",
+		ClientSecret: "s3cret",
+		RedirectURL:  testRedirectURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+// login runs the browser's half of the flow: visit the authorization URL and pick the code out of
+// the redirect back to us:
+func login(t *testing.T, client *Client, state, nonce, verifier string) string {
+	t.Helper()
+	authURL, err := client.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noFollow.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), testRedirectURL) {
+		t.Fatalf("authorization redirected to %q", resp.Header.Get("Location"))
+	}
+	if got := location.Query().Get("state"); got != state {
+		t.Fatalf("state = %q, want %q", got, state)
+	}
+	return location.Query().Get("code")
+}
+
+func TestLoginFlow(t *testing.T) {
+	client, server := newTestClient(t)
+	server.SetUser(oidctest.User{
+		Subject:       "user-123",
+		Email:         "walt@example.com",
+		EmailVerified: true,
+		Name:          "Walter White",
+	})
+	ctx := context.Background()
+
+	verifier, _ := oauth.NewCodeVerifier()
+	code := login(t, client, "state-1", "nonce-1", verifier)
+	rawIDToken, err := client.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatalf("Exchange() = %v", err)
+	}
+	idToken, err := client.VerifyIDToken(ctx, rawIDToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("VerifyIDToken() = %v", err)
+	}
+	want := IDToken{
+		Issuer:        server.URL,
+		Subject:       "user-123",
+		Email:         "walt@example.com",
+		EmailVerified: true,
+		Name:          "Walter White",
+	}
+	if idToken != want {
+		t.Errorf("VerifyIDToken() = %+v, want %+v", idToken, want)
+	}
+
+	// the code is single use, and useless without the right verifier:
+	if _, err := client.Exchange(ctx, code, verifier); err == nil {
+		t.Error("Exchange() accepted a used code")
+	}
+	code = login(t, client, "state-2", "nonce-2", verifier)
+	otherVerifier, _ := oauth.NewCodeVerifier()
+	if _, err := client.Exchange(ctx, code, otherVerifier); err == nil {
+		t.Error("Exchange() accepted the wrong code verifier")
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	client, server := newTestClient(t)
+	user := oidctest.User{Subject: "user-123", Email: "walt@example.com", EmailVerified: true}
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		nonce  string
+	}{
+		{"wrong nonce", func(jwt.MapClaims) {}, "other-nonce"},
+		{"no nonce expected", func(jwt.MapClaims) {}, ""},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, "n"},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "someone-else" }, "n"},
+		{"several audiences without azp", func(c jwt.MapClaims) { c["aud"] = []string{"chirpy", "other"} }, "n"},
+		{"azp for another client", func(c jwt.MapClaims) { c["azp"] = "other" }, "n"},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, "n"},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }, "n"},
+		{"issued in the future", func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() }, "n"},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }, "n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := server.Claims(user, "n")
+			tt.modify(claims)
+			raw, err := server.SignIDToken(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.VerifyIDToken(ctx, raw, tt.nonce); err == nil {
+				t.Error("VerifyIDToken() accepted the token")
+			}
+		})
+	}
+
+	t.Run("unsigned", func(t *testing.T) {
+		raw, _ := jwt.NewWithClaims(jwt.SigningMethodNone, server.Claims(user, "n")).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		if _, err := client.VerifyIDToken(ctx, raw, "n"); err == nil {
+			t.Error("VerifyIDToken() accepted an unsigned token")
+		}
+	})
+	t.Run("signed with the client secret", func(t *testing.T) {
+		raw, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, server.Claims(user, "n")).SignedString([]byte("s3cret"))
+		if _, err := client.VerifyIDToken(ctx, raw, "n"); err == nil {
+			t.Error("VerifyIDToken() accepted an HMAC-signed token")
+		}
+	})
+}
+
+// after the provider rotates its key, the new key is fetched the first time it's seen (but not
+// more than once a minute):
+func TestVerifyIDTokenKeyRotation(t *testing.T) {
+	client, server := newTestClient(t)
+	user := oidctest.User{Subject: "user-123"}
+	ctx := context.Background()
+
+	raw, _ := server.SignIDToken(server.Claims(user, "n"))
+	if _, err := client.VerifyIDToken(ctx, raw, "n"); err != nil {
+		t.Fatalf("VerifyIDToken() = %v", err)
+	}
+
+	if err := server.RotateKey(); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ = server.SignIDToken(server.Claims(user, "n"))
+	if _, err := client.VerifyIDToken(ctx, raw, "n"); err == nil {
+		t.Error("VerifyIDToken() refetched keys within a minute of the last fetch")
+	}
+	client.keys.fetchedAt = time.Now().Add(-2 * minKeyRefresh)
+	if _, err := client.VerifyIDToken(ctx, raw, "n"); err != nil {
+		t.Errorf("VerifyIDToken() after rotation = %v", err)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	_, server := newTestClient(t)
+	client, _ := NewClient(Config{
+		// the same server under a different name, so the document's issuer won't match:
+		Issuer:      strings.Replace(server.URL, "127.0.0.1", "localhost", 1),
+		ClientID:    "chirpy",
+		RedirectURL: testRedirectURL,
+	})
+	if _, err := client.AuthCodeURL(context.Background(), "s", "n", "v"); err == nil {
+		t.Error("AuthCodeURL() accepted a discovery document for another issuer")
+	}
+}
@@ -0,0 +1,269 @@
+// Package oidctest is a minimal OpenID Connect provider for tests and local development. It
+// implements discovery, a JWKS endpoint, an authorization endpoint that approves straight away as
+// whichever user it's been told to, and a token endpoint that checks PKCE and issues RS256-signed
+// ID tokens.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/oauth"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// User is who the provider says has logged in:
+type User struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// pendingCode is an authorization code waiting to be redeemed:
+type pendingCode struct {
+	user          User
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	expiresAt     time.Time
+}
+
+// Provider is the mock issuer. It's an http.Handler, to be served at Issuer:
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	mu    sync.Mutex
+	key   *rsa.PrivateKey
+	kid   string
+	user  *User
+	codes map[string]pendingCode
+	mux   *http.ServeMux
+}
+
+// New creates a provider for issuer that knows a single client:
+func New(issuer, clientID, clientSecret string) (*Provider, error) {
+	p := &Provider{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		codes:        map[string]pendingCode{},
+		mux:          http.NewServeMux(),
+	}
+	if err := p.RotateKey(); err != nil {
+		return nil, err
+	}
+	p.mux.HandleFunc("GET /.well-known/openid-configuration", p.handleDiscovery)
+	p.mux.HandleFunc("GET /jwks", p.handleJWKS)
+	p.mux.HandleFunc("GET /authorize", p.handleAuthorize)
+	p.mux.HandleFunc("POST /token", p.handleToken)
+	return p, nil
+}
+
+// Server is a Provider listening on a local port, for tests:
+type Server struct {
+	*Provider
+	*httptest.Server
+}
+
+// NewServer starts a provider on a random local port; call Close when you're done with it:
+func NewServer(clientID, clientSecret string) (*Server, error) {
+	s := &Server{}
+	s.Server = httptest.NewUnstartedServer(nil)
+	s.Server.Start()
+	p, err := New(s.Server.URL, clientID, clientSecret)
+	if err != nil {
+		s.Server.Close()
+		return nil, err
+	}
+	s.Provider = p
+	s.Server.Config.Handler = p
+	return s, nil
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+// SetUser decides who the next login is as. Until it's called, logins are denied:
+func (p *Provider) SetUser(u User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.user = &u
+}
+
+// RotateKey replaces the signing key; tokens signed with the old one no longer verify:
+func (p *Provider) RotateKey() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.key = key
+	p.kid = randomString(8)
+	return nil
+}
+
+// SignIDToken signs arbitrary claims with the current key, so tests can make tokens that are wrong
+// in specific ways:
+func (p *Provider) SignIDToken(claims jwt.MapClaims) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.kid
+	return token.SignedString(p.key)
+}
+
+// Claims are the claims a normal login's ID token has:
+func (p *Provider) Claims(u User, nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":                p.Issuer,
+		"sub":                u.Subject,
+		"aud":                p.ClientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              nonce,
+		"email":              u.Email,
+		"email_verified":     u.EmailVerified,
+		"name":               u.Name,
+		"preferred_username": u.PreferredUsername,
+	}
+}
+
+func (p *Provider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	pub := p.key.PublicKey
+	kid := p.kid
+	p.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// handleAuthorize skips the login page: it approves the request as the current user and sends the
+// browser straight back:
+func (p *Provider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI := q.Get("redirect_uri")
+	if q.Get("client_id") != p.ClientID || redirectURI == "" {
+		http.Error(w, "unknown client or missing redirect_uri", http.StatusBadRequest)
+		return
+	}
+	params := url.Values{"state": {q.Get("state")}}
+
+	p.mu.Lock()
+	user := p.user
+	p.mu.Unlock()
+	switch {
+	case q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "":
+		params.Set("error", "invalid_request")
+	case user == nil:
+		params.Set("error", "access_denied")
+	default:
+		code := randomString(16)
+		p.mu.Lock()
+		p.codes[code] = pendingCode{
+			user:          *user,
+			clientID:      p.ClientID,
+			redirectURI:   redirectURI,
+			codeChallenge: q.Get("code_challenge"),
+			nonce:         q.Get("nonce"),
+			expiresAt:     time.Now().Add(time.Minute),
+		}
+		p.mu.Unlock()
+		params.Set("code", code)
+	}
+	http.Redirect(w, r, oauth.RedirectURL(redirectURI, params), http.StatusFound)
+}
+
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, oauth.NewError(oauth.ErrInvalidRequest, "couldn't parse form"))
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID || secret != p.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, oauth.NewError(oauth.ErrInvalidClient, "client authentication failed"))
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, oauth.NewError(oauth.ErrUnsupportedGrantType, "only authorization_code is supported"))
+		return
+	}
+
+	p.mu.Lock()
+	code, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+	if !ok || time.Now().After(code.expiresAt) || code.redirectURI != r.PostForm.Get("redirect_uri") ||
+		!oauth.VerifyCodeVerifier(r.PostForm.Get("code_verifier"), code.codeChallenge) {
+		writeJSON(w, http.StatusBadRequest, oauth.NewError(oauth.ErrInvalidGrant, "bad code, redirect_uri or code_verifier"))
+		return
+	}
+
+	idToken, err := p.SignIDToken(p.Claims(code.user, code.nonce))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, oauth.NewError("server_error", "couldn't sign ID token"))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(16),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
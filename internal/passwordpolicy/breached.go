@@ -0,0 +1,77 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// BreachedList holds SHA-1 hashes of passwords known from data breaches, in the format of the
+// "Pwned Passwords" downloads: one uppercase hex hash per line, optionally followed by ":<count>".
+//
+// It's organised like that service's k-anonymity API, by the first five hex characters of each
+// hash. Range answers a query the way the API does (every suffix sharing a prefix), so a checker
+// only ever needs to reveal a prefix that hundreds of other passwords share; Contains uses it
+// locally.
+type BreachedList struct {
+	ranges map[string]map[string]bool
+}
+
+// LoadBreachedList reads a list from a file:
+func LoadBreachedList(path string) (*BreachedList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadBreachedList(f)
+}
+
+func ReadBreachedList(r io.Reader) (*BreachedList, error) {
+	list := &BreachedList{ranges: map[string]map[string]bool{}}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if hash == "" || strings.HasPrefix(hash, "#") {
+			continue
+		}
+		hash = strings.ToUpper(hash)
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+		prefix, suffix := hash[:5], hash[5:]
+		if list.ranges[prefix] == nil {
+			list.ranges[prefix] = map[string]bool{}
+		}
+		list.ranges[prefix][suffix] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Range returns the suffixes of every breached hash starting with prefix (five hex characters):
+func (b *BreachedList) Range(prefix string) []string {
+	suffixes := []string{}
+	for suffix := range b.ranges[strings.ToUpper(prefix)] {
+		suffixes = append(suffixes, suffix)
+	}
+	return suffixes
+}
+
+// Contains reports whether password appears in the list:
+func (b *BreachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	for _, suffix := range b.Range(hash[:5]) {
+		if suffix == hash[5:] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+// Package passwordpolicy decides whether a new password is acceptable, and explains why not in
+// terms the user can act on.
+package passwordpolicy
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Violation is one rule the password broke. Rule is a stable identifier for clients; Message is
+// written for the person choosing the password:
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// MinScore is the lowest acceptable Estimate score, from 0 to 4:
+	MinScore int
+	// Breached is optional; when set, passwords found in it are refused:
+	Breached *BreachedList
+}
+
+// DefaultPolicy follows NIST SP 800-63B: at least 8 characters, long passphrases allowed, no
+// composition rules (like "must contain a symbol"), but no known-weak or breached passwords:
+var DefaultPolicy = Policy{
+	MinLength: 8,
+	MaxLength: 256,
+	MinScore:  2,
+}
+
+// Check returns every rule password breaks (none means it's fine). email and the other user
+// inputs (handle, display name) are things an attacker targeting this account would try first:
+func (p Policy) Check(password, email string, userInputs ...string) []Violation {
+	violations := []Violation{}
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:    "min_length",
+			Message: fmt.Sprintf("Use at least %d characters.", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{
+			Rule:    "max_length",
+			Message: fmt.Sprintf("Use at most %d characters.", p.MaxLength),
+		})
+		// the other checks aren't meaningful (or cheap) for something this long:
+		return violations
+	}
+
+	lower := strings.ToLower(password)
+	localPart, _, _ := strings.Cut(strings.ToLower(email), "@")
+	if email != "" && (strings.Contains(lower, strings.ToLower(email)) || (len(localPart) >= 3 && strings.Contains(lower, localPart))) {
+		violations = append(violations, Violation{
+			Rule:    "contains_email",
+			Message: "Don't include your email address in your password.",
+		})
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		violations = append(violations, Violation{
+			Rule:    "breached",
+			Message: "This password has appeared in a data breach, so attackers will try it. Choose a different one.",
+		})
+	}
+
+	if length >= p.MinLength {
+		strength := Estimate(password, append([]string{email}, userInputs...)...)
+		if strength.Score < p.MinScore {
+			violations = append(violations, Violation{
+				Rule:    "too_weak",
+				Message: weakMessage(strength),
+			})
+		}
+	}
+	return violations
+}
+
+// weakMessage turns the patterns the estimator found into a suggestion:
+func weakMessage(s Strength) string {
+	msg := "This password would be easy to guess."
+	for _, pattern := range s.Patterns {
+		switch pattern {
+		case "common_password":
+			return msg + " It's one of the most common passwords (or close to one); add some less predictable words."
+		case "user_input":
+			return msg + " Avoid your name, handle or email."
+		case "keyboard":
+			return msg + " Keyboard patterns like \"qwerty\" are among the first things attackers try."
+		case "sequence":
+			return msg + " Sequences like \"abc\" or \"1234\" are easy to guess."
+		case "repeat":
+			return msg + " Repeated characters or words add little strength."
+		case "year":
+			return msg + " Years, especially recent ones or birth years, are easy to guess."
+		}
+	}
+	return msg + " Try a longer passphrase of a few unrelated words."
+}
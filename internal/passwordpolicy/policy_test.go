@@ -0,0 +1,122 @@
+package passwordpolicy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		password    string
+		wantMax     int
+		wantMin     int
+		wantPattern string
+	}{
+		{"password", 0, 0, "common_password"},
+		{"P@ssw0rd", 1, 0, "common_password"},
+		{"drowssap", 1, 0, "common_password"},
+		{"qwertyuiop", 0, 0, "common_password"},
+		{"zxcvfdsa", 2, 0, ""},
+		{"abcdefghij", 1, 0, "sequence"},
+		{"aaaaaaaaaaaa", 1, 0, "repeat"},
+		{"asdfasdfasdf", 1, 0, "repeat"},
+		{"sunshine1987", 2, 0, ""},
+		{"correct horse battery staple", 4, 4, ""},
+		{"Tr0ub4dour&3x!qZ", 4, 3, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			got := Estimate(tt.password)
+			if got.Score > tt.wantMax || got.Score < tt.wantMin {
+				t.Errorf("Estimate(%q).Score = %d (%.0f guesses, patterns %v), want %d-%d",
+					tt.password, got.Score, got.Guesses, got.Patterns, tt.wantMin, tt.wantMax)
+			}
+			if tt.wantPattern != "" && !slices.Contains(got.Patterns, tt.wantPattern) {
+				t.Errorf("Estimate(%q).Patterns = %v, want %q among them", tt.password, got.Patterns, tt.wantPattern)
+			}
+		})
+	}
+}
+
+func TestEstimateUsesUserInputs(t *testing.T) {
+	without := Estimate("waltwhite99")
+	with := Estimate("waltwhite99", "walt.white@example.com", "heisenberg")
+	if with.Guesses >= without.Guesses || !slices.Contains(with.Patterns, "user_input") {
+		t.Errorf("knowing the user's details should make the password weaker: %+v vs %+v", with, without)
+	}
+}
+
+func TestEstimateLongPasswordIsFast(t *testing.T) {
+	// this would take far too long without the length cap on matching:
+	Estimate(strings.Repeat("ab", 5000))
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestBreachedList(t *testing.T) {
+	file := "# a comment\n" + sha1Hex("correct horse battery staple") + ":42\n" + strings.ToLower(sha1Hex("hunter2")) + "\n"
+	list, err := ReadBreachedList(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !list.Contains("correct horse battery staple") || !list.Contains("hunter2") {
+		t.Error("Contains() missed a listed password")
+	}
+	if list.Contains("something else entirely") {
+		t.Error("Contains() matched an unlisted password")
+	}
+	hash := sha1Hex("hunter2")
+	if got := list.Range(hash[:5]); len(got) != 1 || got[0] != hash[5:] {
+		t.Errorf("Range(%s) = %v", hash[:5], got)
+	}
+
+	if _, err := ReadBreachedList(strings.NewReader("not-a-hash\n")); err == nil {
+		t.Error("ReadBreachedList() accepted a malformed line")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	breached, _ := ReadBreachedList(strings.NewReader(sha1Hex("correct horse battery staple") + "\n"))
+	policy := DefaultPolicy
+	policy.Breached = breached
+
+	tests := []struct {
+		name      string
+		password  string
+		wantRules []string
+	}{
+		{"empty", "", []string{"min_length"}},
+		{"short", "k9#Lq", []string{"min_length"}},
+		{"common", "password1", []string{"too_weak"}},
+		{"contains email", "walt@example.com rocks my socks", []string{"contains_email"}},
+		{"contains local part", "xQ7!walt.white!zP2", []string{"contains_email"}},
+		{"breached", "correct horse battery staple", []string{"breached"}},
+		{"too long", strings.Repeat("x", 300), []string{"max_length"}},
+		{"good", "mango tractor velvet puzzle", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := "walt@example.com"
+			if tt.name == "contains local part" {
+				email = "walt.white@example.com"
+			}
+			violations := policy.Check(tt.password, email)
+			rules := []string{}
+			for _, v := range violations {
+				if v.Message == "" {
+					t.Errorf("violation %q has no message", v.Rule)
+				}
+				rules = append(rules, v.Rule)
+			}
+			if !slices.Equal(rules, tt.wantRules) && !(len(rules) == 0 && tt.wantRules == nil) {
+				t.Errorf("Check(%q) rules = %v, want %v", tt.password, rules, tt.wantRules)
+			}
+		})
+	}
+}
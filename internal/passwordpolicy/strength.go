@@ -0,0 +1,294 @@
+package passwordpolicy
+
+import (
+	_ "embed"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// This is a cut-down version of the approach zxcvbn takes: instead of counting character classes,
+// find the patterns an attacker would try first (common passwords, the user's own details,
+// keyboard walks, sequences, repeats, years), work out how many guesses each would take, and score
+// the cheapest way to build the whole password out of them.
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// commonRanks maps each common password to its position in the list (1 = most common):
+var commonRanks = func() map[string]int {
+	ranks := map[string]int{}
+	for _, line := range strings.Split(commonPasswordsFile, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, ok := ranks[line]; !ok {
+			ranks[line] = len(ranks) + 1
+		}
+	}
+	return ranks
+}()
+
+var keyboardRows = []string{
+	"`1234567890-=",
+	"qwertyuiop[]\\",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+}
+
+// l33t substitutions, undone before looking words up:
+var unleet = strings.NewReplacer("4", "a", "@", "a", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t", "+", "t")
+
+// A match is a stretch of the password (runes i to j inclusive) that fits a pattern:
+type match struct {
+	i, j    int
+	guesses float64
+	pattern string
+}
+
+// Strength is the estimator's verdict: Score runs from 0 (guessed almost instantly) to 4 (very
+// hard to guess), and Patterns lists the weaknesses it found, for feedback:
+type Strength struct {
+	Score    int
+	Guesses  float64
+	Patterns []string
+}
+
+// Estimate works out how hard password is to guess. userInputs are things an attacker targeting
+// this user would know, like their email and handle, which are treated as the most common words of
+// all:
+func Estimate(password string, userInputs ...string) Strength {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return Strength{}
+	}
+	// like zxcvbn, only look at the start of very long passwords; the matching is quadratic, and
+	// anything this long is strong unless its start already gives it away:
+	if len(runes) > maxEstimateLength {
+		runes = runes[:maxEstimateLength]
+	}
+	return estimate(runes, userInputs, true)
+}
+
+const maxEstimateLength = 64
+
+// estimate does the work for Estimate. Repeats are scored by estimating the repeated chunk, which
+// is done with withRepeats off so the recursion stops there:
+func estimate(runes []rune, userInputs []string, withRepeats bool) Strength {
+	lower := []rune(strings.ToLower(string(runes)))
+
+	matches := dictionaryMatches(runes, lower, userInputs)
+	matches = append(matches, sequenceMatches(lower)...)
+	if withRepeats {
+		matches = append(matches, repeatMatches(runes, userInputs)...)
+	}
+	matches = append(matches, keyboardMatches(lower)...)
+	matches = append(matches, yearMatches(lower)...)
+
+	// best[k] is the fewest guesses needed for the first k runes; any rune not covered by a match
+	// costs a factor of bruteforceCardinality:
+	const bruteforceCardinality = 10
+	best := make([]float64, len(runes)+1)
+	via := make([]*match, len(runes)+1)
+	best[0] = 1
+	for k := 1; k <= len(runes); k++ {
+		best[k] = best[k-1] * bruteforceCardinality
+		via[k] = nil
+		for m := range matches {
+			if matches[m].j != k-1 {
+				continue
+			}
+			if g := best[matches[m].i] * matches[m].guesses; g < best[k] {
+				best[k] = g
+				via[k] = &matches[m]
+			}
+		}
+	}
+
+	// walk back through the winning decomposition to see which patterns it used:
+	seen := map[string]bool{}
+	patterns := []string{}
+	for k := len(runes); k > 0; {
+		if m := via[k]; m != nil {
+			if !seen[m.pattern] {
+				seen[m.pattern] = true
+				patterns = append(patterns, m.pattern)
+			}
+			k = m.i
+		} else {
+			k--
+		}
+	}
+
+	guesses := best[len(runes)]
+	return Strength{Score: score(guesses), Guesses: guesses, Patterns: patterns}
+}
+
+// score uses zxcvbn's thresholds: 10^3 guesses falls to any online attack, 10^10 survives an
+// offline one against a slow hash:
+func score(guesses float64) int {
+	switch l := math.Log10(guesses); {
+	case l < 3:
+		return 0
+	case l < 6:
+		return 1
+	case l < 8:
+		return 2
+	case l < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func dictionaryMatches(runes, lower []rune, userInputs []string) []match {
+	ranks := map[string]int{}
+	// the user's own details rank above every common password:
+	for _, input := range userInputs {
+		for _, word := range strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len([]rune(word)) >= 3 {
+				ranks[word] = 1
+			}
+		}
+	}
+
+	matches := []match{}
+	for i := range lower {
+		for j := i + 2; j < len(lower); j++ {
+			word := string(lower[i : j+1])
+			// each variation an attacker has to try roughly doubles the guesses:
+			variations := 1.0
+			if string(runes[i:j+1]) != word {
+				variations *= 2
+			}
+			candidates := []struct {
+				word     string
+				factor   float64
+				reversed bool
+			}{
+				{word, 1, false},
+				{unleet.Replace(word), 2, false},
+				{reverse(word), 2, true},
+			}
+			for _, c := range candidates {
+				if c.factor == 2 && c.word == word && !c.reversed {
+					continue
+				}
+				rank, ok := ranks[c.word]
+				pattern := "user_input"
+				if !ok {
+					rank, ok = commonRanks[c.word]
+					pattern = "common_password"
+				}
+				if ok {
+					matches = append(matches, match{i, j, float64(rank) * variations * c.factor, pattern})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// sequenceMatches finds runs like "abcd", "9876" or "mnop":
+func sequenceMatches(lower []rune) []match {
+	matches := []match{}
+	for i := 0; i < len(lower)-2; {
+		delta := lower[i+1] - lower[i]
+		j := i + 1
+		if delta == 1 || delta == -1 {
+			for j+1 < len(lower) && lower[j+1]-lower[j] == delta {
+				j++
+			}
+		}
+		if j-i >= 2 {
+			// starting somewhere obvious (a, 1, z, 9) is tried first:
+			base := 26.0
+			if unicode.IsDigit(lower[i]) {
+				base = 10
+			}
+			if strings.ContainsRune("a1z9", lower[i]) {
+				base = 4
+			}
+			matches = append(matches, match{i, j, base * float64(j-i+1), "sequence"})
+			i = j + 1
+		} else {
+			i++
+		}
+	}
+	return matches
+}
+
+// repeatMatches finds runs of one character ("aaaa") and repeated chunks ("abcabc"):
+func repeatMatches(runes []rune, userInputs []string) []match {
+	lower := []rune(strings.ToLower(string(runes)))
+	matches := []match{}
+	for i := range lower {
+		for size := 1; size <= (len(lower)-i)/2; size++ {
+			count := 1
+			for i+(count+1)*size <= len(lower) && string(lower[i+count*size:i+(count+1)*size]) == string(lower[i:i+size]) {
+				count++
+			}
+			if count < 2 || (size == 1 && count < 3) {
+				continue
+			}
+			// guessing the repeated chunk once, then how many times it repeats:
+			chunk := estimate(runes[i:i+size], userInputs, false).Guesses
+			matches = append(matches, match{i, i + count*size - 1, chunk * float64(count), "repeat"})
+		}
+	}
+	return matches
+}
+
+// keyboardMatches finds walks along a keyboard row like "qwerty" or "lkjh":
+func keyboardMatches(lower []rune) []match {
+	matches := []match{}
+	for i := 0; i < len(lower)-3; i++ {
+		for _, row := range keyboardRows {
+			j := i
+			for j+1 < len(lower) && adjacentInRow(row, lower[j], lower[j+1]) {
+				j++
+			}
+			if j-i >= 3 {
+				matches = append(matches, match{i, j, float64(len(keyboardRows)*len(row)) * float64(j-i+1), "keyboard"})
+			}
+		}
+	}
+	return matches
+}
+
+func adjacentInRow(row string, a, b rune) bool {
+	ia, ib := strings.IndexRune(row, a), strings.IndexRune(row, b)
+	return ia >= 0 && ib >= 0 && (ia-ib == 1 || ib-ia == 1)
+}
+
+// yearMatches finds years from 1900 to 2099, which people love to tack on the end:
+func yearMatches(lower []rune) []match {
+	matches := []match{}
+	for i := 0; i+4 <= len(lower); i++ {
+		s := string(lower[i : i+4])
+		if (strings.HasPrefix(s, "19") || strings.HasPrefix(s, "20")) && isDigits(s) {
+			matches = append(matches, match{i, i + 3, 200, "year"})
+		}
+	}
+	return matches
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
@@ -0,0 +1,78 @@
+// Package rbac decides what a user may do from the roles they've been given. Handlers ask for a
+// Permission rather than a Role, so changing what a role can do only means editing the table below.
+package rbac
+
+import (
+	"fmt"
+	"slices"
+)
+
+// A Role is a named bundle of permissions that can be granted to a user. Ordinary users have no
+// roles at all:
+type Role string
+
+const (
+	Admin     Role = "admin"
+	Moderator Role = "moderator"
+)
+
+// A Permission is one thing a handler can require:
+type Permission string
+
+const (
+	// see the hit counter at /admin/metrics:
+	ViewMetrics Permission = "metrics:read"
+	// wipe the database with /admin/reset (which also only works on the dev platform):
+	ResetData Permission = "data:reset"
+	// delete and restore other people's accounts:
+	ManageUsers Permission = "users:manage"
+	// delete and restore other people's chirps:
+	ModerateChirps Permission = "chirps:moderate"
+	// grant and revoke roles:
+	ManageRoles Permission = "roles:manage"
+	// act as another user for a while, to reproduce a problem they're having:
+	ImpersonateUsers Permission = "users:impersonate"
+	// read the audit log and check it hasn't been tampered with:
+	ViewAuditLog Permission = "audit:read"
+)
+
+// what each role is allowed to do:
+var rolePermissions = map[Role][]Permission{
+	Admin:     {ViewMetrics, ResetData, ManageUsers, ModerateChirps, ManageRoles, ImpersonateUsers, ViewAuditLog},
+	Moderator: {ViewMetrics, ModerateChirps},
+}
+
+// Roles lists every role that exists, in a stable order:
+func Roles() []Role {
+	return []Role{Admin, Moderator}
+}
+
+// ParseRole checks s is a role we know about:
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := rolePermissions[role]; !ok {
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+	return role, nil
+}
+
+// Has reports whether any of roles grants p. Unknown role names (say, from a token issued before a
+// role was removed) grant nothing:
+func Has(roles []string, p Permission) bool {
+	for _, role := range roles {
+		if slices.Contains(rolePermissions[Role(role)], p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions lists everything roles grant between them, sorted and without duplicates:
+func Permissions(roles []string) []Permission {
+	perms := []Permission{}
+	for _, role := range roles {
+		perms = append(perms, rolePermissions[Role(role)]...)
+	}
+	slices.Sort(perms)
+	return slices.Compact(perms)
+}
@@ -0,0 +1,60 @@
+package rbac
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestHas(t *testing.T) {
+	tests := []struct {
+		name  string
+		roles []string
+		perm  Permission
+		want  bool
+	}{
+		{"no roles", nil, ViewMetrics, false},
+		{"admin can reset", []string{"admin"}, ResetData, true},
+		{"moderator can moderate", []string{"moderator"}, ModerateChirps, true},
+		{"moderator can't manage users", []string{"moderator"}, ManageUsers, false},
+		{"moderator can't grant roles", []string{"moderator"}, ManageRoles, false},
+		{"moderator can't impersonate", []string{"moderator"}, ImpersonateUsers, false},
+		{"admin can impersonate", []string{"admin"}, ImpersonateUsers, true},
+		{"moderator can't read the audit log", []string{"moderator"}, ViewAuditLog, false},
+		{"admin can read the audit log", []string{"admin"}, ViewAuditLog, true},
+		{"any role is enough", []string{"moderator", "admin"}, ManageRoles, true},
+		{"unknown role grants nothing", []string{"superuser"}, ViewMetrics, false},
+		{"role names are exact", []string{"Admin"}, ViewMetrics, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Has(tt.roles, tt.perm); got != tt.want {
+				t.Errorf("Has(%v, %q) = %v, want %v", tt.roles, tt.perm, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for _, role := range Roles() {
+		if got, err := ParseRole(string(role)); err != nil || got != role {
+			t.Errorf("ParseRole(%q) = %q, %v", role, got, err)
+		}
+	}
+	for _, s := range []string{"", "root", "ADMIN"} {
+		if _, err := ParseRole(s); err == nil {
+			t.Errorf("ParseRole(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	got := Permissions([]string{"moderator", "admin", "nonsense"})
+	want := []Permission{ModerateChirps, ViewMetrics, ResetData, ManageRoles, ManageUsers, ImpersonateUsers, ViewAuditLog}
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("Permissions() = %v, want %v", got, want)
+	}
+	if got := Permissions(nil); len(got) != 0 {
+		t.Errorf("Permissions(nil) = %v, want none", got)
+	}
+}
@@ -0,0 +1,77 @@
+// Package scope limits what a credential that isn't a full login (an API key, say) may be used
+// for. Access tokens from logging in can do anything the user can; everything else carries a list
+// of scopes and each handler asks for the one it needs.
+package scope
+
+import (
+	"fmt"
+	"slices"
+)
+
+// A Scope names one kind of access, like posting chirps:
+type Scope string
+
+const (
+	// post, edit and delete your chirps:
+	ChirpsWrite Scope = "chirps:write"
+	// upload images:
+	MediaWrite Scope = "media:write"
+	// read your own account details:
+	ProfileRead Scope = "profile:read"
+	// change your handle, display name, bio and avatar (not your email, which needs Account):
+	ProfileWrite Scope = "profile:write"
+
+	// Account covers everything that manages the account itself: passwords, two-factor auth,
+	// exports, deleting it and creating more credentials. It can't be granted, so only a real login
+	// ever has it:
+	Account Scope = "account"
+)
+
+// what each grantable scope lets a credential do, in words a user can agree to:
+var descriptions = map[Scope]string{
+	ChirpsWrite:  "Post, edit and delete chirps as you",
+	MediaWrite:   "Upload images as you",
+	ProfileRead:  "See your account details, including your email address",
+	ProfileWrite: "Change your handle, display name, bio and avatar",
+}
+
+// Describe explains s for a consent page:
+func Describe(s Scope) string {
+	if d, ok := descriptions[s]; ok {
+		return d
+	}
+	return string(s)
+}
+
+// Grantable lists the scopes a credential can be given, in a stable order:
+func Grantable() []Scope {
+	return []Scope{ChirpsWrite, MediaWrite, ProfileRead, ProfileWrite}
+}
+
+// Parse checks each name is a grantable scope and returns them sorted, without duplicates:
+func Parse(names []string) ([]Scope, error) {
+	scopes := make([]Scope, 0, len(names))
+	for _, name := range names {
+		s := Scope(name)
+		if !slices.Contains(Grantable(), s) {
+			return nil, fmt.Errorf("unknown scope %q", name)
+		}
+		scopes = append(scopes, s)
+	}
+	slices.Sort(scopes)
+	return slices.Compact(scopes), nil
+}
+
+// Contains reports whether the granted scopes include s:
+func Contains(granted []string, s Scope) bool {
+	return slices.Contains(granted, string(s))
+}
+
+// Strings converts scopes for storing in the database:
+func Strings(scopes []Scope) []string {
+	out := make([]string, len(scopes))
+	for i, s := range scopes {
+		out[i] = string(s)
+	}
+	return out
+}
@@ -0,0 +1,50 @@
+package scope
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		want    []Scope
+		wantErr bool
+	}{
+		{"empty", []string{}, []Scope{}, false},
+		{"sorted and deduplicated", []string{"profile:read", "chirps:write", "profile:read"}, []Scope{ChirpsWrite, ProfileRead}, false},
+		{"unknown", []string{"chirps:write", "chirps:destroy"}, nil, true},
+		{"account can't be granted", []string{"account"}, nil, true},
+		{"case matters", []string{"Chirps:Write"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%v) error = %v, wantErr %v", tt.names, err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("Parse(%v) = %v, want %v", tt.names, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContains(t *testing.T) {
+	granted := Strings([]Scope{ChirpsWrite, ProfileRead})
+	if !Contains(granted, ChirpsWrite) || !Contains(granted, ProfileRead) {
+		t.Errorf("Contains(%v) missed a granted scope", granted)
+	}
+	if Contains(granted, MediaWrite) || Contains(granted, Account) {
+		t.Errorf("Contains(%v) allowed a scope that wasn't granted", granted)
+	}
+}
+
+func TestEveryGrantableScopeIsDescribed(t *testing.T) {
+	for _, s := range Grantable() {
+		if Describe(s) == string(s) {
+			t.Errorf("Describe(%q) has no description", s)
+		}
+	}
+}
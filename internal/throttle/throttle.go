@@ -0,0 +1,45 @@
+// Package throttle decides how long to make someone wait after repeated failures, such as wrong
+// passwords, growing the wait exponentially until it becomes a temporary lockout.
+package throttle
+
+import "time"
+
+// A Policy describes how patient we are. The first FreeAttempts failures cost nothing; after that
+// each one doubles the wait, starting at BaseDelay and capped at MaxDelay, and reaching
+// LockoutThreshold failures locks the key out for LockoutDuration:
+type Policy struct {
+	FreeAttempts     int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+// Delay is how long to block further attempts after the given number of consecutive failures:
+func (p Policy) Delay(failures int) time.Duration {
+	if p.Locked(failures) {
+		return p.LockoutDuration
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// Locked reports whether this many failures means a lockout rather than a back-off:
+func (p Policy) Locked(failures int) bool {
+	return p.LockoutThreshold > 0 && failures >= p.LockoutThreshold
+}
+
+// JustLocked reports whether this failure is the one that triggered the lockout, i.e. the moment to
+// tell the account owner about it (once, rather than on every attempt after):
+func (p Policy) JustLocked(failures int) bool {
+	return p.LockoutThreshold > 0 && failures == p.LockoutThreshold
+}
@@ -0,0 +1,49 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         10 * time.Second,
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+	}
+	tests := []struct {
+		failures   int
+		want       time.Duration
+		wantLocked bool
+	}{
+		{0, 0, false},
+		{3, 0, false},
+		{4, time.Second, false},
+		{5, 2 * time.Second, false},
+		{7, 8 * time.Second, false},
+		{8, 10 * time.Second, false},
+		{9, 10 * time.Second, false},
+		{10, 15 * time.Minute, true},
+		{25, 15 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		if got := p.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+		if got := p.Locked(tt.failures); got != tt.wantLocked {
+			t.Errorf("Locked(%d) = %v, want %v", tt.failures, got, tt.wantLocked)
+		}
+	}
+	if !p.JustLocked(10) || p.JustLocked(11) || p.JustLocked(9) {
+		t.Error("JustLocked() should only be true on the failure that hits the threshold")
+	}
+}
+
+func TestPolicyWithoutLockout(t *testing.T) {
+	p := Policy{BaseDelay: time.Second, MaxDelay: time.Minute}
+	if p.Locked(1000) || p.Delay(1000) != time.Minute {
+		t.Errorf("a policy without a threshold should only ever back off, got %v", p.Delay(1000))
+	}
+}
@@ -0,0 +1,200 @@
+package trends
+
+import (
+	"context"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// A Window is a rolling period we count hashtags over. Baseline is the stretch of time right
+// before the window that we compare against, so a tag is "trending" when it's used more than its
+// own recent history predicts, not just when it's popular:
+type Window struct {
+	Name     string
+	Length   time.Duration
+	Baseline time.Duration
+}
+
+// DefaultWindows compares the last hour against the day before it, and the last day against the
+// week before it:
+var DefaultWindows = []Window{
+	{Name: "1h", Length: time.Hour, Baseline: 24 * time.Hour},
+	{Name: "24h", Length: 24 * time.Hour, Baseline: 7 * 24 * time.Hour},
+}
+
+// A Post is the only thing the aggregator needs to know about a chirp: its text and how long ago
+// it was created:
+type Post struct {
+	Body string
+	Age  time.Duration
+}
+
+// Source loads every post younger than maxAge:
+type Source func(ctx context.Context, maxAge time.Duration) ([]Post, error)
+
+// A Trend is one hashtag's standing in a window. Expected is how many uses the baseline predicts
+// for a window of this length; Score is how far Count rises above that:
+type Trend struct {
+	Tag      string
+	Count    int
+	Expected float64
+	Score    float64
+}
+
+// A Snapshot is a complete, immutable set of results that handlers can read without locking:
+type Snapshot struct {
+	GeneratedAt time.Time
+	Windows     map[string][]Trend
+}
+
+// hashtags start with '#' at the beginning of the text or after a character that can't be part of
+// a word (so "a#b" and "&#39;" aren't tags):
+var hashtagRegexp = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&])#([\p{L}\p{N}_]+)`)
+
+const maxHashtagLength = 50
+
+// ExtractHashtags returns the distinct, lowercased hashtags in body, in order of first use. Tags
+// with no letters ("#1") are ignored:
+func ExtractHashtags(body string) []string {
+	tags := []string{}
+	seen := map[string]struct{}{}
+	for _, match := range hashtagRegexp.FindAllStringSubmatch(body, -1) {
+		tag := strings.ToLower(match[1])
+		if len(tag) > maxHashtagLength || !strings.ContainsFunc(tag, unicode.IsLetter) {
+			continue
+		}
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// Compute counts hashtags in every window and ranks them by growth over their baseline. Tags used
+// fewer than minCount times in a window are dropped and at most limit tags are kept per window:
+func Compute(posts []Post, windows []Window, minCount, limit int) map[string][]Trend {
+	results := map[string][]Trend{}
+	for _, window := range windows {
+		current := map[string]int{}
+		baseline := map[string]int{}
+		for _, post := range posts {
+			var counts map[string]int
+			switch {
+			case post.Age < window.Length:
+				counts = current
+			case post.Age < window.Length+window.Baseline:
+				counts = baseline
+			default:
+				continue
+			}
+			for _, tag := range ExtractHashtags(post.Body) {
+				counts[tag]++
+			}
+		}
+
+		// scale the baseline count down to "uses per window length":
+		ratio := window.Length.Seconds() / window.Baseline.Seconds()
+		trends := []Trend{}
+		for tag, count := range current {
+			if count < minCount {
+				continue
+			}
+			expected := float64(baseline[tag]) * ratio
+			trends = append(trends, Trend{
+				Tag:      tag,
+				Count:    count,
+				Expected: expected,
+				Score:    score(count, expected),
+			})
+		}
+		// highest score first; ties go to the more used tag, then alphabetical so output is stable:
+		sort.Slice(trends, func(i, j int) bool {
+			if trends[i].Score != trends[j].Score {
+				return trends[i].Score > trends[j].Score
+			}
+			if trends[i].Count != trends[j].Count {
+				return trends[i].Count > trends[j].Count
+			}
+			return trends[i].Tag < trends[j].Tag
+		})
+		if len(trends) > limit {
+			trends = trends[:limit]
+		}
+		results[window.Name] = trends
+	}
+	return results
+}
+
+// score treats tag usage as a Poisson process and measures how many standard deviations the
+// observed count sits above what the baseline predicts. The +1 keeps brand-new tags (expected 0)
+// from scoring infinitely high off a single use:
+func score(count int, expected float64) float64 {
+	return (float64(count) - expected) / math.Sqrt(expected+1)
+}
+
+// An Aggregator periodically recomputes trends from a Source and keeps the latest Snapshot in
+// memory:
+type Aggregator struct {
+	source   Source
+	windows  []Window
+	MinCount int
+	Limit    int
+	snapshot atomic.Pointer[Snapshot]
+}
+
+func NewAggregator(source Source, windows []Window) *Aggregator {
+	return &Aggregator{
+		source:   source,
+		windows:  windows,
+		MinCount: 2,
+		Limit:    10,
+	}
+}
+
+// Snapshot returns the most recent results, or nil if Refresh hasn't succeeded yet:
+func (a *Aggregator) Snapshot() *Snapshot {
+	return a.snapshot.Load()
+}
+
+// Refresh loads posts old enough to cover the longest window plus its baseline and replaces the
+// current snapshot:
+func (a *Aggregator) Refresh(ctx context.Context) error {
+	var maxAge time.Duration
+	for _, window := range a.windows {
+		maxAge = max(maxAge, window.Length+window.Baseline)
+	}
+	posts, err := a.source(ctx, maxAge)
+	if err != nil {
+		return err
+	}
+	a.snapshot.Store(&Snapshot{
+		GeneratedAt: time.Now().UTC(),
+		Windows:     Compute(posts, a.windows, a.MinCount, a.Limit),
+	})
+	return nil
+}
+
+// Run refreshes immediately and then every interval until ctx is cancelled. Failed refreshes are
+// logged and the previous snapshot keeps being served:
+func (a *Aggregator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := a.Refresh(ctx); err != nil {
+			log.Printf("Error refreshing trends: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
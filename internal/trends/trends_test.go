@@ -0,0 +1,78 @@
+package trends
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExtractHashtags(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			name: "Single tag",
+			body: "Learning #Go today",
+			want: []string{"go"},
+		},
+		{
+			name: "Duplicates counted once",
+			body: "#go #GO #golang",
+			want: []string{"go", "golang"},
+		},
+		{
+			name: "Not a tag inside a word or entity",
+			body: "email me at a#b or &#39;",
+			want: []string{},
+		},
+		{
+			name: "Numbers only is not a tag",
+			body: "We're #1 at #2024goals",
+			want: []string{"2024goals"},
+		},
+		{
+			name: "Unicode letters",
+			body: "¡#Café!",
+			want: []string{"café"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExtractHashtags(tt.body)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractHashtags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeRanksRisingOverPopular(t *testing.T) {
+	window := Window{Name: "1h", Length: time.Hour, Baseline: 24 * time.Hour}
+	posts := []Post{}
+	// #popular is used 5 times every hour, including this one:
+	for hour := 0; hour < 25; hour++ {
+		for i := 0; i < 5; i++ {
+			posts = append(posts, Post{Body: "#popular", Age: time.Duration(hour)*time.Hour + time.Minute})
+		}
+	}
+	// #rising has never been used before, and 4 times in the last hour:
+	for i := 0; i < 4; i++ {
+		posts = append(posts, Post{Body: "#rising", Age: time.Minute})
+	}
+	// #rare only shows up once, below the minimum count:
+	posts = append(posts, Post{Body: "#rare", Age: time.Minute})
+
+	got := Compute(posts, []Window{window}, 2, 10)["1h"]
+	if len(got) != 2 {
+		t.Fatalf("Compute() returned %d trends, want 2: %v", len(got), got)
+	}
+	if got[0].Tag != "rising" || got[1].Tag != "popular" {
+		t.Errorf("Compute() order = [%s %s], want [rising popular]", got[0].Tag, got[1].Tag)
+	}
+	if got[1].Count != 5 || got[1].Expected != 5 {
+		t.Errorf("popular count/expected = %d/%v, want 5/5", got[1].Count, got[1].Expected)
+	}
+}
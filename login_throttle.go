@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/mailer"
+	"github.com/craigbucher/learn-http-servers/internal/throttle"
+)
+
+var (
+	// a few typos are free, then each wrong password doubles the wait, and ten in a row locks the
+	// account for a quarter of an hour:
+	accountLoginPolicy = throttle.Policy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         5 * time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+	}
+	// an IP address is given more room, since many people can share one (offices, phone networks),
+	// but one address guessing at lots of accounts still gets shut out:
+	ipLoginPolicy = throttle.Policy{
+		FreeAttempts:     20,
+		BaseDelay:        time.Second,
+		MaxDelay:         5 * time.Minute,
+		LockoutThreshold: 100,
+		LockoutDuration:  time.Hour,
+	}
+)
+
+// failures older than this are forgotten:
+const loginFailureWindow = time.Hour
+
+func accountLoginKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipLoginKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// clientIP is the address the request came from. We don't trust X-Forwarded-For, since any client
+// can set it; behind a reverse proxy this is the proxy's address:
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginAttempt is a login attempt that has already been counted against the email and the IP:
+type loginAttempt struct {
+	email string
+	// how many attempts the email has made lately, this one included:
+	accountFailures int
+}
+
+// checkLoginAllowed counts an attempt for the email and the client's IP, or responds with 429 and
+// returns false if either is currently blocked:
+func (cfg *apiConfig) checkLoginAllowed(w http.ResponseWriter, r *http.Request, email string) (loginAttempt, bool) {
+	attempt, retryAfter, err := cfg.beginLoginAttempt(r, email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log in", err)
+		return loginAttempt{}, false
+	}
+	if retryAfter == 0 {
+		return attempt, true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts, try again later", nil)
+	return loginAttempt{}, false
+}
+
+// beginLoginAttempt counts an attempt against both the email and the IP *before* the password (or
+// 2FA code) is checked, and blocks them for as long as their policies say, so that many guesses sent
+// at once can't all be checked before the first one is counted. A successful attempt is taken back
+// again (see forgiveLoginAttempt and clearLoginFailures). If either is already blocked nothing is
+// counted, and it returns how much longer the block lasts. It's for login forms that report the
+// block their own way; JSON endpoints use checkLoginAllowed:
+func (cfg *apiConfig) beginLoginAttempt(r *http.Request, email string) (loginAttempt, time.Duration, error) {
+	attempt := loginAttempt{email: email}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		return attempt, 0, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	// always the account before the IP, so two attempts never wait on each other's rows:
+	for _, k := range []struct {
+		key    string
+		policy throttle.Policy
+	}{
+		{accountLoginKey(email), accountLoginPolicy},
+		{ipLoginKey(r), ipLoginPolicy},
+	} {
+		failures, err := qtx.RecordLoginAttempt(r.Context(), database.RecordLoginAttemptParams{
+			Key:           k.key,
+			WindowSeconds: loginFailureWindow.Seconds(),
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			tx.Rollback()
+			retryAfter, err := cfg.loginBlock(r, email)
+			// the block may have run out in the meantime; the client can simply try again:
+			return attempt, max(retryAfter, time.Second), err
+		}
+		if err != nil {
+			return attempt, 0, err
+		}
+		if k.policy == accountLoginPolicy {
+			attempt.accountFailures = int(failures)
+		}
+		if delay := k.policy.Delay(int(failures)); delay > 0 {
+			err = qtx.BlockLogin(r.Context(), database.BlockLoginParams{
+				Key:          k.key,
+				BlockSeconds: delay.Seconds(),
+			})
+			if err != nil {
+				return attempt, 0, err
+			}
+		}
+	}
+	return attempt, 0, tx.Commit()
+}
+
+// loginBlock is how much longer the email or the client's IP is blocked for, or 0 if neither is:
+func (cfg *apiConfig) loginBlock(r *http.Request, email string) (time.Duration, error) {
+	retryAfter, err := cfg.db.GetLoginBlock(r.Context(), []string{accountLoginKey(email), ipLoginKey(r)})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	// round up, so a block with a fraction of a second left doesn't look like no block:
+	return max(time.Duration(retryAfter*float64(time.Second)), time.Nanosecond), nil
+}
+
+// recordLoginFailure notes a wrong password (or 2FA code) in the audit log; the attempt itself was
+// already counted by beginLoginAttempt. user is nil when the email isn't registered; when it is, the
+// owner is emailed the moment their account gets locked.
+//
+// The email typed in is whatever the client sent, so it's only kept as a hash: that's enough to
+// tell whether attempts were aimed at a particular address, without anyone being able to write
+// arbitrary text into the audit log:
+func (cfg *apiConfig) recordLoginFailure(r *http.Request, attempt loginAttempt, user *database.User) {
+	event := auditEvent{
+		Action:  auditLoginFailed,
+		Details: map[string]any{"email_sha256": auditEmailHash(attempt.email)},
+	}
+	if user != nil {
+		event.Target = auditTarget("user", user.ID)
+	}
+	cfg.queueAudit(r, event)
+
+	if user != nil && accountLoginPolicy.JustLocked(attempt.accountFailures) {
+		cfg.sendLockoutNotice(*user, accountLoginPolicy.LockoutDuration)
+	}
+}
+
+// forgiveLoginAttempt takes back the IP's count for a login step that succeeded. Taking back just
+// the one attempt means logging in to an account of your own can't wipe out failures against others:
+func (cfg *apiConfig) forgiveLoginAttempt(ctx context.Context, r *http.Request) {
+	if err := cfg.db.ForgiveLoginAttempt(ctx, ipLoginKey(r)); err != nil {
+		log.Printf("Error forgiving login attempt: %s", err)
+	}
+}
+
+// clearLoginFailures forgets an account's failures after a successful login. The IP's count is
+// only ever taken back one attempt at a time (see forgiveLoginAttempt):
+func (cfg *apiConfig) clearLoginFailures(ctx context.Context, email string) {
+	if err := cfg.db.ClearLoginFailures(ctx, accountLoginKey(email)); err != nil {
+		log.Printf("Error clearing login failures: %s", err)
+	}
+}
+
+// sendLockoutNotice tells the account owner someone has been guessing their password:
+func (cfg *apiConfig) sendLockoutNotice(user database.User, lockout time.Duration) {
+	go func() {
+		msg, err := mailer.Render("account_locked", user.Email, map[string]string{
+			"Name":     displayNameOrHandle(user),
+			"Duration": lockout.String(),
+			"Link":     cfg.publicURL + "/forgot-password",
+		})
+		if err == nil {
+			err = cfg.mailer.Send(context.Background(), msg)
+		}
+		if err != nil {
+			log.Printf("Error sending lockout notice to user %s: %s", user.ID, err)
+		}
+	}()
+}
+
+// cleanupLoginFailures deletes counters nobody has added to for a while:
+func (cfg *apiConfig) cleanupLoginFailures(ctx context.Context) error {
+	_, err := cfg.db.DeleteStaleLoginFailures(ctx, loginFailureWindow.Seconds())
+	return err
+}
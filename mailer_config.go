@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/craigbucher/learn-http-servers/internal/mailer"
+)
+
+// newMailerFromEnv picks how email goes out based on MAILER:
+//   - "file" (the default): each message is saved as a .eml file under MAIL_DIR, for development
+//   - "smtp": relayed through SMTP_HOST, configured with the SMTP_* variables
+//
+// MAIL_FROM sets the sender for both.
+func newMailerFromEnv() (mailer.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Chirpy <no-reply@localhost>"
+	}
+
+	switch driver := os.Getenv("MAILER"); driver {
+	case "", "file":
+		mailDir := os.Getenv("MAIL_DIR")
+		if mailDir == "" {
+			mailDir = "mail"
+		}
+		return mailer.NewFileDrop(mailDir, from)
+	case "smtp":
+		// SMTP_PORT defaults to 587, the submission port that expects STARTTLS:
+		port := 0
+		if s := os.Getenv("SMTP_PORT"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SMTP_PORT %q", s)
+			}
+			port = n
+		}
+		return mailer.NewSMTP(mailer.SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		})
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q (want \"file\" or \"smtp\")", driver)
+	}
+}
@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"database/sql"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/blobstore"
 	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/craigbucher/learn-http-servers/internal/mailer"
+	"github.com/craigbucher/learn-http-servers/internal/oidc"
+	"github.com/craigbucher/learn-http-servers/internal/passwordpolicy"
+	"github.com/craigbucher/learn-http-servers/internal/rbac"
+	"github.com/craigbucher/learn-http-servers/internal/trends"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq" // The underscore tells Go that you're importing it for its side effects, not because you need to use it
 )
@@ -19,8 +30,45 @@ type apiConfig struct {
 	fileserverHits atomic.Int32
 	// create a new *database.Queries, and store it in your apiConfig struct so that handlers can access it:
 	db *database.Queries
+	// the raw connection pool, needed to start transactions (cfg.db.WithTx):
+	dbConn *sql.DB
 	// 
 	platform       string
+	// holds the latest trending-hashtags snapshot, refreshed in the background:
+	trends         *trends.Aggregator
+	// where uploaded media and thumbnails are stored, and the largest upload we accept:
+	blobs          blobstore.BlobStore
+	maxUploadBytes int64
+	// the keys MFA challenges, CSRF tokens and export links are signed with, each derived from
+	// SERVER_SECRET:
+	mfaSecret    string
+	csrfSecret   string
+	exportSecret string
+	// the key the audit log's hash chain is made with, and the events waiting to be written to it
+	// (see queueAudit):
+	auditKey   []byte
+	auditQueue chan auditEvent
+	// the keys access tokens are signed with (JWT_SECRET, plus any from JWT_KEYS_FILE):
+	signingKeys *auth.KeyRing
+	// how long after posting a chirp its author may still edit it:
+	chirpEditWindow time.Duration
+	// tombstoned users and chirps are hard-deleted once they've been deleted for this long:
+	purgeRetention time.Duration
+	// how long a self-deleted account can still be recovered, and what happens to its chirps:
+	accountDeletionGrace time.Duration
+	accountDeletionChirps string
+	// how long a finished data export stays downloadable:
+	dataExportRetention time.Duration
+	// sends password reset links and other email:
+	mailer mailer.Mailer
+	// where the app is reachable from a browser, for building links in emails:
+	publicURL string
+	// whether users must verify their email address before they can post chirps:
+	requireVerifiedEmail bool
+	// the rules new passwords have to follow:
+	passwordPolicy passwordpolicy.Policy
+	// signs users in with an external OpenID Connect provider; nil when that isn't set up:
+	oidc *oidc.Client
 }
 
 func main() {
@@ -43,6 +91,162 @@ func main() {
 		fmt.Printf("Platform is %s\n", platform)
 	}
 
+	// JWT_SECRET signs access tokens; anyone who knows it can log in as any user, so keep it out of 
+	// the repo (generate one with: openssl rand -base64 64):
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		log.Fatal("JWT_SECRET must be set")
+	}
+	// SERVER_SECRET signs what the server only ever checks itself (MFA challenges, CSRF tokens,
+	// export links), so JWT_SECRET can be rotated, or replaced by JWT_KEYS_FILE, without logging out
+	// every cookie session. Each use gets its own key derived from it:
+	serverSecret := os.Getenv("SERVER_SECRET")
+	if serverSecret == "" {
+		log.Println("SERVER_SECRET isn't set, so JWT_SECRET is used instead; set it before rotating JWT_SECRET")
+		serverSecret = jwtSecret
+	}
+	// AUDIT_LOG_KEY keys the audit log's hash chain. Old entries are only ever checked with the key
+	// they were written with, so it's kept apart from SERVER_SECRET and never rotated; without it,
+	// one is derived from SERVER_SECRET, which then can't be rotated either. Deriving it from
+	// JWT_SECRET would mean rotating that broke verification of the whole log, so one of the two
+	// must be set:
+	auditKey := os.Getenv("AUDIT_LOG_KEY")
+	if auditKey == "" {
+		if os.Getenv("SERVER_SECRET") == "" {
+			log.Fatal("AUDIT_LOG_KEY or SERVER_SECRET must be set")
+		}
+		log.Println("AUDIT_LOG_KEY isn't set, so it's derived from SERVER_SECRET; rotating SERVER_SECRET will break audit log verification")
+		auditKey = auth.DeriveSecret(serverSecret, "audit-log")
+	}
+
+	// CHIRP_EDIT_WINDOW is optional (e.g. "15m"); authors can edit a chirp for this long after posting:
+	chirpEditWindow := time.Hour
+	if s := os.Getenv("CHIRP_EDIT_WINDOW"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid CHIRP_EDIT_WINDOW: %q", s)
+		}
+		chirpEditWindow = d
+	}
+
+	// PURGE_RETENTION is optional (e.g. "168h"); deleted users and chirps can be restored for this 
+	// long before the purge job removes them for good:
+	purgeRetention := 30 * 24 * time.Hour
+	if s := os.Getenv("PURGE_RETENTION"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid PURGE_RETENTION: %q", s)
+		}
+		purgeRetention = d
+	}
+
+	// ACCOUNT_DELETION_GRACE is optional (e.g. "72h"); users who delete their account can change 
+	// their mind by logging back in for this long:
+	accountDeletionGrace := 14 * 24 * time.Hour
+	if s := os.Getenv("ACCOUNT_DELETION_GRACE"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid ACCOUNT_DELETION_GRACE: %q", s)
+		}
+		accountDeletionGrace = d
+	}
+	// ACCOUNT_DELETION_CHIRPS is "anonymize" (the default: chirps stay up, credited to a deleted user) 
+	// or "delete" (chirps go with the account):
+	accountDeletionChirps := chirpPolicyAnonymize
+	if s := os.Getenv("ACCOUNT_DELETION_CHIRPS"); s != "" {
+		if s != chirpPolicyAnonymize && s != chirpPolicyDelete {
+			log.Fatalf("Invalid ACCOUNT_DELETION_CHIRPS: %q", s)
+		}
+		accountDeletionChirps = s
+	}
+
+	// DATA_EXPORT_RETENTION is optional (e.g. "48h"); a finished data export can be downloaded for 
+	// this long before the archive is deleted:
+	dataExportRetention := 7 * 24 * time.Hour
+	if s := os.Getenv("DATA_EXPORT_RETENTION"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid DATA_EXPORT_RETENTION: %q", s)
+		}
+		dataExportRetention = d
+	}
+
+	// PUBLIC_URL is optional; it's the base of links we email out (e.g. https://chirpy.example.com):
+	publicURL := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	if publicURL == "" {
+		publicURL = "http://localhost:8080"
+	}
+
+	// TRENDS_REFRESH_INTERVAL is optional (e.g. "30s", "5m"); trends are recomputed this often:
+	trendsInterval := time.Minute
+	if s := os.Getenv("TRENDS_REFRESH_INTERVAL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid TRENDS_REFRESH_INTERVAL: %q", s)
+		}
+		trendsInterval = d
+	}
+
+	// BLOB_STORE chooses where uploaded images are kept (see blobstore_config.go); MEDIA_MAX_BYTES 
+	// caps a single upload:
+	blobs, err := newBlobStoreFromEnv()
+	if err != nil {
+		log.Fatalf("Error creating blob store: %s", err)
+	}
+	maxUploadBytes := int64(5 << 20)
+	if s := os.Getenv("MEDIA_MAX_BYTES"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid MEDIA_MAX_BYTES: %q", s)
+		}
+		maxUploadBytes = n
+	}
+
+	// REQUIRE_VERIFIED_EMAIL is optional; set it to "true" to stop users chirping until they've 
+	// clicked the link in their verification email:
+	requireVerifiedEmail := false
+	if s := os.Getenv("REQUIRE_VERIFIED_EMAIL"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Fatalf("Invalid REQUIRE_VERIFIED_EMAIL: %q", s)
+		}
+		requireVerifiedEmail = b
+	}
+
+	// PASSWORD_HASH and friends choose how passwords are hashed (see password_config.go):
+	hashConfig, err := hashConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Error reading password hash settings: %s", err)
+	}
+	if err := auth.SetHashConfig(hashConfig); err != nil {
+		log.Fatalf("Invalid password hash settings: %s", err)
+	}
+
+	// new passwords are checked against these rules (see password_config.go):
+	passwordPolicy, err := passwordPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Error reading password policy: %s", err)
+	}
+
+	// OIDC_ISSUER and friends turn on sign-in with an external provider (see oidc_config.go):
+	oidcClient, err := newOIDCClientFromEnv(publicURL)
+	if err != nil {
+		log.Fatalf("Error reading OpenID Connect settings: %s", err)
+	}
+
+	// JWT_KEYS_FILE optionally adds asymmetric keys to sign access tokens with (see
+	// signing_keys_config.go):
+	signingKeys, err := signingKeysFromEnv(jwtSecret)
+	if err != nil {
+		log.Fatalf("Error loading JWT signing keys: %s", err)
+	}
+
+	// MAILER chooses how email is sent (see mailer_config.go):
+	mail, err := newMailerFromEnv()
+	if err != nil {
+		log.Fatalf("Error creating mailer: %s", err)
+	}
+
 	// Next, sql.Open() a connection to your database:
 	// "postgres" is the name of the driver to use; available from _ "github.com/lib/pq"
 	// dbURL is the Postgres connection string
@@ -69,8 +273,53 @@ func main() {
 		fileserverHits: atomic.Int32{},
 		// assigns dbQueries (the database connection) to the db field so handlers can run queries:
 		db:             dbQueries,
+		dbConn:         dbConn,
 		platform:       platform,
+		blobs:          blobs,
+		maxUploadBytes: maxUploadBytes,
+		mfaSecret:      auth.DeriveSecret(serverSecret, "mfa-challenge"),
+		csrfSecret:     auth.DeriveSecret(serverSecret, "csrf"),
+		exportSecret:   auth.DeriveSecret(serverSecret, "data-export"),
+		auditKey:       []byte(auditKey),
+		auditQueue:     make(chan auditEvent, auditQueueSize),
+		signingKeys:    signingKeys,
+		chirpEditWindow: chirpEditWindow,
+		purgeRetention: purgeRetention,
+		accountDeletionGrace: accountDeletionGrace,
+		accountDeletionChirps: accountDeletionChirps,
+		dataExportRetention: dataExportRetention,
+		mailer:         mail,
+		publicURL:      publicURL,
+		requireVerifiedEmail: requireVerifiedEmail,
+		passwordPolicy: passwordPolicy,
+		oidc:           oidcClient,
 	}
+	// ADMIN_EMAILS is optional: a comma-separated list of accounts to make admins while there are
+	// none, which is how the first admin gets in (after that, admins grant roles through
+	// /admin/users/{userID}/roles):
+	if s := os.Getenv("ADMIN_EMAILS"); s != "" {
+		apiCfg.grantStartupAdmins(context.Background(), s)
+	}
+	// the aggregator reads chirps through apiCfg, so it's created once apiCfg exists, then started 
+	// in its own goroutine so it never blocks the server:
+	apiCfg.trends = trends.NewAggregator(apiCfg.recentChirpsForTrends, trends.DefaultWindows)
+	go apiCfg.trends.Run(context.Background(), trendsInterval)
+	// login events are written to the audit log in the background:
+	go apiCfg.runAuditQueue(context.Background())
+	// look for expired tombstones once an hour:
+	go runPeriodically(context.Background(), time.Hour, "purging deleted content", apiCfg.purgeDeleted)
+	// and carry out account deletions whose grace period is over:
+	go runPeriodically(context.Background(), time.Hour, "deleting accounts", apiCfg.processAccountDeletions)
+	// forget old failed login counters:
+	go runPeriodically(context.Background(), time.Hour, "cleaning up login failures", apiCfg.cleanupLoginFailures)
+	// and OAuth codes and tokens that are long dead:
+	go runPeriodically(context.Background(), time.Hour, "cleaning up OAuth tokens", apiCfg.cleanupOAuth)
+	// and external logins that were abandoned halfway:
+	go runPeriodically(context.Background(), time.Hour, "cleaning up OpenID Connect logins", apiCfg.cleanupOIDCLoginStates)
+	// and browser sessions that have expired or been logged out:
+	go runPeriodically(context.Background(), time.Hour, "cleaning up sessions", apiCfg.cleanupSessions)
+	// data exports are checked for often, since a user is waiting on them:
+	go runPeriodically(context.Background(), 30*time.Second, "building data exports", apiCfg.processDataExports)
 
 	// Create a new http.ServeMux:
 	mux := http.NewServeMux()
@@ -98,30 +347,89 @@ func main() {
 	// mux.Handle("/", http.FileServer(http.Dir(filepathRoot)))
 
 	mux.HandleFunc("POST /api/users", apiCfg.handlerUsersCreate)
+	mux.HandleFunc("GET /api/users/me", apiCfg.handlerUsersGetMe)
+	mux.HandleFunc("PATCH /api/users/me", apiCfg.handlerUsersUpdateMe)
+	mux.HandleFunc("DELETE /api/users/me", apiCfg.handlerUsersDeleteMe)
+	mux.HandleFunc("POST /api/users/verify", apiCfg.handlerUsersVerify)
+	mux.HandleFunc("POST /api/users/verify/resend", apiCfg.handlerUsersVerifyResend)
+	mux.HandleFunc("POST /api/users/me/totp", apiCfg.handlerTOTPEnroll)
+	mux.HandleFunc("GET /api/users/me/totp/qr.png", apiCfg.handlerTOTPQRCode)
+	mux.HandleFunc("POST /api/users/me/totp/confirm", apiCfg.handlerTOTPConfirm)
+	mux.HandleFunc("POST /api/users/me/export", apiCfg.handlerUsersExportCreate)
+	mux.HandleFunc("GET /api/users/me/export/{exportID}", apiCfg.handlerUsersExportGet)
+	mux.HandleFunc("GET /api/users/me/export/{exportID}/download", apiCfg.handlerUsersExportDownload)
+	mux.HandleFunc("GET /api/users/me/api-keys", apiCfg.handlerAPIKeysList)
+	mux.HandleFunc("POST /api/users/me/api-keys", apiCfg.handlerAPIKeysCreate)
+	mux.HandleFunc("DELETE /api/users/me/api-keys/{keyID}", apiCfg.handlerAPIKeysRevoke)
+	mux.HandleFunc("GET /api/users/{handle}", apiCfg.handlerUsersGetProfile)
+	mux.HandleFunc("POST /api/oauth/clients", apiCfg.handlerOAuthClientsCreate)
+	mux.HandleFunc("GET /api/oauth/clients", apiCfg.handlerOAuthClientsList)
+	mux.HandleFunc("DELETE /api/oauth/clients/{clientID}", apiCfg.handlerOAuthClientsDelete)
 	// Add a POST /api/chirps handler:
 	mux.HandleFunc("POST /api/chirps", apiCfg.handlerChirpsCreate)
 	mux.HandleFunc("GET /api/chirps", apiCfg.handlerChirpsRetrieve)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerChirpsGet)
+	mux.HandleFunc("PATCH /api/chirps/{chirpID}", apiCfg.handlerChirpsUpdate)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/revisions", apiCfg.handlerChirpsRevisions)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerChirpsDelete)
+	mux.HandleFunc("GET /api/search/chirps", apiCfg.handlerChirpsSearch)
+	mux.HandleFunc("GET /api/trends", apiCfg.handlerTrendsGet)
+	mux.HandleFunc("POST /api/media", apiCfg.handlerMediaCreate)
+	mux.HandleFunc("GET /api/media/{mediaID}", apiCfg.handlerMediaGet)
+	mux.HandleFunc("GET /api/media/{mediaID}/thumbnail", apiCfg.handlerMediaThumbnailGet)
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/mfa", apiCfg.handlerLoginMFA)
+	mux.HandleFunc("GET /api/login/oidc", apiCfg.handlerLoginOIDC)
+	mux.HandleFunc("GET /api/login/oidc/callback", apiCfg.handlerLoginOIDCCallback)
+	mux.HandleFunc("POST /api/logout", apiCfg.handlerLogout)
+	mux.HandleFunc("GET /api/sessions", apiCfg.handlerSessionsList)
+	mux.HandleFunc("DELETE /api/sessions", apiCfg.handlerSessionsDeleteOthers)
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", apiCfg.handlerSessionsDelete)
+	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
+	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
+	mux.HandleFunc("POST /api/password/forgot", apiCfg.handlerPasswordForgot)
+	mux.HandleFunc("POST /api/password/reset", apiCfg.handlerPasswordReset)
+
+	// the OAuth 2.0 authorization server, for third-party apps acting on users' behalf:
+	mux.HandleFunc("GET /.well-known/oauth-authorization-server", apiCfg.handlerOAuthMetadata)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
+	mux.HandleFunc("GET /oauth/authorize", apiCfg.handlerOAuthAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", apiCfg.handlerOAuthAuthorizeSubmit)
+	mux.HandleFunc("POST /oauth/token", apiCfg.handlerOAuthToken)
+	mux.HandleFunc("POST /oauth/introspect", apiCfg.handlerOAuthIntrospect)
+	mux.HandleFunc("POST /oauth/revoke", apiCfg.handlerOAuthRevoke)
 
 	// Register the handlerMetrics handler with the serve mux on the /metrics path:
 	// Update the following paths to only accept GET requests:
 		// prepend /api to the beginning of each of our API endpoints:
 	// Swap out the GET /api/metrics endpoint, which just returns plain text, for a GET /admin/metrics 
 	// that returns HTML:
-	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
+	// every /admin route needs a role that grants the permission (see internal/rbac):
+	mux.Handle("GET /admin/metrics", apiCfg.middlewareRequirePermission(rbac.ViewMetrics, apiCfg.handlerMetrics))
 	// create and register a handler on the /reset path that, when hit, will reset your fileserverHits 
 	// back to 0:
 	// Update the /reset endpoint to only accept POST requests:
 		// prepend /api to the beginning of each of our API endpoints:
 		// Update the POST /api/reset to POST /admin/reset:
 	// Update the POST /admin/reset endpoint to delete all users in the database (but don't mess with the schema)
-	mux.HandleFunc("POST /admin/reset", apiCfg.handlerReset)
+	mux.Handle("POST /admin/reset", apiCfg.middlewareRequirePermission(rbac.ResetData, apiCfg.handlerReset))
+	mux.Handle("DELETE /admin/users/{userID}", apiCfg.middlewareRequirePermission(rbac.ManageUsers, apiCfg.handlerAdminUsersDelete))
+	mux.Handle("POST /admin/users/{userID}/restore", apiCfg.middlewareRequirePermission(rbac.ManageUsers, apiCfg.handlerAdminUsersRestore))
+	mux.Handle("POST /admin/chirps/{chirpID}/restore", apiCfg.middlewareRequirePermission(rbac.ModerateChirps, apiCfg.handlerAdminChirpsRestore))
+	mux.Handle("GET /admin/users/{userID}/roles", apiCfg.middlewareRequirePermission(rbac.ManageRoles, apiCfg.handlerAdminRolesGet))
+	mux.Handle("PUT /admin/users/{userID}/roles/{role}", apiCfg.middlewareRequirePermission(rbac.ManageRoles, apiCfg.handlerAdminRolesGrant))
+	mux.Handle("DELETE /admin/users/{userID}/roles/{role}", apiCfg.middlewareRequirePermission(rbac.ManageRoles, apiCfg.handlerAdminRolesRevoke))
+	mux.Handle("POST /admin/users/{userID}/impersonate", apiCfg.middlewareRequirePermission(rbac.ImpersonateUsers, apiCfg.handlerAdminImpersonationStart))
+	mux.Handle("DELETE /admin/impersonations/{impersonationID}", apiCfg.middlewareRequirePermission(rbac.ImpersonateUsers, apiCfg.handlerAdminImpersonationStop))
+	mux.Handle("GET /admin/audit-events", apiCfg.middlewareRequirePermission(rbac.ViewAuditLog, apiCfg.handlerAdminAuditEventsList))
+	mux.Handle("GET /admin/audit-events/verify", apiCfg.middlewareRequirePermission(rbac.ViewAuditLog, apiCfg.handlerAdminAuditEventsVerify))
 
 	// Create a new http.Server struct:
 	srv := &http.Server{
 		Addr:    ":" + port,	// Set the .Addr field to ":8080"
-		Handler: mux,			// Use the new "ServeMux" as the server's handler
+		// Use the new "ServeMux" as the server's handler, behind the CSRF check for cookie sessions,
+		// the audit of requests made while impersonating, and the request IDs audit entries carry:
+		Handler: middlewareRequestID(apiCfg.middlewareAuditImpersonation(apiCfg.middlewareCSRF(mux))),
 	}
 
 	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
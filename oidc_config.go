@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/craigbucher/learn-http-servers/internal/oidc"
+)
+
+// newOIDCClientFromEnv sets up "sign in with ..." from these variables, or returns nil if
+// OIDC_ISSUER isn't set (the login routes then answer 404):
+//   - OIDC_ISSUER: the provider's issuer URL, e.g. https://accounts.google.com
+//   - OIDC_CLIENT_ID and OIDC_CLIENT_SECRET: what the provider gave us when we registered Chirpy
+//
+// The provider must allow publicURL + "/api/login/oidc/callback" as a redirect URI.
+func newOIDCClientFromEnv(publicURL string) (*oidc.Client, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	if clientID == "" {
+		return nil, errors.New("OIDC_CLIENT_ID must be set along with OIDC_ISSUER")
+	}
+	return oidc.NewClient(oidc.Config{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  publicURL + "/api/login/oidc/callback",
+	})
+}
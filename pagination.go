@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// parsePagination reads the optional ?limit= and ?offset= query parameters from a request. A missing
+// limit falls back to defaultPageLimit, and anything above maxPageLimit is clamped so a single
+// request can't ask the database for an unbounded number of rows:
+func parsePagination(r *http.Request) (limit, offset int32, err error) {
+	limit = defaultPageLimit
+	// r.URL.Query().Get returns "" when the parameter isn't present:
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return 0, 0, errors.New("limit must be a positive integer")
+		}
+		limit = int32(min(n, maxPageLimit))
+	}
+	if s := r.URL.Query().Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > 1<<31-1 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+		offset = int32(n)
+	}
+	return limit, offset, nil
+}
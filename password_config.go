@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/passwordpolicy"
+)
+
+// hashConfigFromEnv reads how new passwords are hashed:
+//   - PASSWORD_HASH: "argon2id" (the default) or "bcrypt"
+//   - ARGON2_MEMORY_KIB, ARGON2_ITERATIONS, ARGON2_PARALLELISM: Argon2id settings
+//   - ARGON2_MAX_MEMORY_KIB: how much memory the Argon2id hashes running at once may use between
+//     them; logins past that wait briefly, then get a 503
+//   - BCRYPT_COST: bcrypt's work factor
+//
+// Raising any of these upgrades existing users' hashes the next time they log in.
+func hashConfigFromEnv() (auth.HashConfig, error) {
+	c := auth.DefaultHashConfig
+	if s := os.Getenv("PASSWORD_HASH"); s != "" {
+		c.Algorithm = s
+	}
+
+	for _, setting := range []struct {
+		name string
+		bits int
+		set  func(uint64)
+	}{
+		{"ARGON2_MEMORY_KIB", 32, func(n uint64) { c.Argon2.Memory = uint32(n) }},
+		{"ARGON2_ITERATIONS", 32, func(n uint64) { c.Argon2.Iterations = uint32(n) }},
+		{"ARGON2_PARALLELISM", 8, func(n uint64) { c.Argon2.Parallelism = uint8(n) }},
+		{"BCRYPT_COST", 8, func(n uint64) { c.BcryptCost = int(n) }},
+		{"ARGON2_MAX_MEMORY_KIB", 32, func(n uint64) { c.Argon2MaxMemory = uint32(n) }},
+	} {
+		s := os.Getenv(setting.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(s, 10, setting.bits)
+		if err != nil {
+			return auth.HashConfig{}, fmt.Errorf("invalid %s %q", setting.name, s)
+		}
+		setting.set(n)
+	}
+	return c, nil
+}
+
+// respondWithHashError answers 503 if err is auth.ErrHashBusy, so the client knows to try again,
+// and 500 with msg for anything else:
+func respondWithHashError(w http.ResponseWriter, msg string, err error) {
+	if errors.Is(err, auth.ErrHashBusy) {
+		w.Header().Set("Retry-After", "1")
+		respondWithError(w, http.StatusServiceUnavailable, "Server is busy, try again in a moment", err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, msg, err)
+}
+
+// passwordPolicyFromEnv reads the rules new passwords must follow:
+//   - PASSWORD_MIN_LENGTH: defaults to 8
+//   - BREACHED_PASSWORDS_FILE: optional path to a list of SHA-1 hashes of breached passwords (the
+//     "Pwned Passwords" download format) to refuse
+func passwordPolicyFromEnv() (passwordpolicy.Policy, error) {
+	p := passwordpolicy.DefaultPolicy
+	if s := os.Getenv("PASSWORD_MIN_LENGTH"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("invalid PASSWORD_MIN_LENGTH %q", s)
+		}
+		p.MinLength = n
+	}
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		list, err := passwordpolicy.LoadBreachedList(path)
+		if err != nil {
+			return p, fmt.Errorf("loading BREACHED_PASSWORDS_FILE: %w", err)
+		}
+		p.Breached = list
+	}
+	return p, nil
+}
+
+// checkPassword responds with 400 and every broken rule, and returns false, if password isn't
+// acceptable for the account with this email and handle:
+func (cfg *apiConfig) checkPassword(w http.ResponseWriter, password, email, handle string) bool {
+	type response struct {
+		Error      string                     `json:"error"`
+		Violations []passwordpolicy.Violation `json:"violations"`
+	}
+	violations := cfg.passwordPolicy.Check(password, email, handle)
+	if len(violations) == 0 {
+		return true
+	}
+	respondWithJSON(w, http.StatusBadRequest, response{
+		Error:      "Password doesn't meet the requirements",
+		Violations: violations,
+	})
+	return false
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// purgeDeleted hard-deletes users and chirps that were tombstoned more than cfg.purgeRetention ago.
+// Media blobs live outside the database, so they're removed from the blob store first; the rows
+// themselves (media, revisions, a user's chirps) go with the foreign key cascades:
+func (cfg *apiConfig) purgeDeleted(ctx context.Context) error {
+	retention := cfg.purgeRetention.Seconds()
+
+	media, err := cfg.db.GetPurgeableMedia(ctx, retention)
+	if err != nil {
+		return err
+	}
+	for _, m := range media {
+		if err := cfg.blobs.Delete(ctx, m.BlobKey); err != nil {
+			return err
+		}
+		if err := cfg.blobs.Delete(ctx, m.ThumbnailKey); err != nil {
+			return err
+		}
+		// delete the row as soon as its blobs are gone, so a failure part way through never leaves
+		// a row pointing at missing files:
+		if err := cfg.db.DeleteMedia(ctx, m.ID); err != nil {
+			return err
+		}
+	}
+
+	// exports are personal data too:
+	exports, err := cfg.db.GetPurgeableDataExports(ctx, retention)
+	if err != nil {
+		return err
+	}
+	if err := cfg.deleteDataExportArchives(ctx, exports); err != nil {
+		return err
+	}
+
+	chirps, err := cfg.db.PurgeDeletedChirps(ctx, retention)
+	if err != nil {
+		return err
+	}
+	users, err := cfg.db.PurgeDeletedUsers(ctx, retention)
+	if err != nil {
+		return err
+	}
+	if chirps > 0 || users > 0 || len(media) > 0 {
+		log.Printf("Purged %d users, %d chirps and %d media", users, chirps, len(media))
+	}
+	return nil
+}
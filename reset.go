@@ -5,7 +5,8 @@ import "net/http"
 // declares a method named handlerReset on the *apiConfig struct. It’s designed to be used as an HTTP 
 // handler, so it receives a http.ResponseWriter and an *http.Request
 func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
-	// If PLATFORM is not equal to "dev", this endpoint should return a 403 Forbidden:
+	// only admins get this far (see main.go), but wiping every table is still too dangerous to allow
+	// anywhere but a dev machine, so if PLATFORM is not equal to "dev", return a 403 Forbidden:
 	if cfg.platform != "dev" {
 		w.WriteHeader(http.StatusForbidden)
 		w.Write([]byte("Reset is only allowed in dev environment."))
@@ -27,6 +28,14 @@ func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Failed to reset the database: " + err.Error()))
 		return
 	}
+	// the audit log isn't touched by the reset, so it's the one place that remembers who did it:
+	actor, onBehalfOf := auditActor(claimsFromContext(r.Context()))
+	cfg.recordAudit(r, auditEvent{
+		Actor:      actor,
+		OnBehalfOf: onBehalfOf,
+		Action:     auditReset,
+		Target:     "database",
+	})
 
 	// explicitly set the HTTP status code to 200 (OK), indicating success:
 	w.WriteHeader(http.StatusOK)
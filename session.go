@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+	"github.com/craigbucher/learn-http-servers/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// browser sessions last a month, then the user logs in again:
+	sessionExpiry = 30 * 24 * time.Hour
+	sessionCookie = "chirpy_session"
+	// the CSRF token is in a cookie the web app's JavaScript can read, and must come back in the
+	// X-CSRF-Token header on anything that changes state:
+	csrfCookie = "chirpy_csrf"
+	csrfHeader = "X-CSRF-Token"
+	// user agents can be arbitrarily long; we only keep enough to recognise the browser:
+	maxUserAgentLength = 512
+)
+
+// secureCookies is whether cookies get the Secure flag. Browsers only send those over HTTPS, so
+// it's left off when running on plain http://localhost:
+func (cfg *apiConfig) secureCookies() bool {
+	return strings.HasPrefix(cfg.publicURL, "https://")
+}
+
+// createSession records a login from the device r came from. Cookie sessions pass the hash of
+// their token; logins that get tokens pass an invalid tokenHash and tie their refresh token to the
+// session instead:
+func createSession(ctx context.Context, q *database.Queries, r *http.Request, userID uuid.UUID, tokenHash sql.NullString, expiresIn time.Duration) (database.Session, error) {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return q.CreateSession(ctx, database.CreateSessionParams{
+		TokenHash:        tokenHash,
+		UserID:           userID,
+		UserAgent:        userAgent,
+		Ip:               clientIP(r),
+		ExpiresInSeconds: expiresIn.Seconds(),
+	})
+}
+
+// startCookieSession stores a new session for user and sets the cookies for it, returning the CSRF
+// token the web app needs to send back:
+func (cfg *apiConfig) startCookieSession(w http.ResponseWriter, r *http.Request, user database.User) (string, error) {
+	token, err := auth.MakeToken()
+	if err != nil {
+		return "", err
+	}
+	tokenHash := sql.NullString{String: auth.HashToken(token), Valid: true}
+	_, err = createSession(r.Context(), cfg.db, r, user.ID, tokenHash, sessionExpiry)
+	if err != nil {
+		return "", err
+	}
+
+	csrfToken := auth.MakeCSRFToken(token, cfg.csrfSecret)
+	// HttpOnly keeps the session token away from JavaScript; SameSite=Lax means other sites can
+	// link to us without the cookie being sent on their form posts or fetches:
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(sessionExpiry.Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    csrfToken,
+		Path:     "/",
+		MaxAge:   int(sessionExpiry.Seconds()),
+		Secure:   cfg.secureCookies(),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return csrfToken, nil
+}
+
+// clearSessionCookies tells the browser to forget its session:
+func (cfg *apiConfig) clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{sessionCookie, csrfCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: name == sessionCookie,
+			Secure:   cfg.secureCookies(),
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+// authenticateSession checks the token from a session cookie and notes that the session is still
+// in use. A session is a full login, so it comes with the user's current roles:
+func (cfg *apiConfig) authenticateSession(r *http.Request, token string) (auth.Claims, error) {
+	session, err := cfg.db.GetActiveSession(r.Context(), auth.HashToken(token))
+	if err != nil {
+		return auth.Claims{}, err
+	}
+	roles, err := cfg.db.GetUserRoles(r.Context(), session.UserID)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+	// last-seen tracking is only informational, so a failure here doesn't fail the request:
+	if err := cfg.db.TouchSession(r.Context(), session.ID); err != nil {
+		log.Printf("Couldn't update last use of session %s: %s", session.ID, err)
+	}
+	return auth.Claims{UserID: session.UserID, Roles: roles, SessionID: session.ID}, nil
+}
+
+// sessionToken is the token from the request's session cookie, if it's authenticating with one.
+// An Authorization header always wins over the cookie:
+func sessionToken(r *http.Request) (string, bool) {
+	if r.Header.Get("Authorization") != "" {
+		return "", false
+	}
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// middlewareCSRF protects cookie sessions from cross-site request forgery: an API request that
+// changes something and is authenticated by the session cookie must also carry the session's CSRF
+// token in the X-CSRF-Token header. Bearer tokens and API keys aren't sent automatically by the
+// browser, so requests using them don't need it:
+func (cfg *apiConfig) middlewareCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		// the OAuth consent form is a plain HTML form that asks for the password itself, so it
+		// doesn't rely on the cookie:
+		if !strings.HasPrefix(r.URL.Path, "/api/") && !strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := sessionToken(r)
+		if ok && !auth.CheckCSRFToken(r.Header.Get(csrfHeader), token, cfg.csrfSecret) {
+			respondWithError(w, http.StatusForbidden, "Missing or invalid CSRF token", errors.New("CSRF check failed"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkLoginOrigin guards cookie-session logins against login CSRF, where another site's form (a
+// text/plain one gets past JSON decoding) logs the victim's browser in to the attacker's account so
+// whatever they do next ends up there. Browsers say where a POST came from in Origin, and newer ones
+// in Sec-Fetch-Site too; clients that aren't browsers send neither, and can't be tricked like this:
+func (cfg *apiConfig) checkLoginOrigin(r *http.Request) error {
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return errors.New("cross-site login request")
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	public, err := url.Parse(cfg.publicURL)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(origin, public.Scheme+"://"+public.Host) {
+		return errors.New("login request from another origin: " + origin)
+	}
+	return nil
+}
+
+// parseSessionMode reads the optional "session" parameter of the login endpoints: "cookie" asks
+// for a cookie session instead of tokens:
+func parseSessionMode(mode string) (cookie bool, err error) {
+	switch mode {
+	case "":
+		return false, nil
+	case "cookie":
+		return true, nil
+	default:
+		return false, errors.New(`Session must be "cookie" or left out`)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/craigbucher/learn-http-servers/internal/auth"
+)
+
+// a shorter HMAC secret could be brute-forced from a single token (openssl rand -base64 48 makes a
+// good one):
+const minHMACSecretLength = 32
+
+// signingKeysFromEnv builds the key ring access tokens are signed with. JWT_SECRET is always in
+// it (and held to minHMACSecretLength like any other secret), signing tokens without a "kid" like it always has. JWT_KEYS_FILE optionally names a JSON file
+// of keys to take over from it, either asymmetric ones (published at /.well-known/jwks.json) or
+// shared secrets with a kid, for rotating an HMAC secret:
+//
+//	{"keys": [
+//	  {"kid": "2026-09", "private_key_file": "2026-09.pem", "active_from": "2026-09-01T00:00:00Z"},
+//	  {"kid": "2026-10", "secret_file": "2026-10.secret", "active_from": "2026-10-20T00:00:00Z"}
+//	]}
+//
+// Paths are relative to the file. Every key needs an active_from, so restarting never changes
+// which key signs. To rotate, add the new key with an active_from far enough ahead for other
+// services to fetch it; the key it replaces is accepted for another accessTokenExpiry after that,
+// then can be removed.
+func signingKeysFromEnv(jwtSecret string) (*auth.KeyRing, error) {
+	if len(jwtSecret) < minHMACSecretLength {
+		return nil, fmt.Errorf("JWT_SECRET must be at least %d characters", minHMACSecretLength)
+	}
+	keys := []auth.SigningKey{auth.NewHMACKey("", jwtSecret, time.Time{})}
+
+	path := os.Getenv("JWT_KEYS_FILE")
+	if path == "" {
+		return auth.NewKeyRing(accessTokenExpiry, keys...)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Keys []struct {
+			KeyID          string     `json:"kid"`
+			PrivateKeyFile string     `json:"private_key_file"`
+			SecretFile     string     `json:"secret_file"`
+			ActiveFrom     *time.Time `json:"active_from"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// key files are found next to the keys file unless they say otherwise:
+	readKeyFile := func(keyPath string) ([]byte, error) {
+		if !filepath.IsAbs(keyPath) {
+			keyPath = filepath.Join(filepath.Dir(path), keyPath)
+		}
+		return os.ReadFile(keyPath)
+	}
+	for _, k := range file.Keys {
+		if k.KeyID == "" {
+			return nil, fmt.Errorf("%s: every key needs a kid", path)
+		}
+		if k.ActiveFrom == nil {
+			return nil, fmt.Errorf("%s: key %q needs an active_from", path, k.KeyID)
+		}
+		var key auth.SigningKey
+		switch {
+		case k.PrivateKeyFile != "" && k.SecretFile != "":
+			return nil, fmt.Errorf("%s: key %q has both a private_key_file and a secret_file", path, k.KeyID)
+		case k.PrivateKeyFile != "":
+			pemBytes, err := readKeyFile(k.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			key, err = auth.ParsePrivateKeyPEM(k.KeyID, pemBytes, *k.ActiveFrom)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", k.KeyID, err)
+			}
+		case k.SecretFile != "":
+			secret, err := readKeyFile(k.SecretFile)
+			if err != nil {
+				return nil, err
+			}
+			// a trailing newline from the editor isn't part of the secret:
+			s := strings.TrimSpace(string(secret))
+			if len(s) < minHMACSecretLength {
+				return nil, fmt.Errorf("key %q: secret must be at least %d characters", k.KeyID, minHMACSecretLength)
+			}
+			key = auth.NewHMACKey(k.KeyID, s, *k.ActiveFrom)
+		default:
+			return nil, fmt.Errorf("%s: key %q needs a private_key_file or a secret_file", path, k.KeyID)
+		}
+		keys = append(keys, key)
+	}
+	return auth.NewKeyRing(accessTokenExpiry, keys...)
+}
+
+// handles GET /.well-known/jwks.json: the public keys that sign (or are about to sign) our access
+// tokens, so other services can check them without sharing a secret with us:
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	// a few minutes of caching is fine, since new keys are published well before they're used:
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, cfg.signingKeys.JWKS())
+}
@@ -0,0 +1,36 @@
+-- name: ScheduleUserDeletion :one
+UPDATE users
+SET deletion_scheduled_at = NOW() + make_interval(secs => sqlc.arg(grace_period_seconds)::float8),
+    updated_at = NOW()
+WHERE id = sqlc.arg(id) AND deleted_at IS NULL
+RETURNING *;
+
+-- name: CancelUserDeletion :exec
+UPDATE users
+SET deletion_scheduled_at = NULL, updated_at = NOW()
+WHERE id = $1 AND deletion_scheduled_at IS NOT NULL;
+
+-- name: GetUsersDueForDeletion :many
+SELECT * FROM users
+WHERE deletion_scheduled_at <= NOW() AND deleted_at IS NULL;
+
+-- Scrubs everything that identifies the person but keeps the row, so their chirps stay up under a 
+-- "Deleted user" name. The email and handle are derived from the ID so they stay unique, and 
+-- 'unset' isn't a valid password hash, so nobody can log in to the account again. Its two-factor 
+-- secret goes too; its recovery codes and external logins are deleted alongside (see 
+-- processAccountDeletions):
+-- name: AnonymizeUser :exec
+UPDATE users
+SET email = 'deleted-' || id::text || '@deleted.invalid',
+    handle = 'deleted_' || replace(id::text, '-', ''),
+    display_name = 'Deleted user',
+    bio = '',
+    avatar_url = '',
+    hashed_password = 'unset',
+    totp_secret = NULL,
+    totp_enabled_at = NULL,
+    totp_last_step = NULL,
+    deletion_scheduled_at = NULL,
+    anonymized_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1;
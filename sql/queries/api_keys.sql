@@ -0,0 +1,46 @@
+-- name: CreateAPIKey :one
+INSERT INTO api_keys (id, created_at, user_id, name, prefix, key_hash, scopes, expires_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    sqlc.arg(user_id),
+    sqlc.arg(name),
+    sqlc.arg(prefix),
+    sqlc.arg(key_hash),
+    sqlc.arg(scopes)::text[],
+    -- a NULL expires_in_seconds gives a NULL expires_at, i.e. a key that never expires:
+    NOW() + make_interval(secs => sqlc.narg(expires_in_seconds)::float8)
+)
+RETURNING *;
+
+-- Revoked keys are left out; expired ones are listed so users can see why a script stopped working:
+-- name: ListAPIKeys :many
+SELECT * FROM api_keys
+WHERE user_id = $1 AND revoked_at IS NULL
+ORDER BY created_at DESC;
+
+-- Only keys that are unrevoked, unexpired and belong to a live account are accepted:
+-- name: GetAPIKeyByHash :one
+SELECT api_keys.* FROM api_keys
+JOIN users ON users.id = api_keys.user_id
+WHERE api_keys.key_hash = $1
+    AND api_keys.revoked_at IS NULL
+    AND (api_keys.expires_at IS NULL OR api_keys.expires_at > NOW())
+    AND users.deleted_at IS NULL;
+
+-- Only writes when the stored time is more than a minute old, so a busy script doesn't turn every
+-- request into an UPDATE:
+-- name: TouchAPIKey :exec
+UPDATE api_keys
+SET last_used_at = NOW()
+WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute');
+
+-- name: RevokeAPIKey :execrows
+UPDATE api_keys
+SET revoked_at = NOW()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeAllAPIKeysForUser :exec
+UPDATE api_keys
+SET revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
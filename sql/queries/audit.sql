@@ -0,0 +1,48 @@
+-- Entries are chained in the order they're written, so writers take turns: the lock is held until
+-- the transaction ends, by which time the new entry is the one the next writer sees as the last.
+-- The number is arbitrary, it just has to be the same everywhere.
+
+-- name: LockAuditLog :exec
+SELECT pg_advisory_xact_lock(7283401);
+
+-- name: GetLastAuditHash :one
+SELECT hash::text FROM audit_events
+WHERE hash IS NOT NULL
+ORDER BY id DESC
+LIMIT 1;
+
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (
+    created_at, actor_id, on_behalf_of, action, target, ip, details, request_id, diff, prev_hash, hash
+)
+VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, sqlc.arg(prev_hash)::text, sqlc.arg(hash)::text
+);
+
+-- Each filter is ignored when it's NULL:
+
+-- name: ListAuditEvents :many
+SELECT * FROM audit_events
+WHERE (sqlc.narg(actor_id)::uuid IS NULL OR actor_id = sqlc.narg(actor_id) OR on_behalf_of = sqlc.narg(actor_id))
+    AND (sqlc.narg(action)::text IS NULL OR action = sqlc.narg(action))
+    AND (sqlc.narg(target)::text IS NULL OR target = sqlc.narg(target))
+    AND (sqlc.narg(since)::timestamp IS NULL OR created_at >= sqlc.narg(since))
+    AND (sqlc.narg(until)::timestamp IS NULL OR created_at < sqlc.narg(until))
+ORDER BY id DESC
+LIMIT sqlc.arg(page_limit)::int OFFSET sqlc.arg(page_offset)::int;
+
+-- name: CountAuditEvents :one
+SELECT COUNT(*) FROM audit_events
+WHERE (sqlc.narg(actor_id)::uuid IS NULL OR actor_id = sqlc.narg(actor_id) OR on_behalf_of = sqlc.narg(actor_id))
+    AND (sqlc.narg(action)::text IS NULL OR action = sqlc.narg(action))
+    AND (sqlc.narg(target)::text IS NULL OR target = sqlc.narg(target))
+    AND (sqlc.narg(since)::timestamp IS NULL OR created_at >= sqlc.narg(since))
+    AND (sqlc.narg(until)::timestamp IS NULL OR created_at < sqlc.narg(until));
+
+-- the chained entries after after_id, oldest first, for checking the chain a batch at a time:
+
+-- name: ListAuditChain :many
+SELECT * FROM audit_events
+WHERE hash IS NOT NULL AND id > sqlc.arg(after_id)::bigint
+ORDER BY id
+LIMIT sqlc.arg(page_limit)::int;
@@ -0,0 +1,30 @@
+-- Locks the chirp row until the transaction ends, so two edits can't both save the same old body 
+-- as a revision. editable says whether the chirp is still inside the edit window:
+-- name: GetChirpForEdit :one
+SELECT
+    *,
+    (created_at > NOW() - make_interval(secs => sqlc.arg(edit_window_seconds)::float8))::boolean AS editable
+FROM chirps
+WHERE id = sqlc.arg(id) AND deleted_at IS NULL
+FOR UPDATE;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, edited = TRUE, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: CreateChirpRevision :one
+INSERT INTO chirp_revisions (id, created_at, chirp_id, body)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2
+)
+RETURNING *;
+
+-- name: GetChirpRevisions :many
+SELECT * FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY created_at ASC;
@@ -9,10 +9,27 @@ VALUES (
 )
 RETURNING *;
 
+-- Chirps that have been deleted, or whose author has been, are never returned:
+
 -- name: GetChirps :many
 SELECT * FROM chirps
+WHERE deleted_at IS NULL
+    AND EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NULL)
 ORDER BY created_at ASC;
 
 -- name: GetChirp :one
 SELECT * FROM chirps
-WHERE id = $1;
\ No newline at end of file
+WHERE chirps.id = $1
+    AND deleted_at IS NULL
+    AND EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NULL);
+
+-- name: SoftDeleteChirp :execrows
+UPDATE chirps
+SET deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL;
+
+-- name: RestoreChirp :one
+UPDATE chirps
+SET deleted_at = NULL, updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NOT NULL
+RETURNING *;
\ No newline at end of file
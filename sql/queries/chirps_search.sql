@@ -0,0 +1,40 @@
+-- websearch_to_tsquery understands "quoted phrases", -negation and OR. It has no prefix syntax, so 
+-- prefix terms (e.g. chirp*) are passed separately as a to_tsquery string and AND-ed on; an empty 
+-- prefix query is simply ignored by the && operator.
+-- strip_chars are removed from the body before highlighting, so the markers ts_headline adds are
+-- the only ones in the snippet.
+
+-- name: SearchChirps :many
+SELECT
+    id,
+    created_at,
+    updated_at,
+    body,
+    user_id,
+    edited,
+    ts_rank(
+        search_vector,
+        websearch_to_tsquery('english', sqlc.arg(query)::text) && to_tsquery('english', sqlc.arg(prefix_query)::text)
+    )::real AS rank,
+    ts_headline(
+        'english',
+        translate(body, sqlc.arg(strip_chars)::text, ''),
+        websearch_to_tsquery('english', sqlc.arg(query)::text) && to_tsquery('english', sqlc.arg(prefix_query)::text),
+        sqlc.arg(headline_options)::text
+    )::text AS snippet
+FROM chirps
+WHERE search_vector @@ (
+    websearch_to_tsquery('english', sqlc.arg(query)::text) && to_tsquery('english', sqlc.arg(prefix_query)::text)
+)
+    AND deleted_at IS NULL
+    AND EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NULL)
+ORDER BY rank DESC, created_at DESC
+LIMIT sqlc.arg(page_limit)::int OFFSET sqlc.arg(page_offset)::int;
+
+-- name: CountSearchChirps :one
+SELECT COUNT(*) FROM chirps
+WHERE search_vector @@ (
+    websearch_to_tsquery('english', sqlc.arg(query)::text) && to_tsquery('english', sqlc.arg(prefix_query)::text)
+)
+    AND deleted_at IS NULL
+    AND EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NULL);
@@ -0,0 +1,100 @@
+-- name: CreateDataExport :one
+INSERT INTO data_exports (id, created_at, updated_at, user_id, status)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    'pending'
+)
+RETURNING *;
+
+-- An export that's already queued or running is reused instead of starting another one:
+-- name: GetActiveDataExport :one
+SELECT * FROM data_exports
+WHERE user_id = $1 AND status IN ('pending', 'processing')
+ORDER BY created_at DESC
+LIMIT 1;
+
+-- name: GetDataExport :one
+SELECT * FROM data_exports
+WHERE id = $1 AND user_id = $2;
+
+-- Download links are signed, so they work without an access token; this just checks the archive 
+-- is still there:
+-- name: GetReadyDataExport :one
+SELECT * FROM data_exports
+WHERE id = $1 AND status = 'ready' AND expires_at > NOW();
+
+-- Claims the oldest pending export for this worker. SKIP LOCKED lets several servers run the job 
+-- without grabbing the same row, and an export stuck in "processing" for an hour (its server 
+-- probably died) is picked up again:
+-- name: ClaimDataExport :one
+UPDATE data_exports
+SET status = 'processing', updated_at = NOW()
+WHERE id = (
+    SELECT id FROM data_exports
+    WHERE status = 'pending'
+        OR (status = 'processing' AND updated_at < NOW() - INTERVAL '1 hour')
+    ORDER BY created_at ASC
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: CompleteDataExport :exec
+UPDATE data_exports
+SET status = 'ready',
+    blob_key = sqlc.arg(blob_key),
+    size_bytes = sqlc.arg(size_bytes),
+    completed_at = NOW(),
+    expires_at = NOW() + make_interval(secs => sqlc.arg(expires_in_seconds)::float8),
+    updated_at = NOW()
+WHERE id = sqlc.arg(id);
+
+-- name: FailDataExport :exec
+UPDATE data_exports
+SET status = 'failed', completed_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: GetExpiredDataExports :many
+SELECT * FROM data_exports
+WHERE status = 'ready' AND expires_at <= NOW();
+
+-- name: ExpireDataExport :exec
+UPDATE data_exports
+SET status = 'expired', blob_key = NULL, updated_at = NOW()
+WHERE id = $1;
+
+-- Archives still in the blob store for a user whose account is being deleted, so they can go
+-- before their download window would have closed:
+-- name: GetStoredDataExportsForUser :many
+SELECT * FROM data_exports
+WHERE user_id = $1 AND status = 'ready';
+
+-- Archives of users the purge job is about to delete (their rows go with the cascade, but the
+-- blobs wouldn't):
+-- name: GetPurgeableDataExports :many
+SELECT data_exports.* FROM data_exports
+JOIN users ON users.id = data_exports.user_id
+WHERE data_exports.status = 'ready'
+    AND users.deleted_at < NOW() - make_interval(secs => sqlc.arg(retention_seconds)::float8);
+
+-- Everything below gathers the data that goes into an export. Unlike the public queries, these 
+-- include the user's own deleted chirps (until they're purged), since it's still data we hold:
+
+-- name: GetChirpsForExport :many
+SELECT * FROM chirps
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- name: GetChirpRevisionsForExport :many
+SELECT chirp_revisions.* FROM chirp_revisions
+JOIN chirps ON chirps.id = chirp_revisions.chirp_id
+WHERE chirps.user_id = $1
+ORDER BY chirp_revisions.created_at ASC;
+
+-- name: GetMediaForExport :many
+SELECT * FROM media
+WHERE user_id = $1
+ORDER BY created_at ASC;
@@ -0,0 +1,30 @@
+-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens (token_hash, created_at, user_id, email, expires_at, used_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    NOW() + make_interval(secs => sqlc.arg(expires_in_seconds)::float8),
+    NULL
+);
+
+-- name: ConsumeEmailVerificationToken :one
+UPDATE email_verification_tokens
+SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING user_id, email;
+
+-- Only verifies the address the token was sent to; if the user has changed it since, nothing 
+-- happens:
+-- name: MarkEmailVerified :one
+UPDATE users
+SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND email = $2 AND deleted_at IS NULL
+RETURNING *;
+
+-- Used to throttle resends:
+-- name: CountEmailVerificationTokensSince :one
+SELECT COUNT(*) FROM email_verification_tokens
+WHERE user_id = $1
+    AND created_at > NOW() - make_interval(secs => sqlc.arg(window_seconds)::float8);
@@ -0,0 +1,22 @@
+-- name: CreateImpersonation :one
+INSERT INTO impersonations (id, created_at, admin_id, user_id, reason, expires_at, ended_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    NOW() + make_interval(secs => sqlc.arg(expires_in_seconds)::float8),
+    NULL
+)
+RETURNING *;
+
+-- name: GetActiveImpersonation :one
+SELECT * FROM impersonations
+WHERE id = $1 AND ended_at IS NULL AND expires_at > NOW();
+
+-- name: EndImpersonation :one
+UPDATE impersonations
+SET ended_at = NOW()
+WHERE id = $1 AND ended_at IS NULL AND expires_at > NOW()
+RETURNING *;
@@ -0,0 +1,43 @@
+-- Returns how much longer the most restrictive of the given keys is blocked for, or no rows if none 
+-- are:
+-- name: GetLoginBlock :one
+SELECT EXTRACT(EPOCH FROM MAX(blocked_until) - NOW())::float8 AS retry_after_seconds
+FROM login_failures
+WHERE key = ANY(sqlc.arg(keys)::text[]) AND blocked_until > NOW()
+HAVING COUNT(*) > 0;
+
+-- Counts one more attempt, before the password is checked, so requests racing each other can't 
+-- all get in under the limit. A key that hasn't failed for window_seconds starts again from one, so 
+-- the odd typo spread over weeks never adds up to a lockout. Nothing is counted, and no rows come 
+-- back, while the key is blocked:
+-- name: RecordLoginAttempt :one
+INSERT INTO login_failures (key, failures, last_failure_at, blocked_until)
+VALUES (sqlc.arg(key), 1, NOW(), NULL)
+ON CONFLICT (key) DO UPDATE
+SET failures = CASE
+        WHEN login_failures.last_failure_at < NOW() - make_interval(secs => sqlc.arg(window_seconds)::float8) THEN 1
+        ELSE login_failures.failures + 1
+    END,
+    last_failure_at = NOW()
+WHERE login_failures.blocked_until IS NULL OR login_failures.blocked_until <= NOW()
+RETURNING failures;
+
+-- Takes back an attempt that turned out to be a success:
+-- name: ForgiveLoginAttempt :exec
+UPDATE login_failures
+SET failures = GREATEST(failures - 1, 0)
+WHERE key = $1;
+
+-- name: BlockLogin :exec
+UPDATE login_failures
+SET blocked_until = NOW() + make_interval(secs => sqlc.arg(block_seconds)::float8)
+WHERE key = sqlc.arg(key);
+
+-- name: ClearLoginFailures :exec
+DELETE FROM login_failures
+WHERE key = $1;
+
+-- name: DeleteStaleLoginFailures :execrows
+DELETE FROM login_failures
+WHERE last_failure_at < NOW() - make_interval(secs => sqlc.arg(window_seconds)::float8)
+    AND (blocked_until IS NULL OR blocked_until < NOW());
@@ -0,0 +1,60 @@
+-- name: CreateMedia :one
+INSERT INTO media (
+    id, created_at, updated_at, user_id, content_type, size_bytes, width, height,
+    blob_key, thumbnail_key, thumbnail_content_type
+)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9
+)
+RETURNING *;
+
+-- Media on a deleted chirp, or uploaded by a deleted user, is hidden along with them:
+-- name: GetMedia :one
+SELECT * FROM media
+WHERE media.id = $1
+    AND NOT EXISTS (SELECT 1 FROM chirps WHERE chirps.id = media.chirp_id AND chirps.deleted_at IS NOT NULL)
+    AND EXISTS (SELECT 1 FROM users WHERE users.id = media.user_id AND users.deleted_at IS NULL);
+
+-- Only the uploader's own, not-yet-attached media is updated, so the caller can compare the number 
+-- of returned rows with the number of IDs it asked for:
+-- name: AttachMediaToChirp :many
+UPDATE media
+SET chirp_id = sqlc.arg(chirp_id), updated_at = NOW()
+WHERE id = ANY(sqlc.arg(ids)::uuid[])
+    AND user_id = sqlc.arg(user_id)
+    AND chirp_id IS NULL
+RETURNING *;
+
+-- name: GetMediaForChirps :many
+SELECT * FROM media
+WHERE chirp_id = ANY(sqlc.arg(chirp_ids)::uuid[])
+ORDER BY created_at ASC;
+
+-- Media that belongs to chirps or users tombstoned before the retention cutoff, i.e. everything the 
+-- purge job is about to cascade-delete:
+-- name: GetPurgeableMedia :many
+SELECT * FROM media
+WHERE EXISTS (
+        SELECT 1 FROM chirps
+        WHERE chirps.id = media.chirp_id
+            AND chirps.deleted_at < NOW() - make_interval(secs => sqlc.arg(retention_seconds)::float8)
+    )
+    OR EXISTS (
+        SELECT 1 FROM users
+        WHERE users.id = media.user_id
+            AND users.deleted_at < NOW() - make_interval(secs => sqlc.arg(retention_seconds)::float8)
+    );
+
+-- name: DeleteMedia :exec
+DELETE FROM media
+WHERE id = $1;
@@ -0,0 +1,126 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, created_at, updated_at, owner_id, name, redirect_uris, secret_hash)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    sqlc.arg(owner_id),
+    sqlc.arg(name),
+    sqlc.arg(redirect_uris)::text[],
+    sqlc.narg(secret_hash)
+)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients
+WHERE id = $1;
+
+-- name: ListOAuthClients :many
+SELECT * FROM oauth_clients
+WHERE owner_id = $1
+ORDER BY created_at DESC;
+
+-- Deleting a client cascades to its codes and tokens, so every app session ends with it:
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients
+WHERE id = $1 AND owner_id = $2;
+
+-- name: CreateOAuthAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (
+    code_hash, created_at, grant_id, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at
+)
+VALUES (
+    sqlc.arg(code_hash),
+    NOW(),
+    gen_random_uuid(),
+    sqlc.arg(client_id),
+    sqlc.arg(user_id),
+    sqlc.arg(redirect_uri),
+    sqlc.arg(scopes)::text[],
+    sqlc.arg(code_challenge),
+    NOW() + make_interval(secs => sqlc.arg(expires_in_seconds)::float8)
+);
+
+-- Marks the code used and returns it, or no rows if it's unknown, expired or already used. Codes
+-- for accounts that have since been deleted are refused too:
+-- name: ConsumeOAuthAuthorizationCode :one
+UPDATE oauth_authorization_codes
+SET used_at = NOW()
+WHERE code_hash = $1
+    AND used_at IS NULL
+    AND expires_at > NOW()
+    AND EXISTS (
+        SELECT 1 FROM users
+        WHERE users.id = oauth_authorization_codes.user_id AND users.deleted_at IS NULL
+    )
+RETURNING *;
+
+-- Finds the grant a code belongs to even if it's been used, so a replayed code can revoke
+-- everything that was issued from it:
+-- name: GetOAuthAuthorizationCodeGrant :one
+SELECT grant_id FROM oauth_authorization_codes
+WHERE code_hash = $1;
+
+-- name: CreateOAuthToken :exec
+INSERT INTO oauth_tokens (token_hash, created_at, kind, grant_id, client_id, user_id, scopes, expires_at)
+VALUES (
+    sqlc.arg(token_hash),
+    NOW(),
+    sqlc.arg(kind),
+    sqlc.arg(grant_id),
+    sqlc.arg(client_id),
+    sqlc.arg(user_id),
+    sqlc.arg(scopes)::text[],
+    NOW() + make_interval(secs => sqlc.arg(expires_in_seconds)::float8)
+);
+
+-- Only tokens that are unrevoked, unexpired and belong to a live account are returned. The handle
+-- is for the introspection response:
+-- name: GetActiveOAuthToken :one
+SELECT oauth_tokens.*, users.handle FROM oauth_tokens
+JOIN users ON users.id = oauth_tokens.user_id
+WHERE oauth_tokens.token_hash = $1
+    AND oauth_tokens.revoked_at IS NULL
+    AND oauth_tokens.expires_at > NOW()
+    AND users.deleted_at IS NULL;
+
+-- Refresh tokens are single use: redeeming one revokes it and hands back its row. No rows means it
+-- was unknown, expired or already used:
+-- name: ConsumeOAuthRefreshToken :one
+UPDATE oauth_tokens
+SET revoked_at = NOW()
+WHERE token_hash = $1
+    AND kind = 'refresh'
+    AND revoked_at IS NULL
+    AND expires_at > NOW()
+RETURNING *;
+
+-- Looks a token up whatever its state, to spot a refresh token being replayed:
+-- name: GetOAuthToken :one
+SELECT * FROM oauth_tokens
+WHERE token_hash = $1;
+
+-- name: RevokeOAuthToken :exec
+UPDATE oauth_tokens
+SET revoked_at = NOW()
+WHERE token_hash = $1 AND revoked_at IS NULL;
+
+-- name: RevokeOAuthGrant :exec
+UPDATE oauth_tokens
+SET revoked_at = NOW()
+WHERE grant_id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeAllOAuthTokensForUser :exec
+UPDATE oauth_tokens
+SET revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- Used codes and dead tokens are kept for a while so replays can still be recognised, then
+-- deleted:
+-- name: DeleteStaleOAuthCodes :exec
+DELETE FROM oauth_authorization_codes
+WHERE expires_at < NOW() - make_interval(secs => sqlc.arg(retention_seconds)::float8);
+
+-- name: DeleteStaleOAuthTokens :exec
+DELETE FROM oauth_tokens
+WHERE expires_at < NOW() - make_interval(secs => sqlc.arg(retention_seconds)::float8);
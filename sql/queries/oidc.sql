@@ -0,0 +1,67 @@
+-- name: CreateOIDCLoginState :exec
+INSERT INTO oidc_login_states (state_hash, created_at, nonce, code_verifier, expires_at, cookie_session)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    NOW() + make_interval(secs => sqlc.arg(expires_in_seconds)::float8),
+    $4
+);
+
+-- Deleting the row as we read it means a state can only be used once:
+-- name: ConsumeOIDCLoginState :one
+DELETE FROM oidc_login_states
+WHERE state_hash = $1 AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredOIDCLoginStates :exec
+DELETE FROM oidc_login_states
+WHERE expires_at <= NOW();
+
+-- name: GetUserByIdentity :one
+SELECT users.* FROM users
+JOIN user_identities ON user_identities.user_id = users.id
+WHERE user_identities.issuer = $1
+    AND user_identities.subject = $2
+    AND users.deleted_at IS NULL
+    AND users.anonymized_at IS NULL;
+
+-- Providers don't always send an address the way the user typed it when they signed up, so it's 
+-- matched ignoring case. If two accounts differ only in case, the exact match wins:
+-- name: GetUserByEmailForIdentity :one
+SELECT * FROM users
+WHERE lower(email) = lower(sqlc.arg(email)::text)
+    AND deleted_at IS NULL
+    AND anonymized_at IS NULL
+ORDER BY email = sqlc.arg(email)::text DESC, created_at
+LIMIT 1;
+
+-- name: DeleteUserIdentities :exec
+DELETE FROM user_identities
+WHERE user_id = $1;
+
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (issuer, subject, user_id, email, created_at, last_login_at)
+VALUES ($1, $2, $3, $4, NOW(), NOW());
+
+-- name: TouchUserIdentity :exec
+UPDATE user_identities
+SET email = $3, last_login_at = NOW()
+WHERE issuer = $1 AND subject = $2;
+
+-- Creates an account for someone signing in with a provider for the first time. The provider has
+-- already checked the email address, so it starts out verified:
+-- name: CreateVerifiedUser :one
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle, display_name, email_verified_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    NOW()
+)
+RETURNING *;
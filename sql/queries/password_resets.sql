@@ -0,0 +1,28 @@
+-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens (token_hash, created_at, user_id, expires_at, used_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    NOW() + make_interval(secs => sqlc.arg(expires_in_seconds)::float8),
+    NULL
+);
+
+-- Marks the token used and returns its user in one statement, so two requests racing with the same 
+-- token can't both succeed:
+-- name: ConsumePasswordResetToken :one
+UPDATE password_reset_tokens
+SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING user_id;
+
+-- Once the password has changed, any other reset links still sitting in the user's inbox are void:
+-- name: InvalidatePasswordResetTokens :exec
+UPDATE password_reset_tokens
+SET used_at = NOW()
+WHERE user_id = $1 AND used_at IS NULL;
+
+-- name: UpdateUserPassword :execrows
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL;
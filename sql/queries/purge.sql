@@ -0,0 +1,10 @@
+-- Hard-delete rows that were tombstoned more than retention_seconds ago. Foreign keys cascade, so 
+-- purging a user also removes their chirps, media rows and revisions:
+
+-- name: PurgeDeletedChirps :execrows
+DELETE FROM chirps
+WHERE deleted_at < NOW() - make_interval(secs => sqlc.arg(retention_seconds)::float8);
+
+-- name: PurgeDeletedUsers :execrows
+DELETE FROM users
+WHERE deleted_at < NOW() - make_interval(secs => sqlc.arg(retention_seconds)::float8);
@@ -0,0 +1,45 @@
+-- name: CreateRefreshToken :one
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, user_id, expires_at, revoked_at, session_id)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    NOW() + make_interval(secs => sqlc.arg(expires_in_seconds)::float8),
+    NULL,
+    $3
+)
+RETURNING *;
+
+-- Only tokens that are unrevoked, unexpired and belong to a live account are accepted:
+-- name: GetActiveRefreshToken :one
+SELECT refresh_tokens.* FROM refresh_tokens
+JOIN users ON users.id = refresh_tokens.user_id
+WHERE refresh_tokens.token_hash = $1
+    AND refresh_tokens.revoked_at IS NULL
+    AND refresh_tokens.expires_at > NOW()
+    AND users.deleted_at IS NULL;
+
+-- name: RevokeRefreshToken :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE token_hash = $1 AND revoked_at IS NULL;
+
+-- name: RevokeAllRefreshTokensForUser :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokensForSession :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE session_id = $1 AND revoked_at IS NULL;
+
+-- Revokes everything except the given session's tokens. Tokens from before sessions existed have
+-- no session, so they always go:
+-- name: RevokeOtherRefreshTokensForUser :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = sqlc.arg(user_id)
+    AND session_id IS DISTINCT FROM sqlc.arg(keep_session_id)::uuid
+    AND revoked_at IS NULL;
@@ -0,0 +1,23 @@
+-- name: GetUserRoles :many
+SELECT role FROM user_roles
+WHERE user_id = $1
+ORDER BY role;
+
+-- Granting a role someone already has is a no-op:
+-- name: GrantRole :exec
+INSERT INTO user_roles (user_id, role, granted_by)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, role) DO NOTHING;
+
+-- name: RevokeRole :execrows
+DELETE FROM user_roles
+WHERE user_id = $1 AND role = $2;
+
+-- Only counts accounts someone can still log in to: not deleted, and not anonymized (which leaves 
+-- the row but sets a password hash nothing matches):
+-- name: CountUsersWithRole :one
+SELECT COUNT(*) FROM user_roles
+JOIN users ON users.id = user_roles.user_id
+WHERE user_roles.role = $1
+    AND users.deleted_at IS NULL
+    AND users.hashed_password <> 'unset';
@@ -0,0 +1,88 @@
+-- token_hash is NULL for logins that get tokens rather than a cookie:
+-- name: CreateSession :one
+INSERT INTO sessions (id, token_hash, user_id, created_at, last_seen_at, expires_at, revoked_at, user_agent, ip)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    NOW(),
+    NOW(),
+    NOW() + make_interval(secs => sqlc.arg(expires_in_seconds)::float8),
+    NULL,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- Like refresh tokens, only sessions that are unrevoked, unexpired and belong to a live account
+-- are accepted:
+-- name: GetActiveSession :one
+SELECT sessions.* FROM sessions
+JOIN users ON users.id = sessions.user_id
+WHERE sessions.token_hash = sqlc.arg(token_hash)::text
+    AND sessions.revoked_at IS NULL
+    AND sessions.expires_at > NOW()
+    AND users.deleted_at IS NULL;
+
+-- Access tokens can't be revoked themselves, so each use checks the account is still live and not
+-- waiting to be deleted, and, for a token from a login, that its session hasn't been ended:
+-- name: AccessTokenStillValid :one
+SELECT (
+    EXISTS (
+        SELECT 1 FROM users
+        WHERE users.id = sqlc.arg(user_id)
+            AND users.deleted_at IS NULL
+            AND users.deletion_scheduled_at IS NULL
+    )
+    AND (
+        sqlc.narg(session_id)::uuid IS NULL OR EXISTS (
+            SELECT 1 FROM sessions
+            WHERE sessions.id = sqlc.narg(session_id)
+                AND sessions.user_id = sqlc.arg(user_id)
+                AND sessions.revoked_at IS NULL
+                AND sessions.expires_at > NOW()
+        )
+    )
+)::boolean AS valid;
+
+-- Only writes when the stored time is more than a minute old, so browsing doesn't turn every
+-- request into an UPDATE:
+-- name: TouchSession :exec
+UPDATE sessions
+SET last_seen_at = NOW()
+WHERE id = $1 AND last_seen_at < NOW() - INTERVAL '1 minute';
+
+-- name: ListSessions :many
+SELECT * FROM sessions
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY last_seen_at DESC;
+
+-- name: RevokeSession :execrows
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeSessionByHash :exec
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE token_hash = sqlc.arg(token_hash)::text AND revoked_at IS NULL;
+
+-- Logging out a refresh token ends the session it belongs to:
+-- name: RevokeSessionForRefreshToken :exec
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE id = (SELECT session_id FROM refresh_tokens WHERE refresh_tokens.token_hash = $1) AND revoked_at IS NULL;
+
+-- name: RevokeOtherSessionsForUser :exec
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE user_id = sqlc.arg(user_id) AND id <> sqlc.arg(keep_session_id) AND revoked_at IS NULL;
+
+-- name: RevokeAllSessionsForUser :exec
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: DeleteDeadSessions :exec
+DELETE FROM sessions
+WHERE expires_at < NOW() OR revoked_at IS NOT NULL;
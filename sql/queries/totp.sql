@@ -0,0 +1,30 @@
+-- Starting enrollment again replaces a secret that was never confirmed, but never one in use:
+-- name: SetPendingTOTPSecret :execrows
+UPDATE users
+SET totp_secret = $2, totp_last_step = NULL, updated_at = NOW()
+WHERE id = $1 AND totp_enabled_at IS NULL AND deleted_at IS NULL;
+
+-- name: EnableTOTP :exec
+UPDATE users
+SET totp_enabled_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND totp_secret IS NOT NULL;
+
+-- Records the step of a code that just validated. No row is updated if that step (or a later one) 
+-- was already used, which is how replays are caught, even between two requests racing each other:
+-- name: UseTOTPStep :execrows
+UPDATE users
+SET totp_last_step = sqlc.arg(step)::bigint
+WHERE id = sqlc.arg(id) AND (totp_last_step IS NULL OR totp_last_step < sqlc.arg(step)::bigint);
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (code_hash, created_at, user_id, used_at)
+VALUES ($1, NOW(), $2, NULL);
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = NOW()
+WHERE code_hash = $1 AND user_id = $2 AND used_at IS NULL;
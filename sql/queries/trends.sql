@@ -0,0 +1,12 @@
+-- Ages are computed by Postgres against its own clock, so the trends aggregator never has to 
+-- reconcile the app server's time zone with the TIMESTAMP columns:
+
+-- name: GetRecentChirpBodies :many
+SELECT
+    body,
+    EXTRACT(EPOCH FROM (NOW() - created_at))::float8 AS age_seconds
+FROM chirps
+WHERE created_at >= NOW() - make_interval(secs => sqlc.arg(max_age_seconds)::float8)
+    AND deleted_at IS NULL
+    AND EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NULL)
+ORDER BY created_at DESC;
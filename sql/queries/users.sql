@@ -1,14 +1,54 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3
 )
 RETURNING *;
 
+-- Deleted (tombstoned) users can't be looked up, so they can't log in or be viewed:
+
 -- name: GetUserByEmail :one
 SELECT * FROM users
-WHERE email = $1;
+WHERE email = $1 AND deleted_at IS NULL;
+
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1 AND deleted_at IS NULL;
+
+-- name: GetUserByHandle :one
+SELECT * FROM users
+WHERE lower(handle) = lower(sqlc.arg(handle)) AND deleted_at IS NULL;
+
+-- Fields left NULL keep their current value. A new email address has to be verified again (SET 
+-- expressions see the row's old values, so the comparison is with the current address):
+-- name: UpdateUserProfile :one
+UPDATE users
+SET
+    email_verified_at = CASE
+        WHEN sqlc.narg(email)::text IS NOT NULL AND sqlc.narg(email)::text <> email THEN NULL
+        ELSE email_verified_at
+    END,
+    email = COALESCE(sqlc.narg(email), email),
+    handle = COALESCE(sqlc.narg(handle), handle),
+    display_name = COALESCE(sqlc.narg(display_name), display_name),
+    bio = COALESCE(sqlc.narg(bio), bio),
+    avatar_url = COALESCE(sqlc.narg(avatar_url), avatar_url),
+    updated_at = NOW()
+WHERE id = sqlc.arg(id) AND deleted_at IS NULL
+RETURNING *;
+
+-- name: SoftDeleteUser :execrows
+UPDATE users
+SET deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL;
+
+-- name: RestoreUser :one
+UPDATE users
+SET deleted_at = NULL, updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NOT NULL
+RETURNING *;
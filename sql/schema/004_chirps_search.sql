@@ -0,0 +1,15 @@
+-- +goose Up
+-- A generated column is recomputed by Postgres whenever body changes, so the search vector can 
+-- never drift out of sync with the chirp text:
+ALTER TABLE chirps
+ADD COLUMN search_vector TSVECTOR
+GENERATED ALWAYS AS (to_tsvector('english', body)) STORED;
+
+-- A GIN index makes '@@' lookups against the search vector fast:
+CREATE INDEX chirps_search_vector_idx ON chirps USING GIN (search_vector);
+
+-- +goose Down
+DROP INDEX chirps_search_vector_idx;
+
+ALTER TABLE chirps
+DROP COLUMN search_vector;
@@ -0,0 +1,22 @@
+-- +goose Up
+-- One row per uploaded image. The bytes themselves live in the blob store under blob_key and 
+-- thumbnail_key; chirp_id stays NULL until the upload is attached to a chirp:
+CREATE TABLE media (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    chirp_id UUID REFERENCES chirps(id) ON DELETE CASCADE,
+    content_type TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    blob_key TEXT NOT NULL,
+    thumbnail_key TEXT NOT NULL,
+    thumbnail_content_type TEXT NOT NULL
+);
+
+CREATE INDEX media_chirp_id_idx ON media (chirp_id);
+
+-- +goose Down
+DROP TABLE media;
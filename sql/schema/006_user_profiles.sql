@@ -0,0 +1,25 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN handle TEXT,
+ADD COLUMN display_name TEXT NOT NULL DEFAULT '',
+ADD COLUMN bio TEXT NOT NULL DEFAULT '',
+ADD COLUMN avatar_url TEXT NOT NULL DEFAULT '';
+
+-- existing accounts get a placeholder handle derived from their ID, which they can change later:
+UPDATE users
+SET handle = 'user_' || substr(replace(id::text, '-', ''), 1, 10);
+
+ALTER TABLE users
+ALTER COLUMN handle SET NOT NULL;
+
+-- handles are unique regardless of case, so @Chirpy and @chirpy can't both exist:
+CREATE UNIQUE INDEX users_handle_lower_idx ON users (lower(handle));
+
+-- +goose Down
+DROP INDEX users_handle_lower_idx;
+
+ALTER TABLE users
+DROP COLUMN handle,
+DROP COLUMN display_name,
+DROP COLUMN bio,
+DROP COLUMN avatar_url;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- Rows are "tombstoned" by setting deleted_at instead of being deleted straight away. Every read 
+-- query skips tombstoned rows, an admin can clear deleted_at to restore them, and a background job 
+-- hard-deletes them once they're older than the retention period:
+ALTER TABLE users
+ADD COLUMN deleted_at TIMESTAMP;
+
+ALTER TABLE chirps
+ADD COLUMN deleted_at TIMESTAMP;
+
+-- partial indexes: only tombstoned rows are indexed, which is all the purge job looks for:
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX chirps_deleted_at_idx ON chirps (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX chirps_deleted_at_idx;
+DROP INDEX users_deleted_at_idx;
+
+ALTER TABLE chirps
+DROP COLUMN deleted_at;
+
+ALTER TABLE users
+DROP COLUMN deleted_at;
@@ -0,0 +1,24 @@
+-- +goose Up
+-- set when a user asks to delete their account; the deletion job acts on it once it's in the past, 
+-- and logging in before then clears it:
+ALTER TABLE users
+ADD COLUMN deletion_scheduled_at TIMESTAMP;
+
+-- Long-lived tokens used to get new access tokens. Only a SHA-256 hash of each token is stored, so 
+-- a database leak doesn't hand out working sessions:
+CREATE TABLE refresh_tokens (
+    token_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+-- +goose Down
+DROP TABLE refresh_tokens;
+
+ALTER TABLE users
+DROP COLUMN deletion_scheduled_at;
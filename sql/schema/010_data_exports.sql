@@ -0,0 +1,21 @@
+-- +goose Up
+-- One row per "download my data" request. The export job picks up pending rows, writes the ZIP to 
+-- the blob store and marks them ready; once expires_at passes the archive is deleted again:
+CREATE TABLE data_exports (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- pending, processing, ready, failed or expired:
+    status TEXT NOT NULL,
+    blob_key TEXT,
+    size_bytes BIGINT,
+    completed_at TIMESTAMP,
+    expires_at TIMESTAMP
+);
+
+CREATE INDEX data_exports_user_id_idx ON data_exports (user_id);
+CREATE INDEX data_exports_pending_idx ON data_exports (created_at) WHERE status IN ('pending', 'processing');
+
+-- +goose Down
+DROP TABLE data_exports;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- NULL until the user clicks the link we emailed them, and reset to NULL when they change address:
+ALTER TABLE users
+ADD COLUMN email_verified_at TIMESTAMP;
+
+-- Each token remembers the address it was sent to, so a link for an old address can't verify a new 
+-- one:
+CREATE TABLE email_verification_tokens (
+    token_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+CREATE INDEX email_verification_tokens_user_id_idx ON email_verification_tokens (user_id, created_at);
+
+-- +goose Down
+DROP TABLE email_verification_tokens;
+
+ALTER TABLE users
+DROP COLUMN email_verified_at;
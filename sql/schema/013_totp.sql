@@ -0,0 +1,27 @@
+-- +goose Up
+-- totp_secret is set when enrollment starts, but two-factor auth is only on once totp_enabled_at is 
+-- (i.e. after the user has proved their app works by entering a first code). The secret has to be 
+-- readable to check codes, so unlike passwords it can't be hashed. totp_last_step is the time step 
+-- of the last accepted code, which stops a code being used twice:
+ALTER TABLE users
+ADD COLUMN totp_secret TEXT,
+ADD COLUMN totp_enabled_at TIMESTAMP,
+ADD COLUMN totp_last_step BIGINT;
+
+-- Single-use fallback codes for when the user loses their authenticator; stored as SHA-256 hashes:
+CREATE TABLE recovery_codes (
+    code_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    used_at TIMESTAMP
+);
+
+CREATE INDEX recovery_codes_user_id_idx ON recovery_codes (user_id);
+
+-- +goose Down
+DROP TABLE recovery_codes;
+
+ALTER TABLE users
+DROP COLUMN totp_last_step,
+DROP COLUMN totp_enabled_at,
+DROP COLUMN totp_secret;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- Consecutive failed logins, keyed by "account:<email>" or "ip:<address>". Keying accounts by the 
+-- email that was typed (not the user ID) means unknown emails are throttled exactly like real ones, 
+-- so the responses don't reveal which accounts exist:
+CREATE TABLE login_failures (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMP NOT NULL,
+    blocked_until TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE login_failures;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- Which roles each user has been given; most users have none. What a role is allowed to do lives
+-- in the code (internal/rbac), so only the names are stored:
+CREATE TABLE user_roles (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL CHECK (role IN ('admin', 'moderator')),
+    granted_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    -- who granted it; NULL for roles granted at startup from ADMIN_EMAILS:
+    granted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    PRIMARY KEY (user_id, role)
+);
+
+-- +goose Down
+DROP TABLE user_roles;
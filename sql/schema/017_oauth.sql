@@ -0,0 +1,52 @@
+-- +goose Up
+-- Third-party apps registered to use Chirpy's OAuth server. Public clients (single-page and mobile
+-- apps, which can't keep a secret) have no secret_hash and rely on PKCE alone:
+CREATE TABLE oauth_clients (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    redirect_uris TEXT[] NOT NULL,
+    secret_hash TEXT
+);
+
+CREATE INDEX oauth_clients_owner_id_idx ON oauth_clients (owner_id);
+
+-- Every time a user approves an app they start a "grant". The authorization code and all the
+-- tokens that come from it share its grant_id, so the whole chain can be revoked at once (for
+-- example when a code or refresh token is replayed):
+CREATE TABLE oauth_authorization_codes (
+    code_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    grant_id UUID NOT NULL,
+    client_id UUID NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    code_challenge TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+-- Access and refresh tokens issued to clients. Like every other token, only a SHA-256 hash is kept:
+CREATE TABLE oauth_tokens (
+    token_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    -- access or refresh:
+    kind TEXT NOT NULL CHECK (kind IN ('access', 'refresh')),
+    grant_id UUID NOT NULL,
+    client_id UUID NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX oauth_tokens_grant_id_idx ON oauth_tokens (grant_id);
+CREATE INDEX oauth_tokens_user_id_idx ON oauth_tokens (user_id);
+
+-- +goose Down
+DROP TABLE oauth_tokens;
+DROP TABLE oauth_authorization_codes;
+DROP TABLE oauth_clients;
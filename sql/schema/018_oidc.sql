@@ -0,0 +1,29 @@
+-- +goose Up
+-- External accounts (from an OpenID Connect provider such as Google) linked to Chirpy users. The
+-- provider's (issuer, subject) pair is the stable identity; the email is only what it said last
+-- time, kept for the record:
+CREATE TABLE user_identities (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    last_login_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
+
+-- A login that has been sent off to the provider and not come back yet. The state in the callback
+-- finds the row (only its hash is stored); the nonce and PKCE verifier are needed to finish:
+CREATE TABLE oidc_login_states (
+    state_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE oidc_login_states;
+DROP TABLE user_identities;
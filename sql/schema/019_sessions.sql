@@ -0,0 +1,20 @@
+-- +goose Up
+-- Cookie sessions for the web app, so it never has to hold a bearer token where JavaScript (and so
+-- any injected script) can read it. The cookie carries a random token; only its hash is stored.
+-- The device details are only for showing the user where they're logged in:
+CREATE TABLE sessions (
+    id UUID PRIMARY KEY,
+    token_hash TEXT NOT NULL UNIQUE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    user_agent TEXT NOT NULL,
+    ip TEXT NOT NULL
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+
+-- +goose Down
+DROP TABLE sessions;
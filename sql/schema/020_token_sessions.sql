@@ -0,0 +1,20 @@
+-- +goose Up
+-- Logins that get tokens now have a session too, so every device shows up in the session list. Those
+-- sessions have no cookie, so no token_hash; their refresh token points at them instead, and ending
+-- the session revokes it:
+ALTER TABLE sessions
+ALTER COLUMN token_hash DROP NOT NULL;
+
+-- NULL for refresh tokens issued before sessions existed:
+ALTER TABLE refresh_tokens
+ADD COLUMN session_id UUID REFERENCES sessions(id) ON DELETE CASCADE;
+
+CREATE INDEX refresh_tokens_session_id_idx ON refresh_tokens (session_id);
+
+-- +goose Down
+ALTER TABLE refresh_tokens
+DROP COLUMN session_id;
+
+DELETE FROM sessions WHERE token_hash IS NULL;
+ALTER TABLE sessions
+ALTER COLUMN token_hash SET NOT NULL;
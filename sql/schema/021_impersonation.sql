@@ -0,0 +1,34 @@
+-- +goose Up
+-- Times an admin has acted as another user to reproduce a problem. The token they're given names
+-- its row, so ending the impersonation early stops the token working:
+CREATE TABLE impersonations (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    admin_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP
+);
+
+-- A record of security-sensitive things people have done. Rows are only ever added, and there are
+-- no foreign keys, so the record outlives the accounts it mentions:
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    -- who did it. For requests made while impersonating this is the admin, and on_behalf_of is
+    -- the user they were acting as:
+    actor_id UUID,
+    on_behalf_of UUID,
+    action TEXT NOT NULL,
+    -- what it was done to, e.g. "user:<id>":
+    target TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    details JSONB NOT NULL
+);
+
+CREATE INDEX audit_events_actor_id_idx ON audit_events (actor_id);
+
+-- +goose Down
+DROP TABLE audit_events;
+DROP TABLE impersonations;
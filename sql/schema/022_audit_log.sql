@@ -0,0 +1,47 @@
+-- +goose Up
+-- The audit log grows into the record of every security and admin event. Each row now carries the
+-- request it came from, what it changed, and a hash chaining it to the row before, so a row that's
+-- been edited or removed behind the app's back shows up when the chain is checked. Rows written
+-- before this migration have no hash and aren't part of the chain:
+ALTER TABLE audit_events
+ADD COLUMN request_id TEXT NOT NULL DEFAULT '',
+ADD COLUMN diff JSONB NOT NULL DEFAULT '{}',
+ADD COLUMN prev_hash TEXT,
+ADD COLUMN hash TEXT;
+
+CREATE INDEX audit_events_action_idx ON audit_events (action);
+CREATE INDEX audit_events_target_idx ON audit_events (target);
+CREATE INDEX audit_events_created_at_idx ON audit_events (created_at);
+
+-- refuse to change or delete rows, so even a bug (or a stray query in psql) can't rewrite history.
+-- Someone who can drop the trigger can get round this, which is what the hash chain is for:
+-- +goose StatementBegin
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER audit_events_no_update_or_delete
+BEFORE UPDATE OR DELETE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate
+BEFORE TRUNCATE ON audit_events
+FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+
+-- +goose Down
+DROP TRIGGER audit_events_no_truncate ON audit_events;
+DROP TRIGGER audit_events_no_update_or_delete ON audit_events;
+DROP FUNCTION audit_events_append_only();
+
+DROP INDEX audit_events_created_at_idx;
+DROP INDEX audit_events_target_idx;
+DROP INDEX audit_events_action_idx;
+
+ALTER TABLE audit_events
+DROP COLUMN hash,
+DROP COLUMN prev_hash,
+DROP COLUMN diff,
+DROP COLUMN request_id;
@@ -0,0 +1,10 @@
+-- +goose Up
+-- Whether the login that was sent off to the provider asked for a cookie session, so the callback
+-- can finish it the same way:
+ALTER TABLE oidc_login_states
+ADD COLUMN cookie_session BOOLEAN NOT NULL
+DEFAULT false;
+
+-- +goose Down
+ALTER TABLE oidc_login_states
+DROP COLUMN cookie_session;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- When an account was anonymized (see AnonymizeUser). The row stays for its chirps' sake, but
+-- nothing should find it as a person's account any more. Accounts anonymized before this column
+-- existed are recognised by the address AnonymizeUser gives them, and their external logins go:
+ALTER TABLE users
+ADD COLUMN anonymized_at TIMESTAMP;
+
+UPDATE users
+SET anonymized_at = updated_at
+WHERE email = 'deleted-' || id::text || '@deleted.invalid';
+
+DELETE FROM user_identities
+WHERE user_id IN (SELECT id FROM users WHERE anonymized_at IS NOT NULL);
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN anonymized_at;